@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/alecthomas/kong"
 	"github.com/joho/godotenv"
 	"github.com/olekukonko/tablewriter"
 	"github.com/ringmaster/Sn/sn"
+	"github.com/spf13/afero"
 	"github.com/spf13/viper"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/ssh/terminal"
@@ -27,6 +34,24 @@ var CLI struct {
 	} `cmd:"passwd" help:"Generate a bcrypt password hash. With --user, stores in config."`
 	RegenKeys struct {
 	} `cmd:"regen-keys" help:"Regenerate ActivityPub keys (removes existing encrypted keys)"`
+	Build struct {
+		Out string `default:"public" help:"The directory to write the site to"`
+	} `cmd:"build" help:"Export the site to static files"`
+	Db struct {
+		Migrate struct {
+		} `cmd:"migrate" help:"Apply pending schema migrations to the database"`
+		Status struct {
+		} `cmd:"status" help:"Show applied and pending schema migrations"`
+	} `cmd:"db" help:"Manage the schema of the database"`
+	Routes struct {
+		List struct {
+		} `cmd:"" default:"1" help:"List the routes in the order they are matched"`
+		Match struct {
+			Path   string `arg:"" help:"The URL path to match, with an optional query string"`
+			Method string `default:"GET" help:"The method of the request"`
+			Accept string `help:"The Accept header of the request"`
+		} `cmd:"match" help:"Show the route that answers a URL path, its path variables and item queries"`
+	} `cmd:"routes" help:"Inspect the route table without starting the server"`
 }
 
 func serve() {
@@ -35,6 +60,7 @@ func serve() {
 	if err == nil {
 		sn.RegisterTemplateHelpers()
 		sn.RegisterPartials()
+		sn.StartWatchingTemplates()
 
 		sn.DBConnect()
 		defer sn.DBClose()
@@ -62,9 +88,44 @@ func serve() {
 			}
 		}()
 
-		sn.DBLoadRepos()
+		// Item timestamps come from git history when serving from a git repo
+		if _, err := sn.LoadGitHistory(); err != nil {
+			slog.Error(fmt.Sprintf("Error while loading git history: %v", err))
+		}
+
+		// Load repos in the background so the server can start answering right away;
+		// each repo becomes visible all at once when its transaction commits.  A shutdown
+		// waits for the load to finish before the database is closed.
+		var loading sync.WaitGroup
+		loading.Add(1)
+		go func() {
+			defer loading.Done()
+			sn.LoadRepos()
+		}()
+		defer loading.Wait()
+
+		// Edits to the configuration file are validated and applied to the running server
+		stopConfigWatch := sn.ReloadOnConfigChange()
+		defer stopConfigWatch()
+
+		// SIGTERM and SIGINT drain the requests in flight before the deferred cleanup flushes
+		// ActivityPub storage and closes the database; SIGHUP reloads the configuration
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		defer stop()
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		defer signal.Stop(hangups)
+		go func() {
+			for range hangups {
+				if err := sn.Reload(); err != nil {
+					slog.Error(fmt.Sprintf("Error while reloading: %v", err))
+				}
+			}
+		}()
 
-		sn.WebserverStart()
+		if err := sn.WebserverStart(ctx); err != nil {
+			slog.Error(fmt.Sprintf("Error while serving: %v", err))
+		}
 	} else {
 		slog.Error(fmt.Sprintf("Error while setting up config: %v", err))
 	}
@@ -79,7 +140,8 @@ func sql(query string) {
 
 	sn.DBConnect()
 	defer sn.DBClose()
-	sn.DBLoadReposSync()
+	sn.LoadGitHistory()
+	sn.DBLoadRepos()
 	fmt.Printf("Running Query: %s\n", query)
 
 	rows, _ := sn.DBQuery(query)
@@ -187,6 +249,155 @@ func regenKeys() {
 	slog.Info("Note: Existing followers will need to re-follow your accounts")
 }
 
+func build(outDir string) {
+	_, err := sn.ConfigSetup()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error while setting up config: %v", err))
+		os.Exit(1)
+	}
+
+	sn.RegisterTemplateHelpers()
+	sn.RegisterPartials()
+
+	sn.DBConnect()
+	defer sn.DBClose()
+
+	if _, err := sn.LoadGitHistory(); err != nil {
+		slog.Error(fmt.Sprintf("Error while loading git history: %v", err))
+	}
+	sn.DBLoadRepos()
+
+	result := sn.BuildSite(afero.NewOsFs(), outDir)
+
+	if len(result.Unreachable) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Unreachable Route", "Reason"})
+		for _, unreachable := range result.Unreachable {
+			table.Append([]string{unreachable.Route, unreachable.Reason})
+		}
+		table.Render()
+	}
+	for _, err := range result.Errors {
+		slog.Error(fmt.Sprintf("Error while building: %v", err))
+	}
+	slog.Info(fmt.Sprintf("Wrote %d files to %s", len(result.Files), outDir))
+	if len(result.Errors) > 0 {
+		sn.DBClose()
+		os.Exit(1)
+	}
+}
+
+func dbMigrate() {
+	_, err := sn.ConfigSetup()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error while setting up config: %v", err))
+		return
+	}
+
+	sn.DBOpen()
+	defer sn.DBClose()
+
+	count, err := sn.DBMigrate()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error while migrating database: %v", err))
+		return
+	}
+	slog.Info(fmt.Sprintf("Applied %d migrations", count))
+}
+
+func dbStatus() {
+	_, err := sn.ConfigSetup()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error while setting up config: %v", err))
+		return
+	}
+
+	sn.DBOpen()
+	defer sn.DBClose()
+
+	statuses, err := sn.DBMigrationStatus()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error while reading migration status: %v", err))
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Version", "Name", "Status"})
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied && status.AppliedOn.IsZero() {
+			state = "applied (before migrations)"
+		} else if status.Applied {
+			state = "applied " + status.AppliedOn.Format("2006-01-02 15:04:05")
+		}
+		table.Append([]string{fmt.Sprint(status.Version), status.Name, state})
+	}
+	table.Render()
+}
+
+func routesList() {
+	_, err := sn.ConfigSetup()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error while setting up config: %v", err))
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"#", "Name", "Handler", "Path", "Methods"})
+	for i, route := range sn.RouteTable() {
+		table.Append([]string{fmt.Sprint(i + 1), route.Name, route.Handler, route.Path, strings.Join(route.Methods, ",")})
+	}
+	table.Render()
+}
+
+func routesMatch(path string, method string, accept string) {
+	_, err := sn.ConfigSetup()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error while setting up config: %v", err))
+		return
+	}
+
+	match, ok := sn.MatchRoute(path, strings.ToUpper(method), accept)
+	if !ok {
+		fmt.Printf("No route matches %s %s\n", strings.ToUpper(method), path)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Route:   %s\n", match.Route.Name)
+	fmt.Printf("Handler: %s\n", match.Route.Handler)
+	fmt.Printf("Path:    %s\n", match.Route.Path)
+	if len(match.Route.Methods) > 0 {
+		fmt.Printf("Methods: %s\n", strings.Join(match.Route.Methods, ","))
+	}
+
+	if len(match.Vars) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Path Variable", "Value"})
+		for _, name := range slices.Sorted(maps.Keys(match.Vars)) {
+			table.Append([]string{name, match.Vars[name]})
+		}
+		table.Render()
+	}
+
+	if len(match.Queries) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Query", "Field", "Value"})
+		for _, name := range slices.Sorted(maps.Keys(match.Queries)) {
+			for _, field := range slices.Sorted(maps.Keys(match.Queries[name])) {
+				table.Append([]string{name, field, fmt.Sprint(match.Queries[name][field])})
+			}
+		}
+		table.Render()
+	}
+
+	if len(match.Shadows) > 0 {
+		fmt.Println("Also matched by these later routes:")
+		for _, route := range match.Shadows {
+			fmt.Printf("  %s (%s) %s\n", route.Name, route.Handler, route.Path)
+		}
+	}
+}
+
 func main() {
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
 	err := godotenv.Load()
@@ -213,6 +424,16 @@ func main() {
 	case "regen-keys":
 		slog.Default().Info("regenerating ActivityPub keys")
 		regenKeys()
+	case "build":
+		build(CLI.Build.Out)
+	case "db migrate":
+		dbMigrate()
+	case "db status":
+		dbStatus()
+	case "routes", "routes list":
+		routesList()
+	case "routes match <path>":
+		routesMatch(CLI.Routes.Match.Path, CLI.Routes.Match.Method, CLI.Routes.Match.Accept)
 	default:
 		fmt.Println(ctx.Command())
 	}
@@ -18,6 +18,7 @@ type InboxService struct {
 	keyManager   *KeyManager
 	actorService *ActorService
 	db           *sql.DB
+	onComment    func(*Comment)
 }
 
 // NewInboxService creates a new inbox service
@@ -472,6 +473,10 @@ func (is *InboxService) handleCreateNote(activity *Activity, objectMap map[strin
 		}
 	}
 
+	if is.onComment != nil {
+		is.onComment(comment)
+	}
+
 	slog.Info("Comment created", "id", comment.ID, "author", authorName, "post", postSlug)
 	return nil
 }
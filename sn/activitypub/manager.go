@@ -98,6 +98,13 @@ func (m *Manager) IsEnabled() bool {
 	return m.enabled
 }
 
+// NewRouteManager returns a manager without services that registers the configured
+// ActivityPub routes, so that they can be listed without opening storage or loading keys.
+// Its handlers must not be served.
+func NewRouteManager() *Manager {
+	return &Manager{enabled: viper.GetBool("activitypub.enabled")}
+}
+
 // RegisterRoutes registers ActivityPub routes with the provided router
 func (m *Manager) RegisterRoutes(router *mux.Router) {
 	if !m.enabled {
@@ -242,6 +249,14 @@ func (m *Manager) DeletePost(postURL, repo string) error {
 	return m.outboxService.DeletePost(postURL, repo, baseURL)
 }
 
+// OnComment registers a function that is called after a new comment has been stored
+func (m *Manager) OnComment(fn func(*Comment)) {
+	if !m.enabled {
+		return
+	}
+	m.inboxService.onComment = fn
+}
+
 // GetComments returns comments for a specific post
 func (m *Manager) GetComments(repo, slug string) ([]*Comment, error) {
 	if !m.enabled {
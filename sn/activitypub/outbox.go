@@ -231,7 +231,7 @@ func (os *OutboxService) UpdatePost(post *BlogPost, baseURL string) error {
 			URL:          post.URL,
 			AttributedTo: attribution, // Can be string or []string
 			Published:    post.PublishedAt.Format(time.RFC3339),
-			Updated:      time.Now().Format(time.RFC3339),
+			Updated:      postUpdatedAt(post).Format(time.RFC3339),
 			To:           []string{"https://www.w3.org/ns/activitystreams#Public"},
 			CC:           buildFollowersCC(post, baseURL), // Include all authors' followers
 			Tag:          convertTagsToActivityPub(post.Tags),
@@ -783,6 +783,14 @@ func getRepoOwner(repo string) string {
 	return ""
 }
 
+// postUpdatedAt returns when a post was last modified, or now if that is unknown
+func postUpdatedAt(post *BlogPost) time.Time {
+	if post.UpdatedAt.IsZero() {
+		return time.Now()
+	}
+	return post.UpdatedAt
+}
+
 // getPostPrimaryAuthor gets the primary author for a post, with fallbacks
 func getPostPrimaryAuthor(post *BlogPost) string {
 	// First, try to use the post's actual authors
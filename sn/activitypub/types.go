@@ -282,6 +282,7 @@ type BlogPost struct {
 	MarkdownContent string
 	Summary         string
 	PublishedAt     time.Time
+	UpdatedAt       time.Time
 	Tags            []string
 	Authors         []string // Post authors from frontmatter
 	Repo            string
@@ -0,0 +1,462 @@
+package sn
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ringmaster/Sn/sn/util"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// BuildResult reports the files written by BuildSite and the routes it could not export
+type BuildResult struct {
+	Files       []string
+	Unreachable []UnreachableRoute
+	Errors      []error
+}
+
+// UnreachableRoute is a route, or part of one, that has no static equivalent
+type UnreachableRoute struct {
+	Route  string
+	Reason string
+}
+
+// routeVariable matches the {name} and {name:pattern} variables of a route path
+var routeVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// siteBuilder renders the routes of the site through the router into an output directory
+type siteBuilder struct {
+	out     afero.Fs
+	outDir  string
+	router  *mux.Router
+	written map[string]bool // Request paths already rendered
+	result  BuildResult
+}
+
+// BuildSite renders every URL reachable from the route definitions into outDir on out.
+// Listing pages are rendered for each page, tag and author their queries can select,
+// every item is rendered at its GetItemURL, and static routes are copied.  The repos
+// must already be loaded into the database.
+func BuildSite(out afero.Fs, outDir string) BuildResult {
+	b := &siteBuilder{
+		out:     out,
+		outDir:  outDir,
+		router:  NewRouter(),
+		written: make(map[string]bool),
+	}
+	// Helpers such as pageurl match the page being rendered against the global router
+	previous := router.Swap(b.router)
+	defer router.Store(previous)
+
+	for _, routeName := range sortedRouteNames() {
+		b.buildRoute(routeName)
+	}
+	b.buildItems()
+
+	return b.result
+}
+
+func (b *siteBuilder) unreachable(routeName string, reason string) {
+	b.result.Unreachable = append(b.result.Unreachable, UnreachableRoute{Route: routeName, Reason: reason})
+}
+
+func (b *siteBuilder) buildRoute(routeName string) {
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+	routePath := viper.GetString(fmt.Sprintf("%s.path", routeConfigLocation))
+
+	switch handler := viper.GetString(fmt.Sprintf("%s.handler", routeConfigLocation)); handler {
+	case "static":
+		b.copyStatic(routeName)
+	case "ogimage":
+		b.buildSocialCards(routeName)
+	case "frontend", "upload", "git", "debug", "websub":
+		b.unreachable(routeName, fmt.Sprintf("the %s handler needs a running server", handler))
+	case "redirect":
+		if routeVariable.MatchString(routePath) {
+			b.unreachable(routeName, "redirects with path variables need a running server")
+			return
+		}
+		to := viper.GetString(fmt.Sprintf("%s.to", routeConfigLocation))
+		b.writeFile(path.Join(routePath, "index.html"), []byte(redirectPage(to)))
+	case "feed":
+		b.buildFeedRoute(routeName)
+	case "ical":
+		b.buildICalRoute(routeName)
+	default:
+		if viper.GetInt(fmt.Sprintf("%s.http_status", routeConfigLocation)) == http.StatusNotFound {
+			b.renderNotFound(routeName)
+			return
+		}
+		b.buildTemplateRoute(routeName)
+	}
+}
+
+// buildTemplateRoute renders a templated route for every value its path variables can take
+func (b *siteBuilder) buildTemplateRoute(routeName string) {
+	for _, query := range routeOutQueries(routeName) {
+		if match := paramReference.FindStringSubmatch(fmt.Sprint(query)); match != nil {
+			b.unreachable(routeName, fmt.Sprintf("the content depends on the query parameter %s", match[1]))
+			return
+		}
+	}
+
+	if b.buildPages(routeName) {
+		b.unreachable(routeName, "pages after the first are selected by a query parameter; use a path variable named by paginate_name to export them")
+	}
+}
+
+// buildFeedRoute renders a feed for every value its path variables can take, such as the
+// feed of every tag, and every page of a feed paginated by a path variable
+func (b *siteBuilder) buildFeedRoute(routeName string) {
+	if b.buildPages(routeName) {
+		b.unreachable(routeName, "feed pages after the first are selected by a query parameter; use a path variable named by paginate_name to export them")
+	}
+}
+
+// buildPages renders a route for every value its path variables can take, and every page
+// paginated by the path variable named by paginate_name.  It returns whether any of the
+// rendered URLs has more pages, selected by a query parameter, that cannot be exported.
+func (b *siteBuilder) buildPages(routeName string) bool {
+	combinations, pageVar, ok := b.routeVarCombinations(routeName)
+	if !ok {
+		return false
+	}
+
+	queryPaginated := false
+	for _, vars := range combinations {
+		pages := 1
+		if pageVar != "" {
+			pages = b.pageCount(routeName, vars)
+		} else if b.pageCount(routeName, vars) > 1 {
+			queryPaginated = true
+		}
+
+		for page := 1; page <= pages; page++ {
+			if pageVar != "" {
+				vars[pageVar] = strconv.Itoa(page)
+			}
+			b.renderVars(routeName, vars)
+		}
+	}
+	return queryPaginated
+}
+
+// buildICalRoute renders a calendar for every value its path variables can take that has
+// events, such as every tag or every event
+func (b *siteBuilder) buildICalRoute(routeName string) {
+	combinations, _, ok := b.routeVarCombinations(routeName)
+	if !ok {
+		return
+	}
+	for _, vars := range combinations {
+		if len(vars) == 0 || len(icalEvents(routeName, vars)) > 0 {
+			b.renderVars(routeName, vars)
+		}
+	}
+}
+
+// routeVarCombinations lists every combination of the values of a route's path variables,
+// except the one named by its paginate_name, which it returns
+func (b *siteBuilder) routeVarCombinations(routeName string) ([]map[string]string, string, bool) {
+	routePath := viper.GetString(fmt.Sprintf("routes.%s.path", routeName))
+
+	pageVar := ""
+	combinations := []map[string]string{{}}
+	for _, match := range routeVariable.FindAllStringSubmatch(routePath, -1) {
+		name := match[1]
+		if b.isPaginateName(routeName, name) {
+			pageVar = name
+			continue
+		}
+		values, ok := b.routeVarValues(routeName, name)
+		if !ok {
+			b.unreachable(routeName, fmt.Sprintf("no values can be listed for {%s}", name))
+			return nil, "", false
+		}
+		expanded := make([]map[string]string, 0, len(combinations)*len(values))
+		for _, combination := range combinations {
+			for _, value := range values {
+				vars := maps.Clone(combination)
+				vars[name] = value
+				expanded = append(expanded, vars)
+			}
+		}
+		combinations = expanded
+	}
+	return combinations, pageVar, true
+}
+
+// renderVars renders the URL of a route with its path variables set to vars
+func (b *siteBuilder) renderVars(routeName string, vars map[string]string) {
+	pairs := make([]string, 0, len(vars)*2)
+	for name, value := range vars {
+		pairs = append(pairs, name, value)
+	}
+	u, err := b.router.Get(routeName).URLPath(pairs...)
+	if err != nil {
+		b.result.Errors = append(b.result.Errors, fmt.Errorf("route %s: %w", routeName, err))
+		return
+	}
+	b.render(routeName, u.Path)
+}
+
+func (b *siteBuilder) isPaginateName(routeName string, name string) bool {
+	for _, query := range routeOutQueries(routeName) {
+		if paginateName, ok := query["paginate_name"].(string); ok && paginateName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// routeVarValues lists the values of a path variable from the item query field that uses it
+func (b *siteBuilder) routeVarValues(routeName string, name string) ([]string, bool) {
+	reference := fmt.Sprintf("{%s}", name)
+	for _, query := range routeOutQueries(routeName) {
+		repo, _ := query["repo"].(string)
+		for field, value := range query {
+			if fmt.Sprint(value) != reference {
+				continue
+			}
+			switch field {
+			case "slug":
+				return b.queryValues("SELECT slug FROM items WHERE ? = '' OR repo = ? ORDER BY slug", repo), true
+			case "tag", "category":
+				return b.queryValues(`SELECT DISTINCT category FROM categories
+					INNER JOIN items_categories ON items_categories.category_id = categories.id
+					INNER JOIN items ON items.id = items_categories.item_id
+					WHERE ? = '' OR repo = ? ORDER BY category`, repo), true
+			case "author":
+				return b.queryValues(`SELECT DISTINCT author FROM authors
+					INNER JOIN items_authors ON items_authors.author_id = authors.id
+					INNER JOIN items ON items.id = items_authors.item_id
+					WHERE ? = '' OR repo = ? ORDER BY author`, repo), true
+			case "repo":
+				repos := make([]string, 0)
+				for repoName := range viper.GetStringMap("repos") {
+					repos = append(repos, repoName)
+				}
+				sort.Strings(repos)
+				return repos, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (b *siteBuilder) queryValues(query string, repo string) []string {
+	values := make([]string, 0)
+	rows, err := db.Query(query, repo, repo)
+	if err != nil {
+		b.result.Errors = append(b.result.Errors, fmt.Errorf("error listing route values: %w", err))
+		return values
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err == nil && value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// pageCount returns the largest number of pages of the route's item queries
+func (b *siteBuilder) pageCount(routeName string, vars map[string]string) int {
+	pages := 1
+	for _, query := range routeOutQueries(routeName) {
+		context := map[string]interface{}{
+			"pathvars": maps.Clone(vars),
+			"params":   url.Values{},
+		}
+		pages = MaxOf(pages, ItemsFromOutvals(maps.Clone(query), context).Pages)
+	}
+	return pages
+}
+
+// buildItems renders every item at the URL GetItemURL links to
+func (b *siteBuilder) buildItems() {
+	rows, err := db.Query("SELECT slug, repo, title FROM items ORDER BY repo, slug")
+	if err != nil {
+		b.result.Errors = append(b.result.Errors, fmt.Errorf("error listing items: %w", err))
+		return
+	}
+	items := make([]Item, 0)
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.Slug, &item.Repo, &item.Title); err == nil {
+			items = append(items, item)
+		}
+	}
+	rows.Close()
+
+	rootPath := ""
+	if root, err := url.Parse(viper.GetString("rooturl")); err == nil {
+		rootPath = strings.TrimSuffix(root.Path, "/")
+	}
+	for _, item := range items {
+		itemURL, err := url.Parse(util.GetItemURL(item))
+		if err != nil {
+			b.result.Errors = append(b.result.Errors, fmt.Errorf("item %s/%s has an invalid URL: %w", item.Repo, item.Slug, err))
+			continue
+		}
+		itemPath := strings.TrimPrefix(itemURL.Path, rootPath)
+		b.render(b.servingRoute(itemPath, item.Repo), itemPath)
+	}
+}
+
+// servingRoute returns the name of the route that answers a path, or fallback when no
+// named route does
+func (b *siteBuilder) servingRoute(requestPath string, fallback string) string {
+	req, err := siteRequest(requestPath)
+	if err != nil {
+		return fallback
+	}
+	var match mux.RouteMatch
+	if b.router.Match(req, &match) && match.Route != nil && match.Route.GetName() != "" {
+		return match.Route.GetName()
+	}
+	return fallback
+}
+
+// buildSocialCards renders the social card image of every item of a public repo
+func (b *siteBuilder) buildSocialCards(routeName string) {
+	rows, err := db.Query("SELECT slug, repo FROM items ORDER BY repo, slug")
+	if err != nil {
+		b.result.Errors = append(b.result.Errors, fmt.Errorf("error listing items: %w", err))
+		return
+	}
+	items := make([]Item, 0)
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.Slug, &item.Repo); err == nil && repoIsPublic(item.Repo) {
+			items = append(items, item)
+		}
+	}
+	rows.Close()
+
+	for _, item := range items {
+		cardPath, ok := socialCardPath(item)
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(cardPath); err == nil {
+			b.render(routeName, unescaped)
+		}
+	}
+}
+
+// render requests a path from the router and writes the response to the output directory
+func (b *siteBuilder) render(routeName string, requestPath string) {
+	if b.written[requestPath] {
+		return
+	}
+	b.written[requestPath] = true
+
+	req, err := siteRequest(requestPath)
+	if err != nil {
+		b.result.Errors = append(b.result.Errors, fmt.Errorf("route %s: %w", routeName, err))
+		return
+	}
+	rec := newResponseBuffer()
+	b.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		b.result.Errors = append(b.result.Errors, fmt.Errorf("route %s: %s returned status %d", routeName, requestPath, rec.Code))
+		return
+	}
+
+	filename := path.Clean("/" + requestPath)
+	if strings.HasSuffix(requestPath, "/") || (path.Ext(filename) == "" && strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html")) {
+		filename = path.Join(filename, "index.html")
+	}
+	b.writeFile(filename, rec.Body.Bytes())
+}
+
+// renderNotFound renders a route that answers with 404 to the 404.html that static hosts serve
+func (b *siteBuilder) renderNotFound(routeName string) {
+	req, err := siteRequest("/404.html")
+	if err != nil {
+		b.result.Errors = append(b.result.Errors, fmt.Errorf("route %s: %w", routeName, err))
+		return
+	}
+	req = mux.SetURLVars(req, map[string]string{})
+	rec := newResponseBuffer()
+	templateHandler(rec, req, routeName)
+	b.writeFile("/404.html", rec.Body.Bytes())
+}
+
+// copyStatic copies the file of a static route from the Vfs, or its directory merged with
+// the theme's static files
+func (b *siteBuilder) copyStatic(routeName string) {
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+	routePath := viper.GetString(fmt.Sprintf("%s.path", routeConfigLocation))
+
+	if viper.IsSet(fmt.Sprintf("%s.file", routeConfigLocation)) {
+		file := ConfigPath(fmt.Sprintf("%s.file", routeConfigLocation), OptionallyExist())
+		content, err := afero.ReadFile(Vfs, file)
+		if err != nil {
+			b.result.Errors = append(b.result.Errors, fmt.Errorf("route %s: %w", routeName, err))
+			return
+		}
+		b.writeFile(routePath, content)
+		return
+	}
+
+	staticFs := ThemeFs(themeStatic, ConfigPath(fmt.Sprintf("%s.dir", routeConfigLocation), OptionallyExist()))
+	err := afero.Walk(staticFs, "/", func(filename string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := afero.ReadFile(staticFs, filename)
+		if err != nil {
+			return err
+		}
+		b.writeFile(path.Join(routePath, filepath.ToSlash(filename)), content)
+		return nil
+	})
+	if err != nil {
+		b.result.Errors = append(b.result.Errors, fmt.Errorf("route %s: %w", routeName, err))
+	}
+}
+
+func (b *siteBuilder) writeFile(filename string, content []byte) {
+	target := filepath.Join(b.outDir, filepath.FromSlash(path.Clean("/"+filename)))
+	if err := b.out.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		b.result.Errors = append(b.result.Errors, err)
+		return
+	}
+	if err := afero.WriteFile(b.out, target, content, 0644); err != nil {
+		b.result.Errors = append(b.result.Errors, err)
+		return
+	}
+	slog.Debug("Wrote file", "file", target)
+	b.result.Files = append(b.result.Files, target)
+}
+
+// siteRequest returns a request for a path of the site, or an error for one that does not
+// start at the root
+func siteRequest(requestPath string) (*http.Request, error) {
+	if !strings.HasPrefix(requestPath, "/") {
+		return nil, fmt.Errorf("%q is not a path of the site", requestPath)
+	}
+	return newSiteRequest(context.Background(), (&url.URL{Path: requestPath}).String())
+}
+
+// redirectPage is a page that sends browsers on to another URL without a server
+func redirectPage(to string) string {
+	return fmt.Sprintf(`<!DOCTYPE html><html><head><meta charset="utf-8"><meta http-equiv="refresh" content="0; url=%[1]s"><link rel="canonical" href="%[1]s"></head><body><a href="%[1]s">%[1]s</a></body></html>`, html.EscapeString(to))
+}
@@ -0,0 +1,138 @@
+package sn
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+func TestBuildSite(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md":         "---\ntitle: One\ntags: [go]\n---\n\nFirst post.",
+		"/blog/two.md":         "---\ntitle: Two\ntags: [go, sqlite]\n---\n\nSecond post.",
+		"/blog/three.md":       "---\ntitle: Three\n---\n\nThird post.",
+		"/tpl/list.html.hb":    "{{#each posts.Items}}{{title}};{{/each}}",
+		"/tpl/post.html.hb":    "{{#each posts.Items}}<h1>{{title}}</h1>{{/each}}",
+		"/tpl/404.html.hb":     "Not found",
+		"/static/css/site.css": "body {}",
+		"/static/img/logo.svg": "<svg/>",
+	})
+	DBLoadRepo("blog")
+
+	viper.Set("template_dir", "/tpl")
+	viper.Set("routes.01_index.path", "/")
+	viper.Set("routes.01_index.handler", "posts")
+	viper.Set("routes.01_index.templates", []string{"list.html.hb"})
+	viper.Set("routes.01_index.out.posts", map[string]interface{}{"repo": "blog", "paginate_count": 2})
+	viper.Set("routes.02_pages.path", "/page/{page:[0-9]+}")
+	viper.Set("routes.02_pages.handler", "posts")
+	viper.Set("routes.02_pages.templates", []string{"list.html.hb"})
+	viper.Set("routes.02_pages.out.posts", map[string]interface{}{"repo": "blog", "paginate_name": "page", "paginate_count": 2})
+	viper.Set("routes.03_tags.path", "/tag/{tag}")
+	viper.Set("routes.03_tags.handler", "posts")
+	viper.Set("routes.03_tags.templates", []string{"list.html.hb"})
+	viper.Set("routes.03_tags.out.posts", map[string]interface{}{"repo": "blog", "tag": "{tag}"})
+	viper.Set("routes.04_posts.path", "/posts/{slug}")
+	viper.Set("routes.04_posts.handler", "posts")
+	viper.Set("routes.04_posts.templates", []string{"post.html.hb"})
+	viper.Set("routes.04_posts.out.posts", map[string]interface{}{"repo": "blog", "slug": "{slug}"})
+	viper.Set("routes.05_static.path", "/static")
+	viper.Set("routes.05_static.handler", "static")
+	viper.Set("routes.05_static.dir", "/static")
+	viper.Set("routes.06_old.path", "/old")
+	viper.Set("routes.06_old.handler", "redirect")
+	viper.Set("routes.06_old.to", "/")
+	viper.Set("routes.07_debug.path", "/_/debug")
+	viper.Set("routes.07_debug.handler", "debug")
+	viper.Set("routes.08_tagfeed.path", "/tag/{tag}/feed.xml")
+	viper.Set("routes.08_tagfeed.handler", "feed")
+	viper.Set("routes.08_tagfeed.feed", map[string]interface{}{"repo": "blog"})
+	viper.Set("routes.fof.path", "/{any:.*}")
+	viper.Set("routes.fof.handler", "posts")
+	viper.Set("routes.fof.http_status", 404)
+	viper.Set("routes.fof.templates", []string{"404.html.hb"})
+	RegisterPartials()
+
+	out := afero.NewMemMapFs()
+	previous := router.Load()
+	result := BuildSite(out, "/public")
+
+	if router.Load() != previous {
+		t.Error("Expected the build to restore the server's router")
+	}
+
+	if len(result.Errors) != 0 {
+		t.Errorf("Expected no errors, got %v", result.Errors)
+	}
+
+	expected := map[string]string{
+		"/public/index.html":             "",
+		"/public/page/1/index.html":      "",
+		"/public/page/2/index.html":      "",
+		"/public/tag/go/index.html":      "",
+		"/public/tag/sqlite/index.html":  "Two;",
+		"/public/posts/one/index.html":   "<h1>One</h1>",
+		"/public/posts/three/index.html": "<h1>Three</h1>",
+		"/public/static/css/site.css":    "body {}",
+		"/public/static/img/logo.svg":    "<svg/>",
+		"/public/old/index.html":         "",
+		"/public/tag/go/feed.xml":        "",
+		"/public/tag/sqlite/feed.xml":    "",
+		"/public/404.html":               "Not found",
+	}
+	for filename, content := range expected {
+		written, err := afero.ReadFile(out, filename)
+		if err != nil {
+			t.Errorf("Expected %s to be written", filename)
+			continue
+		}
+		if content != "" && string(written) != content {
+			t.Errorf("%s = %q, want %q", filename, written, content)
+		}
+	}
+	if exists, _ := afero.Exists(out, "/public/page/3/index.html"); exists {
+		t.Error("Expected pagination to stop at the last page")
+	}
+
+	unreachable := make(map[string]bool)
+	for _, route := range result.Unreachable {
+		unreachable[route.Route] = true
+	}
+	if !unreachable["01_index"] || !unreachable["07_debug"] || len(unreachable) != 2 {
+		t.Errorf("Expected 01_index (query pagination) and 07_debug to be unreachable, got %v", result.Unreachable)
+	}
+}
+
+func TestBuildSite_ServingRoute(t *testing.T) {
+	setupLoaderTest(t, nil)
+	viper.Set("routes.posts.path", "/posts/{slug}")
+	viper.Set("routes.posts.handler", "posts")
+	previous := router.Load()
+	b := &siteBuilder{router: NewRouter()}
+	if router.Load() != previous {
+		t.Error("Expected NewRouter to leave the server's router in place")
+	}
+
+	if route := b.servingRoute("/posts/one", "blog"); route != "posts" {
+		t.Errorf("servingRoute(/posts/one) = %q, want posts", route)
+	}
+	if route := b.servingRoute("/elsewhere/one", "blog"); route != "blog" {
+		t.Errorf("servingRoute(/elsewhere/one) = %q, want the fallback blog", route)
+	}
+}
+
+func TestBuildSite_RenderRelativePath(t *testing.T) {
+	setupLoaderTest(t, nil)
+	viper.Set("routes.posts.path", "/posts/{slug}")
+	viper.Set("routes.posts.handler", "posts")
+	b := &siteBuilder{out: afero.NewMemMapFs(), outDir: "/public", router: NewRouter(), written: make(map[string]bool)}
+
+	if route := b.servingRoute("posts/one", "blog"); route != "blog" {
+		t.Errorf("servingRoute(posts/one) = %q, want the fallback blog", route)
+	}
+	b.render("blog", "posts/one")
+	if len(b.result.Errors) != 1 || len(b.result.Files) != 0 {
+		t.Errorf("Expected an error and no file for a path that does not start at the root, got %v", b.result)
+	}
+}
@@ -78,7 +78,6 @@ func ConfigSetup() (afero.Fs, error) {
 		return nil, err
 	}
 
-	viper.WatchConfig()
 	if err := viper.ReadInConfig(); err != nil {
 		// Output the files in the root of the virtual filesystem
 		files, err2 := afero.ReadDir(Vfs, "/")
@@ -113,6 +112,9 @@ func InitializeActivityPub() error {
 		slog.Error(fmt.Sprintf("Failed to initialize ActivityPub: %v", err))
 		return err
 	}
+	ActivityPubManager.OnComment(func(comment *activitypub.Comment) {
+		InvalidateCommentPages(comment.PostRepo, comment.PostSlug)
+	})
 	return nil
 }
 
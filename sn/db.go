@@ -13,9 +13,11 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -40,175 +42,132 @@ import (
 
 var db *sql.DB
 
-func schema() string {
-	return `
-	CREATE TABLE IF NOT EXISTS "items" (
-		"id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
-		"slug" varchar(255) NOT NULL,
-		"repo" varchar(255) NOT NULL,
-		"publishedon" timestamp(128),
-		"rawpublishedon" varchar(128),
-		"raw" text(128),
-		"html" text(128),
-		"source" varchar(128),
-		"title" varchar(255) NOT NULL,
-		"frontmatter" text(128)
-	  );
-
-	  CREATE INDEX IF NOT EXISTS items_repo ON "items" ("repo" ASC);
-
-	  CREATE UNIQUE INDEX IF NOT EXISTS items_repo_slug ON "items" ("slug" ASC, "repo" ASC);
-
-	  CREATE INDEX IF NOT EXISTS items_published_on ON "items" ("publishedon" ASC);
-
-	  CREATE TABLE IF NOT EXISTS "authors" (
-		"id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
-		"author" varchar(128)
-	  );
-
-	  CREATE UNIQUE INDEX IF NOT EXISTS authors_author ON "authors" ("author" ASC);
-
-	  CREATE TABLE IF NOT EXISTS "categories" (
-		"id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
-		"category" varchar(128) NOT NULL
-	  );
-
-	  CREATE UNIQUE INDEX IF NOT EXISTS categories_category ON "categories" ("category" ASC);
-
-	  CREATE TABLE IF NOT EXISTS "frontmatter" (
-		"id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
-		"item_id" integer(128) NOT NULL,
-		"fieldname" varchar(255) NOT NULL,
-		"value" text(128),
-		FOREIGN KEY (item_id) REFERENCES "items" (id)
-	  );
-
-	  CREATE INDEX IF NOT EXISTS frontmatter_fieldname ON "frontmatter" ("fieldname" ASC);
-
-	  CREATE INDEX IF NOT EXISTS frontmatter_item_id ON "frontmatter" ("item_id" ASC);
-
-	  CREATE INDEX IF NOT EXISTS frontmatter_fieldname_value ON "frontmatter" ("fieldname" ASC, "value" ASC);
-
-	  CREATE TABLE IF NOT EXISTS "items_authors" (
-		"id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
-		"item_id" integer(128) NOT NULL,
-		"author_id" integer(128),
-		FOREIGN KEY (item_id) REFERENCES "items" (id),
-		FOREIGN KEY (author_id) REFERENCES "authors" (id)
-	  );
-
-	  CREATE INDEX IF NOT EXISTS items_authors_author_id ON "items_authors" ("author_id" ASC);
-
-	  CREATE UNIQUE INDEX IF NOT EXISTS items_authors_item_id_author_id ON "items_authors" ("item_id" ASC, "author_id" ASC);
-
-	  CREATE INDEX IF NOT EXISTS iterms_authors_item_id ON "items_authors" ("item_id" ASC);
-
-	  CREATE TABLE IF NOT EXISTS "items_categories" (
-		"id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
-		"item_id" integer(128),
-		"category_id" integer(128),
-		FOREIGN KEY (item_id) REFERENCES "items" (id),
-		FOREIGN KEY (category_id) REFERENCES "categories" (id)
-	  );
-
-	  CREATE INDEX IF NOT EXISTS items_categories_item_id ON "items_categories" ("item_id" ASC);
-
-	  CREATE INDEX IF NOT EXISTS items_categories_category_id ON "items_categories" ("category_id" ASC);
-
-	  CREATE TABLE IF NOT EXISTS "comments" (
-		"id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
-		"comment_id" varchar(255) NOT NULL,
-		"activity_id" varchar(255),
-		"in_reply_to" varchar(255) NOT NULL,
-		"author" varchar(255) NOT NULL,
-		"author_name" varchar(255),
-		"author_url" varchar(255),
-		"content" text NOT NULL,
-		"content_html" text,
-		"published" timestamp,
-		"updated" timestamp,
-		"verified" boolean DEFAULT 0,
-		"approved" boolean DEFAULT 1,
-		"hidden" boolean DEFAULT 0,
-		"post_slug" varchar(255) NOT NULL,
-		"post_repo" varchar(255) NOT NULL,
-		"item_id" integer,
-		FOREIGN KEY (item_id) REFERENCES "items" (id)
-	  );
-
-	  CREATE UNIQUE INDEX IF NOT EXISTS comments_comment_id ON "comments" ("comment_id");
-	  CREATE INDEX IF NOT EXISTS comments_post ON "comments" ("post_repo", "post_slug");
-	  CREATE INDEX IF NOT EXISTS comments_published ON "comments" ("published" DESC);
-	`
+// stmtCacheLimit bounds the number of distinct queries kept as prepared statements
+const stmtCacheLimit = 256
+
+// stmtCache holds prepared statements for the queries run on every request.
+// A *sql.Stmt is safe for concurrent use, so the statements are shared between requests.
+var (
+	stmtCache     = make(map[string]*sql.Stmt)
+	stmtCacheLock sync.RWMutex
+)
+
+// cachedStmt returns a prepared statement for a query, or nil if the cache is full
+func cachedStmt(query string) (*sql.Stmt, error) {
+	stmtCacheLock.RLock()
+	stmt, ok := stmtCache[query]
+	stmtCacheLock.RUnlock()
+	if ok {
+		return stmt, nil
+	}
+
+	stmtCacheLock.Lock()
+	defer stmtCacheLock.Unlock()
+	if stmt, ok := stmtCache[query]; ok {
+		return stmt, nil
+	}
+	if len(stmtCache) >= stmtCacheLimit {
+		return nil, nil
+	}
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	stmtCache[query] = stmt
+	return stmt, nil
+}
+
+// dbQueryCached runs a query through a cached prepared statement
+func dbQueryCached(query string, args ...any) (*sql.Rows, error) {
+	stmt, err := cachedStmt(query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return db.Query(query, args...)
+	}
+	return stmt.Query(args...)
 }
 
+// dbQueryRowCached runs a single row query through a cached prepared statement
+func dbQueryRowCached(query string, args ...any) *sql.Row {
+	stmt, err := cachedStmt(query)
+	if err != nil || stmt == nil {
+		return db.QueryRow(query, args...)
+	}
+	return stmt.QueryRow(args...)
+}
+
+// resetStmtCache closes the cached statements, which belong to the current connection
+func resetStmtCache() {
+	stmtCacheLock.Lock()
+	defer stmtCacheLock.Unlock()
+	for query, stmt := range stmtCache {
+		stmt.Close()
+		delete(stmtCache, query)
+	}
+}
+
+// DBConnect opens the database and applies any pending schema migrations
 func DBConnect() {
-	var dburi string
-	dbfile := ConfigPath("dbfile", WithDefault(":memory:"), OptionallyExist())
+	DBOpen()
 
+	if _, err := DBMigrate(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// dbURI returns the SQLite URI of the configured dbfile, which is a path or a file: URI.  A
+// database file is opened in WAL mode, so requests keep reading the committed items while a
+// repo is being reloaded.
+func dbURI(dbfile string) string {
 	if dbfile == ":memory:" {
-		dburi = "file:sn?mode=memory&cache=shared"
-	} else {
-		dburi = fmt.Sprintf("file:%s", dbfile)
+		return "file:sn?mode=memory&cache=shared"
+	}
+	dburi := dbfile
+	if !strings.HasPrefix(dburi, "file:") {
+		dburi = "file:" + dburi
+	}
+	if strings.Contains(dburi, "mode=memory") {
+		return dburi
 	}
+	separator := "?"
+	if strings.Contains(dburi, "?") {
+		separator = "&"
+	}
+	return dburi + separator + "_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+}
+
+// DBOpen opens the database without changing its schema
+func DBOpen() {
+	dbfile := ConfigPath("dbfile", WithDefault(":memory:"), OptionallyExist())
+	dburi := dbURI(dbfile)
 
 	if viper.IsSet("cleandb") && viper.GetBool("cleandb") {
 		Vfs.Remove(dbfile)
+		Vfs.Remove(dbfile + "-wal")
+		Vfs.Remove(dbfile + "-shm")
 	}
 
+	resetStmtCache()
+
 	var err error
 	db, err = sql.Open("sqlite", dburi)
 
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	db.Exec(schema())
 }
 
 func DBClose() {
+	resetStmtCache()
 	db.Close()
 }
 
-func DBLoadRepos() {
-	for repo := range viper.GetStringMap("repos") {
-		DBLoadRepo(repo)
-	}
-}
-
 func DBQuery(query string) (*sql.Rows, error) {
 	return db.Query(query)
 }
 
-func DBLoadReposSync() {
-	for repoName := range viper.GetStringMap("repos") {
-		repoPath := ConfigPath(fmt.Sprintf("repos.%s.path", repoName))
-
-		if exists, err := afero.DirExists(Vfs, repoPath); err != nil || !exists {
-			panic(fmt.Sprintf("Repo path %s does not exist", repoPath))
-		}
-
-		errz := afero.Walk(Vfs, repoPath, func(path string, info os.FileInfo, _ error) error {
-			if info.IsDir() {
-				return nil
-			}
-			if filepath.Ext(path) != ".md" {
-				return nil
-			}
-			item, err := LoadItem(repoName, repoPath, path)
-			if err == nil {
-				insertItem(item)
-			} else {
-				slog.Error(fmt.Sprintln(err))
-			}
-			return nil
-		})
-		if errz != nil {
-			panic(errz)
-		}
-	}
-}
-
 func RowToMapSlice(rows *sql.Rows) ([][]string, error) {
 	// Slice to hold the maps
 	var maps [][]string
@@ -248,52 +207,12 @@ func RowToMapSlice(rows *sql.Rows) ([][]string, error) {
 	return maps, nil
 }
 
-func DBLoadRepo(repoName string) {
-	const bufferLen = 5000
-	itempaths := make(chan string, bufferLen)
-	repoPath := ConfigPath(fmt.Sprintf("repos.%s.path", repoName))
-
-	const workers = 1
-	for w := 0; w < workers; w++ {
-		go func(id int, itempaths <-chan string) {
-			for path := range itempaths {
-				item, err := LoadItem(repoName, repoPath, path)
-				if err == nil {
-					insertItem(item)
-				} else {
-					slog.Error(fmt.Sprintln(err))
-				}
-			}
-		}(w, itempaths)
-	}
-
-	if !DirExistsFs(Vfs, repoPath) {
-		panic(fmt.Sprintf("Repo path %s does not exist", repoPath))
-	}
-
-	err := afero.Walk(Vfs, repoPath, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		if filepath.Ext(path) != ".md" {
-			return nil
-		}
-		itempaths <- path
-		return nil
-	})
-	if err != nil {
-		panic(err)
-	}
-	close(itempaths)
-	StartWatching(repoPath, repoName)
-}
-
 func reloadItem(repoName string, repoPath string, filename string) (Item, error) {
 	var item_id int64
 	isUpdate := false
 	if err := db.QueryRow("SELECT id FROM items WHERE repo = ? and source = ?", repoName, filename).Scan(&item_id); err == nil && item_id > 0 {
 		isUpdate = true
-		db.Exec("DELETE FROM items_tags WHERE item_id = ?", item_id)
+		db.Exec("DELETE FROM items_categories WHERE item_id = ?", item_id)
 		db.Exec("DELETE FROM items_authors WHERE item_id = ?", item_id)
 		db.Exec("DELETE FROM frontmatter WHERE item_id = ?", item_id)
 		db.Exec("DELETE FROM items WHERE repo = ? and source = ?", repoName, filename)
@@ -304,6 +223,7 @@ func reloadItem(repoName string, repoPath string, filename string) (Item, error)
 	item, err := LoadItem(repoName, repoPath, filename)
 	if err == nil {
 		insertItem(item)
+		NotifyWebSub(item)
 
 		// Publish to ActivityPub if enabled and this is an ActivityPub-enabled repo
 		if ActivityPubManager != nil {
@@ -328,6 +248,7 @@ func reloadItem(repoName string, repoPath string, filename string) (Item, error)
 			}
 		}
 	}
+	InvalidateRepoPages(repoName)
 	return item, err
 }
 
@@ -363,6 +284,7 @@ func ConvertItemToBlogPost(item Item) *activitypub.BlogPost {
 		MarkdownContent: item.Raw,
 		Summary:         summary,
 		PublishedAt:     item.Date,
+		UpdatedAt:       item.Updated,
 		Tags:            item.Categories, // Categories are used as tags
 		Authors:         item.Authors,
 		Repo:            item.Repo,
@@ -375,19 +297,9 @@ func GenerateSummaryFromHTML(htmlContent string) string {
 	return util.GenerateSummaryFromHTML(htmlContent)
 }
 
-func LoadItem(repoName string, repoPath string, filename string) (Item, error) {
-	var item Item
-
-	item.Source = filename
-
-	file, err := afero.ReadFile(Vfs, filename)
-	if err != nil {
-		slog.Error(fmt.Sprintf("Error reading file %s: %v", filename, err))
-		return item, err
-	}
-
-	var buf bytes.Buffer
-	md := goldmark.New(
+// newMarkdown creates the goldmark converter used for items and the markdown template helper
+func newMarkdown() goldmark.Markdown {
+	return goldmark.New(
 		goldmark.WithExtensions(
 			extension.GFM,
 			meta.New(
@@ -410,6 +322,21 @@ func LoadItem(repoName string, repoPath string, filename string) (Item, error) {
 			html.WithUnsafe(),
 		),
 	)
+}
+
+func LoadItem(repoName string, repoPath string, filename string) (Item, error) {
+	var item Item
+
+	item.Source = filename
+
+	file, err := afero.ReadFile(Vfs, filename)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error reading file %s: %v", filename, err))
+		return item, err
+	}
+
+	var buf bytes.Buffer
+	md := newMarkdown()
 	context := parser.NewContext()
 	if err := md.Convert(file, &buf, parser.WithContext(context)); err != nil {
 		panic(err)
@@ -521,22 +448,32 @@ func LoadItem(repoName string, repoPath string, filename string) (Item, error) {
 		}
 	}
 
-	// Get a real date from frontmatter or from filesystem
+	// Get a real date from frontmatter, from git history, or from filesystem
+	history, hasHistory := GitFileHistory(filename)
+	filestat, _ := Vfs.Stat(filename)
 	if _, ok := f["date"]; ok {
-		item.RawDate = f["date"].(string)
+		item.RawDate = fmt.Sprint(f["date"])
+	} else if hasHistory {
+		item.RawDate = history.Created.String()
 	} else {
-		filestat, _ := Vfs.Stat(filename)
 		item.RawDate = filestat.ModTime().String()
 	}
 	item.Date, _ = dateparse.ParseLocal(item.RawDate)
 
+	// In git mode the filesystem times are the clone time, so prefer the history of the file
+	if hasHistory {
+		item.Created = history.Created
+		item.Updated = history.Updated
+		item.LastEditor = history.LastEditor
+	} else {
+		item.Created = item.Date
+		item.Updated = filestat.ModTime()
+	}
+
 	return item, nil
 }
 
 func replaceImgSrc(html string) (string, error) {
-	// Define the regex to extract bucket and filename
-	regex := regexp.MustCompile(`s3://(?P<bucket>[^/]+)/(?P<filename>.+)`)
-
 	// Load the HTML document
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader([]byte(html)))
 	if err != nil {
@@ -547,14 +484,7 @@ func replaceImgSrc(html string) (string, error) {
 	doc.Find("img[src^='s3://']").Each(func(index int, item *goquery.Selection) {
 		src, exists := item.Attr("src")
 		if exists {
-			match := regex.FindStringSubmatch(src)
-			if match != nil {
-				bucket := match[1]
-				filename := match[2]
-				cdnURL := viper.GetString(fmt.Sprintf("s3.%s.cdn", bucket))
-				newSrc := cdnURL + filename
-				item.SetAttr("src", newSrc)
-			}
+			item.SetAttr("src", s3URL(src))
 		}
 	})
 
@@ -571,10 +501,107 @@ func replaceImgSrc(html string) (string, error) {
 	return buf.String(), nil
 }
 
+// insertItem inserts a single item and its relations in its own transaction
 func insertItem(item Item) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction for \"%s\": %w", item.Slug, err)
+	}
+	inserter, err := newItemInserter(tx)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	defer inserter.Close()
+
+	id, err := inserter.Insert(item)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// itemInserter inserts items within a transaction, reusing its prepared statements
+// and caching the ids of categories and authors across items
+type itemInserter struct {
+	tx            *sql.Tx
+	item          *sql.Stmt
+	category      *sql.Stmt
+	categoryId    *sql.Stmt
+	itemCategory  *sql.Stmt
+	author        *sql.Stmt
+	authorId      *sql.Stmt
+	itemAuthor    *sql.Stmt
+	frontmatter   *sql.Stmt
+	categoryIds   map[string]int64
+	authorIds     map[string]int64
+	preparedStmts []*sql.Stmt
+}
+
+func newItemInserter(tx *sql.Tx) (*itemInserter, error) {
+	ins := &itemInserter{
+		tx:          tx,
+		categoryIds: make(map[string]int64),
+		authorIds:   make(map[string]int64),
+	}
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&ins.item, "INSERT INTO items (slug, repo, publishedon, rawpublishedon, raw, html, source, title, frontmatter, createdon, updatedon, lasteditor) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)"},
+		{&ins.category, "INSERT OR IGNORE INTO categories (category) VALUES (?)"},
+		{&ins.categoryId, "SELECT id FROM categories WHERE category = ?"},
+		{&ins.itemCategory, "INSERT INTO items_categories (item_id, category_id) VALUES (?, ?)"},
+		{&ins.author, "INSERT OR IGNORE INTO authors (author) VALUES (?)"},
+		{&ins.authorId, "SELECT id FROM authors WHERE author = ?"},
+		{&ins.itemAuthor, "INSERT INTO items_authors (item_id, author_id) VALUES (?, ?)"},
+		{&ins.frontmatter, "INSERT INTO frontmatter (item_id, fieldname, value) VALUES (?,?,?)"},
+	}
+	for _, s := range statements {
+		stmt, err := tx.Prepare(s.query)
+		if err != nil {
+			ins.Close()
+			return nil, fmt.Errorf("error preparing \"%s\": %w", s.query, err)
+		}
+		*s.stmt = stmt
+		ins.preparedStmts = append(ins.preparedStmts, stmt)
+	}
+	return ins, nil
+}
+
+// Close releases the prepared statements of the inserter
+func (ins *itemInserter) Close() {
+	for _, stmt := range ins.preparedStmts {
+		stmt.Close()
+	}
+	ins.preparedStmts = nil
+}
+
+// Insert adds an item with its categories, authors and frontmatter.  An item that fails to
+// insert is rolled back to the savepoint before it, so it leaves none of its rows behind.
+func (ins *itemInserter) Insert(item Item) (int64, error) {
+	if _, err := ins.tx.Exec("SAVEPOINT item"); err != nil {
+		return 0, fmt.Errorf("error starting savepoint for \"%s\": %w", item.Slug, err)
+	}
+	id, err := ins.insert(item)
+	if err != nil {
+		ins.tx.Exec("ROLLBACK TO item")
+		ins.tx.Exec("RELEASE item")
+		// The rollback may have removed categories and authors that were cached
+		ins.categoryIds = make(map[string]int64)
+		ins.authorIds = make(map[string]int64)
+		return 0, err
+	}
+	if _, err := ins.tx.Exec("RELEASE item"); err != nil {
+		return 0, fmt.Errorf("error releasing savepoint for \"%s\": %w", item.Slug, err)
+	}
+	return id, nil
+}
+
+func (ins *itemInserter) insert(item Item) (int64, error) {
 	frontmatter, _ := json.Marshal(item.Frontmatter)
-	result, err := db.Exec(
-		"INSERT INTO items (slug, repo, publishedon, rawpublishedon, raw, html, source, title, frontmatter) VALUES (?,?,?,?,?,?,?,?,?)",
+	result, err := ins.item.Exec(
 		item.Slug,
 		item.Repo,
 		item.Date,
@@ -584,6 +611,9 @@ func insertItem(item Item) (int64, error) {
 		item.Source,
 		item.Title,
 		frontmatter,
+		item.Created,
+		item.Updated,
+		item.LastEditor,
 	)
 
 	if err != nil {
@@ -592,66 +622,58 @@ func insertItem(item Item) (int64, error) {
 
 	item.Id, _ = result.LastInsertId()
 
-	insertCategories(item)
-	insertAuthors(item)
-	insertFrontmatter(item)
-
-	return item.Id, nil
-}
-
-func insertCategories(item Item) {
-	var categorymap map[string]int64 = make(map[string]int64, len(item.Categories))
-
-	for _, category := range item.Categories {
-		stmt, _ := db.Prepare("INSERT INTO categories (category) VALUES (?)")
-		result, err := stmt.Exec(category)
-		var category_id int64
-		if err == nil {
-			category_id, err = result.LastInsertId()
-			if err != nil {
-				panic(err)
-			}
-		} else {
-			db.QueryRow("SELECT id FROM categories WHERE category = ?", category).Scan(&category_id)
+	categoryIds, err := ins.lookupIds(item.Categories, ins.categoryIds, ins.category, ins.categoryId)
+	if err != nil {
+		return item.Id, fmt.Errorf("error inserting categories of \"%s\": %w", item.Slug, err)
+	}
+	for _, categoryId := range categoryIds {
+		if _, err := ins.itemCategory.Exec(item.Id, categoryId); err != nil {
+			return item.Id, fmt.Errorf("error inserting categories of \"%s\": %w", item.Slug, err)
 		}
-		categorymap[category] = category_id
 	}
 
-	for _, category_id := range categorymap {
-		stmt, _ := db.Prepare("INSERT INTO items_categories (item_id, category_id) VALUES (?, ?)")
-		stmt.Exec(item.Id, category_id)
+	authorIds, err := ins.lookupIds(item.Authors, ins.authorIds, ins.author, ins.authorId)
+	if err != nil {
+		return item.Id, fmt.Errorf("error inserting authors of \"%s\": %w", item.Slug, err)
 	}
-}
-
-func insertAuthors(item Item) {
-	var authormap map[string]int64 = make(map[string]int64, len(item.Authors))
-
-	for _, author := range item.Authors {
-		stmt, _ := db.Prepare("INSERT INTO authors (author) VALUES (?)")
-		result, err := stmt.Exec(author)
-		var author_id int64
-		if err == nil {
-			author_id, err = result.LastInsertId()
-			if err != nil {
-				panic(err)
-			}
-		} else {
-			db.QueryRow("SELECT id FROM authors WHERE author = ?", author).Scan(&author_id)
+	for _, authorId := range authorIds {
+		if _, err := ins.itemAuthor.Exec(item.Id, authorId); err != nil {
+			return item.Id, fmt.Errorf("error inserting authors of \"%s\": %w", item.Slug, err)
 		}
-		authormap[author] = author_id
 	}
 
-	for _, author_id := range authormap {
-		stmt, _ := db.Prepare("INSERT INTO items_authors (item_id, author_id) VALUES (?, ?)")
-		stmt.Exec(item.Id, author_id)
+	for k, v := range item.Frontmatter {
+		if _, err := ins.frontmatter.Exec(item.Id, k, v); err != nil {
+			return item.Id, fmt.Errorf("error inserting frontmatter %s of \"%s\": %w", k, item.Slug, err)
+		}
 	}
+
+	return item.Id, nil
 }
 
-func insertFrontmatter(item Item) {
-	for k, v := range item.Frontmatter {
-		stmt, _ := db.Prepare("INSERT INTO frontmatter (item_id, fieldname, value) VALUES (?,?,?)")
-		stmt.Exec(item.Id, k, v)
+// lookupIds returns the distinct ids for a list of names, inserting any names that don't exist yet
+func (ins *itemInserter) lookupIds(names []string, cache map[string]int64, insert *sql.Stmt, selectId *sql.Stmt) ([]int64, error) {
+	ids := make([]int64, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		id, ok := cache[name]
+		if !ok {
+			if _, err := insert.Exec(name); err != nil {
+				return nil, err
+			}
+			if err := selectId.QueryRow(name).Scan(&id); err != nil {
+				return nil, err
+			}
+			cache[name] = id
+		}
+		ids = append(ids, id)
 	}
+	return ids, nil
 }
 
 // FileState represents the state of a file
@@ -699,8 +721,9 @@ func CompareFileStates(prev, curr map[string]FileState) []string {
 	return changedFiles
 }
 
-// StartWatching starts watching the given directory for changes
-func StartWatching(path string, repoName string) {
+// StartWatching starts watching the given directory for changes, until the returned function
+// is called
+func StartWatching(path string, repoName string) func() {
 	r := regexp.MustCompile(".md$")
 	prevStates, err := GetFileStates(Vfs, path, r)
 	if err != nil {
@@ -708,21 +731,34 @@ func StartWatching(path string, repoName string) {
 	}
 
 	ticker := time.NewTicker(1 * time.Second)
+	done := make(chan struct{})
 
 	go func() {
-		for range ticker.C {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
 			currStates, err := GetFileStates(Vfs, path, r)
 			if err != nil {
 				log.Fatalln(err)
 			}
 			changedFiles := CompareFileStates(prevStates, currStates)
+			configLock.RLock()
 			for _, file := range changedFiles {
 				slog.Info(fmt.Sprintf("File changed: %s", file))
 				reloadItem(repoName, path, file)
 			}
+			configLock.RUnlock()
 			prevStates = currStates
 		}
 	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
 }
 
 type ItemQuery struct {
@@ -735,6 +771,7 @@ type ItemQuery struct {
 	Search      *string
 	OrderBy     *string
 	Frontmatter map[string]string
+	Fields      []string // Body columns to load ("raw", "html"), nil loads both
 }
 
 func setQryValue(field **string, params map[string]interface{}, key string) {
@@ -747,6 +784,12 @@ func setQryValue(field **string, params map[string]interface{}, key string) {
 // outVariableParams is a map of the parameters that define the content of the out variable
 // context is a map of the parameters that define the context of the route
 func ItemsFromOutvals(outVariableParams map[string]interface{}, context map[string]interface{}) ItemResult {
+	return ItemsFromItemQuery(ItemQueryFromOutvals(outVariableParams, context))
+}
+
+// ItemQueryFromOutvals builds the ItemQuery of a route's out query, with the path variables
+// and query parameters of the request in context substituted into it
+func ItemQueryFromOutvals(outVariableParams map[string]interface{}, context map[string]interface{}) ItemQuery {
 	qry := ItemQuery{Page: 1, Frontmatter: make(map[string]string)}
 
 	var ok bool
@@ -783,7 +826,21 @@ func ItemsFromOutvals(outVariableParams map[string]interface{}, context map[stri
 		qry.Frontmatter = outVariableParams["frontmatter"].(map[string]string)
 	}
 
-	return ItemsFromItemQuery(qry)
+	if fields, ok := outVariableParams["fields"]; ok {
+		qry.Fields = make([]string, 0)
+		switch v := fields.(type) {
+		case []interface{}:
+			for _, field := range v {
+				qry.Fields = append(qry.Fields, fmt.Sprint(field))
+			}
+		case []string:
+			qry.Fields = append(qry.Fields, v...)
+		case string:
+			qry.Fields = append(qry.Fields, v)
+		}
+	}
+
+	return qry
 }
 
 func ItemsFromItemQuery(qry ItemQuery) ItemResult {
@@ -795,36 +852,33 @@ func ItemsFromItemQuery(qry ItemQuery) ItemResult {
 	front := (qry.Page - 1) * qry.PerPage
 	pg = qry.Page
 
-	var sql string = `FROM items
-	LEFT JOIN items_authors ON items.id = items_authors.item_id
-   LEFT JOIN authors ON authors.id = items_authors.author_id
-	LEFT JOIN items_categories ON items.id = items_categories.item_id
-   LEFT JOIN categories ON categories.id = items_categories.category_id WHERE 1`
-	var queryvals []any
-
-	sql, queryvals = andSQL("slug", qry.Slug, sql, queryvals)
-	sql, queryvals = andSQL("repo", qry.Repo, sql, queryvals)
-	sql, queryvals = andSQL("category", qry.Category, sql, queryvals)
-	sql, queryvals = andSQL("author", qry.Author, sql, queryvals)
-	if qry.Search != nil {
-		queryvals = append(queryvals, fmt.Sprintf("%%%s%%", *qry.Search))
-		sql = fmt.Sprintf("%s AND raw LIKE ?", sql)
-	}
+	sql, queryvals := itemQueryFilter(qry)
 
 	var orderby string = "ORDER BY publishedon DESC"
 	if qry.OrderBy != nil {
-		orderby = fmt.Sprintf("ORDER BY %s", *qry.OrderBy)
+		orderby = fmt.Sprintf("ORDER BY %s", itemOrderBy(*qry.OrderBy))
 	}
 
-	countsql := fmt.Sprintf("SELECT count(distinct items.id) %s", sql)
+	countsql := fmt.Sprintf("SELECT count(*) %s", sql)
 
 	var itemCount int
-	db.QueryRow(countsql, queryvals...).Scan(&itemCount)
+	if err := dbQueryRowCached(countsql, queryvals...).Scan(&itemCount); err != nil {
+		slog.Error("Error counting items", "error", err)
+	}
 
 	if itemCount > 0 {
-		sql = fmt.Sprintf("SELECT distinct items.id, repo, title, slug, publishedon, rawpublishedon, raw, html, source %s %s LIMIT %d, %d", sql, orderby, front, qry.PerPage)
+		raw, html := "raw", "html"
+		if qry.Fields != nil {
+			if !slices.Contains(qry.Fields, "raw") {
+				raw = "''"
+			}
+			if !slices.Contains(qry.Fields, "html") {
+				html = "''"
+			}
+		}
+		sql = fmt.Sprintf("SELECT items.id, repo, title, slug, publishedon, rawpublishedon, %s, %s, source, frontmatter, createdon, updatedon, lasteditor %s %s LIMIT ?, ?", raw, html, sql, orderby)
 
-		rows, err := db.Query(sql, queryvals...)
+		rows, err := dbQueryCached(sql, append(queryvals, front, qry.PerPage)...)
 
 		if err != nil {
 			slog.Error(fmt.Sprintf("Error: %#v", err))
@@ -835,52 +889,34 @@ func ItemsFromItemQuery(qry ItemQuery) ItemResult {
 		for rows.Next() {
 			var item Item
 			var interimDate string
-			err = rows.Scan(&item.Id, &item.Repo, &item.Title, &item.Slug, &interimDate, &item.RawDate, &item.Raw, &item.Html, &item.Source)
+			var frontmatter, created, updated, lastEditor *string
+			err = rows.Scan(&item.Id, &item.Repo, &item.Title, &item.Slug, &interimDate, &item.RawDate, &item.Raw, &item.Html, &item.Source, &frontmatter, &created, &updated, &lastEditor)
 
 			item.Date, _ = dateparse.ParseLocal(interimDate)
 			if err != nil {
 				panic(err)
 			}
-
-			categories, err := db.Query("SELECT category FROM categories INNER JOIN items_categories ON items_categories.category_id = categories.id WHERE items_categories.item_id = ?", item.Id)
-
-			if err != nil {
-				panic(err)
+			if created != nil {
+				item.Created, _ = dateparse.ParseLocal(*created)
 			}
-
-			var category string
-			for categories.Next() {
-				categories.Scan(&category)
-				item.Categories = append(item.Categories, category)
+			if updated != nil {
+				item.Updated, _ = dateparse.ParseLocal(*updated)
 			}
-
-			authors, err := db.Query("SELECT author FROM authors INNER JOIN items_authors ON items_authors.author_id = authors.id WHERE items_authors.item_id = ?", item.Id)
-
-			if err != nil {
-				panic(err)
-			}
-
-			var author string
-			for authors.Next() {
-				authors.Scan(&author)
-				item.Authors = append(item.Authors, author)
-			}
-
-			frontmatters, err := db.Query("SELECT fieldname, value FROM frontmatter WHERE item_id = ?", item.Id)
-
-			if err != nil {
-				panic(err)
+			if lastEditor != nil {
+				item.LastEditor = *lastEditor
 			}
 
-			var fm_key, fm_value string
 			item.Frontmatter = make(map[string]string)
-			for frontmatters.Next() {
-				frontmatters.Scan(&fm_key, &fm_value)
-				item.Frontmatter[fm_key] = fm_value
+			if frontmatter != nil {
+				json.Unmarshal([]byte(*frontmatter), &item.Frontmatter)
 			}
 
 			items = append(items, item)
 		}
+
+		if err := loadItemRelations(items); err != nil {
+			slog.Error("Error loading item categories and authors", "error", err)
+		}
 	}
 
 	// Load comments only for single-item queries (when viewing a specific post)
@@ -896,6 +932,149 @@ func ItemsFromItemQuery(qry ItemQuery) ItemResult {
 	return ItemResult{Items: items, Total: int(itemCount), Pages: int(math.Ceil(float64(itemCount) / float64(qry.PerPage))), Page: pg}
 }
 
+// taxonomyOrderTerm matches an order_by term that sorts by the category or author of items
+var taxonomyOrderTerm = regexp.MustCompile(`(?i)^\s*(?:(categories\.)?(category)|(authors\.)?(author))(\s+(?:ASC|DESC))?\s*$`)
+
+// itemOrderBy returns an order_by setting for the items query.  The categories and authors
+// are not joined into the query, so a term that sorts by the category or author sorts by
+// the first one of the item in alphabetical order.
+func itemOrderBy(orderBy string) string {
+	terms := strings.Split(orderBy, ",")
+	for i, term := range terms {
+		match := taxonomyOrderTerm.FindStringSubmatch(term)
+		if match == nil {
+			continue
+		}
+		if match[2] != "" {
+			terms[i] = "(SELECT min(category) FROM categories INNER JOIN items_categories ON items_categories.category_id = categories.id WHERE items_categories.item_id = items.id)" + match[5]
+		} else {
+			terms[i] = "(SELECT min(author) FROM authors INNER JOIN items_authors ON items_authors.author_id = authors.id WHERE items_authors.item_id = items.id)" + match[5]
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// itemQueryFilter returns the FROM and WHERE clauses of the items selected by an ItemQuery,
+// without ordering or pagination, and their values
+func itemQueryFilter(qry ItemQuery) (string, []any) {
+	var sql string = `FROM items WHERE 1`
+	var queryvals []any
+
+	sql, queryvals = andSQL("slug", qry.Slug, sql, queryvals)
+	sql, queryvals = andSQL("repo", qry.Repo, sql, queryvals)
+	if qry.Category != nil {
+		queryvals = append(queryvals, *qry.Category)
+		sql = fmt.Sprintf("%s AND items.id IN (SELECT item_id FROM items_categories INNER JOIN categories ON categories.id = items_categories.category_id WHERE category = ?)", sql)
+	}
+	if qry.Author != nil {
+		queryvals = append(queryvals, *qry.Author)
+		sql = fmt.Sprintf("%s AND items.id IN (SELECT item_id FROM items_authors INNER JOIN authors ON authors.id = items_authors.author_id WHERE author = ?)", sql)
+	}
+	if qry.Search != nil {
+		queryvals = append(queryvals, fmt.Sprintf("%%%s%%", *qry.Search))
+		sql = fmt.Sprintf("%s AND raw LIKE ?", sql)
+	}
+	return sql, queryvals
+}
+
+// TaxonomyFromItemQuery counts the items of each category and author among every item an
+// ItemQuery selects, regardless of the page
+func TaxonomyFromItemQuery(qry ItemQuery) (Taxonomy, error) {
+	filter, queryvals := itemQueryFilter(qry)
+	taxonomy := Taxonomy{Categories: []Category{}, Authors: []Author{}}
+
+	relations := []struct {
+		query string
+		add   func(name string, count int)
+	}{
+		{
+			fmt.Sprintf("SELECT category, count(*) AS total FROM categories INNER JOIN items_categories ON items_categories.category_id = categories.id WHERE items_categories.item_id IN (SELECT items.id %s) GROUP BY category ORDER BY total DESC, category", filter),
+			func(name string, count int) {
+				taxonomy.Categories = append(taxonomy.Categories, Category{Name: name, Count: count})
+			},
+		},
+		{
+			fmt.Sprintf("SELECT author, count(*) AS total FROM authors INNER JOIN items_authors ON items_authors.author_id = authors.id WHERE items_authors.item_id IN (SELECT items.id %s) GROUP BY author ORDER BY total DESC, author", filter),
+			func(name string, count int) {
+				taxonomy.Authors = append(taxonomy.Authors, Author{Name: name, Count: count})
+			},
+		},
+	}
+
+	for _, relation := range relations {
+		rows, err := dbQueryCached(relation.query, queryvals...)
+		if err != nil {
+			return taxonomy, err
+		}
+		for rows.Next() {
+			var name string
+			var count int
+			if err := rows.Scan(&name, &count); err != nil {
+				rows.Close()
+				return taxonomy, err
+			}
+			relation.add(name, count)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return taxonomy, err
+		}
+	}
+	return taxonomy, nil
+}
+
+// loadItemRelations fills in the categories and authors of a page of items with one query each
+func loadItemRelations(items []Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	positions := make(map[int64]int, len(items))
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		positions[item.Id] = i
+		ids[i] = item.Id
+	}
+	idList, _ := json.Marshal(ids)
+
+	relations := []struct {
+		query  string
+		assign func(item *Item, value string)
+	}{
+		{
+			"SELECT items_categories.item_id, category FROM categories INNER JOIN items_categories ON items_categories.category_id = categories.id WHERE items_categories.item_id IN (SELECT value FROM json_each(?)) ORDER BY items_categories.id",
+			func(item *Item, value string) { item.Categories = append(item.Categories, value) },
+		},
+		{
+			"SELECT items_authors.item_id, author FROM authors INNER JOIN items_authors ON items_authors.author_id = authors.id WHERE items_authors.item_id IN (SELECT value FROM json_each(?)) ORDER BY items_authors.id",
+			func(item *Item, value string) { item.Authors = append(item.Authors, value) },
+		},
+	}
+
+	for _, relation := range relations {
+		rows, err := dbQueryCached(relation.query, string(idList))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var itemId int64
+			var value string
+			if err := rows.Scan(&itemId, &value); err != nil {
+				rows.Close()
+				return err
+			}
+			if i, ok := positions[itemId]; ok {
+				relation.assign(&items[i], value)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func replaceParams(values map[string]interface{}, params map[string]string) map[string]interface{} {
 	for k1, v1 := range values {
 		temp := v1
@@ -944,6 +1123,7 @@ func InsertComment(comment *activitypub.Comment) error {
 	if err != nil {
 		return fmt.Errorf("failed to insert comment: %w", err)
 	}
+	InvalidateCommentPages(comment.PostRepo, comment.PostSlug)
 	return nil
 }
 
@@ -1,6 +1,8 @@
 package sn
 
 import (
+	"fmt"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -262,9 +264,13 @@ func TestItemQueryStruct(t *testing.T) {
 	}
 }
 
-// TestSchema tests that schema returns valid SQL
+// TestSchema tests that the initial migration creates the expected tables
 func TestSchema(t *testing.T) {
-	schemaSQL := schema()
+	migrations, err := Migrations()
+	if err != nil || len(migrations) == 0 {
+		t.Fatalf("Migrations() = %v, %v", migrations, err)
+	}
+	schemaSQL := migrations[0].SQL
 
 	// Verify it contains expected table creations
 	if !strings.Contains(schemaSQL, "CREATE TABLE IF NOT EXISTS \"items\"") {
@@ -294,3 +300,96 @@ func TestSchema(t *testing.T) {
 		t.Error("Schema should contain unique index creation statements")
 	}
 }
+
+// TestItemsFromItemQuery verifies filtering, batched relations and body projection
+func TestItemsFromItemQuery(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md":   "---\ntitle: One\ndate: 2024-01-01\ntags: [go, sqlite]\nauthors: [alice]\nhero: one.png\n---\n\nFirst post.",
+		"/blog/two.md":   "---\ntitle: Two\ndate: 2024-01-02\ntags: [go]\nauthors: [alice, bob]\n---\n\nSecond post.",
+		"/blog/three.md": "---\ntitle: Three\ndate: 2024-01-03\nauthors: [bob]\n---\n\nThird post.",
+	})
+	DBLoadRepo("blog")
+
+	repo := "blog"
+	tag := "go"
+	result := ItemsFromItemQuery(ItemQuery{Page: 1, PerPage: 10, Repo: &repo, Category: &tag})
+	if result.Total != 2 || len(result.Items) != 2 {
+		t.Fatalf("Expected 2 items tagged go, got total %d, %d items", result.Total, len(result.Items))
+	}
+
+	two, one := result.Items[0], result.Items[1]
+	if two.Title != "Two" || one.Title != "One" {
+		t.Fatalf("Expected newest first, got %q then %q", two.Title, one.Title)
+	}
+	if !slices.Contains(one.Categories, "sqlite") || !slices.Contains(one.Categories, "go") {
+		t.Errorf("Expected categories of One to include go and sqlite, got %v", one.Categories)
+	}
+	if !slices.Equal(two.Authors, []string{"alice", "bob"}) {
+		t.Errorf("Expected authors of Two to be alice and bob, got %v", two.Authors)
+	}
+	if one.Frontmatter["hero"] != "one.png" {
+		t.Errorf("Expected frontmatter hero of One, got %v", one.Frontmatter)
+	}
+	if one.Raw == "" || one.Html == "" {
+		t.Error("Expected raw and html to be loaded without a field projection")
+	}
+
+	author := "bob"
+	projected := ItemsFromItemQuery(ItemQuery{Page: 1, PerPage: 1, Repo: &repo, Author: &author, Fields: []string{"html"}})
+	if projected.Total != 2 || projected.Pages != 2 || len(projected.Items) != 1 {
+		t.Fatalf("Expected page 1 of 2 for bob, got %+v", projected)
+	}
+	if projected.Items[0].Raw != "" || projected.Items[0].Html == "" {
+		t.Errorf("Expected only html to be loaded, got raw %q html %q", projected.Items[0].Raw, projected.Items[0].Html)
+	}
+}
+
+// TestItemsFromItemQuery_OrderByTaxonomy verifies that items sort by their first category
+// or author, which are not columns of the items query
+func TestItemsFromItemQuery_OrderByTaxonomy(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md":   "---\ntitle: One\ndate: 2024-01-01\ntags: [python, go]\nauthors: [carol]\n---\n\nFirst post.",
+		"/blog/two.md":   "---\ntitle: Two\ndate: 2024-01-02\ntags: [rust]\nauthors: [alice, dave]\n---\n\nSecond post.",
+		"/blog/three.md": "---\ntitle: Three\ndate: 2024-01-03\ntags: [html]\nauthors: [bob]\n---\n\nThird post.",
+	})
+	DBLoadRepo("blog")
+
+	tests := []struct {
+		orderBy string
+		titles  []string
+	}{
+		{"category", []string{"One", "Three", "Two"}},
+		{"categories.category DESC", []string{"Two", "Three", "One"}},
+		{"author ASC, publishedon", []string{"Two", "Three", "One"}},
+		{"authors.author desc", []string{"One", "Three", "Two"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.orderBy, func(t *testing.T) {
+			orderBy := tt.orderBy
+			result := ItemsFromItemQuery(ItemQuery{Page: 1, PerPage: 10, OrderBy: &orderBy})
+			titles := make([]string, 0, len(result.Items))
+			for _, item := range result.Items {
+				titles = append(titles, item.Title)
+			}
+			if !slices.Equal(titles, tt.titles) {
+				t.Errorf("Items ordered by %s = %v, want %v", tt.orderBy, titles, tt.titles)
+			}
+		})
+	}
+}
+
+func BenchmarkItemsFromItemQuery_Tag(b *testing.B) {
+	files := make(map[string]string)
+	for i := 0; i < 500; i++ {
+		files[fmt.Sprintf("/blog/post-%d.md", i)] = fmt.Sprintf("---\ntitle: Post %d\ndate: 2024-01-01\ntags: [go, tag%d]\nauthors: [alice]\n---\n\n%s", i, i%10, strings.Repeat("Some words here. ", 200))
+	}
+	setupLoaderTest(b, files)
+	DBLoadRepo("blog")
+
+	repo := "blog"
+	tag := "go"
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ItemsFromItemQuery(ItemQuery{Page: 1, PerPage: 10, Repo: &repo, Category: &tag, Fields: []string{"html"}})
+	}
+}
@@ -0,0 +1,188 @@
+package sn
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+// feedHistoryNamespace is the RFC 5005 namespace of the element that marks archive documents
+const feedHistoryNamespace = "http://purl.org/syndication/history/1.0"
+
+// feedPageLink is an RFC 5005 link from a page of a feed to another
+type feedPageLink struct {
+	Rel  string
+	Href string
+}
+
+// feedPage is the page of a feed that a request selects, with validators from its items
+type feedPage struct {
+	Query    ItemQuery
+	Archive  bool // Whether the page is an archive document of an archived feed
+	Links    []feedPageLink
+	ETag     string
+	Modified time.Time
+}
+
+// newFeedPage selects the page of a feed route's items for a request, from the paginate_name
+// query parameter or path variable of its feed query.  It returns false when there is no such
+// page, or when the feed of a tag or author has no items.
+//
+// A feed is paged by default, with first, last, next and previous links between its pages,
+// newest first.  With archive set, the feed's subscription document has the newest items and
+// links to prev-archive, and the numbered pages are archive documents that hold the oldest
+// items first, so that a complete archive page never changes.
+//
+// The page's items are looked up without their content to find the validators, so a
+// conditional request for an unchanged feed does not render it.
+func newFeedPage(r *http.Request, format string) (*feedPage, bool) {
+	routeName := mux.CurrentRoute(r).GetName()
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+	query := feedQuery(routeName)
+	paginateName, ok := query["paginate_name"].(string)
+	if !ok {
+		paginateName = "page"
+	}
+	_, numbered := mux.Vars(r)[paginateName]
+	numbered = numbered || r.URL.Query().Has(paginateName)
+
+	context := viper.GetStringMap(routeConfigLocation)
+	context["pathvars"] = maps.Clone(mux.Vars(r))
+	context["params"] = r.URL.Query()
+	page := &feedPage{
+		Query:   ItemQueryFromOutvals(query, context),
+		Archive: viper.GetBool(fmt.Sprintf("%s.feed.archive", routeConfigLocation)) && numbered,
+	}
+	if page.Archive {
+		orderBy := "publishedon ASC"
+		page.Query.OrderBy = &orderBy
+	}
+
+	probe := page.Query
+	probe.Fields = []string{}
+	result := ItemsFromItemQuery(probe)
+	if result.Total == 0 && feedHasTaxonomy(routeName) {
+		return nil, false
+	}
+
+	pageURL := func(number int) string {
+		return feedPageURL(r, paginateName, number)
+	}
+	current := page.Query.Page
+	switch {
+	case viper.GetBool(fmt.Sprintf("%s.feed.archive", routeConfigLocation)):
+		archives := 0
+		if page.Query.PerPage > 0 {
+			archives = result.Total / page.Query.PerPage
+		}
+		if !page.Archive {
+			if archives > 0 {
+				page.Links = append(page.Links, feedPageLink{"prev-archive", pageURL(archives)})
+			}
+			break
+		}
+		if current < 1 || current > archives {
+			return nil, false
+		}
+		page.Links = append(page.Links, feedPageLink{"current", pageURL(0)})
+		if current > 1 {
+			page.Links = append(page.Links, feedPageLink{"prev-archive", pageURL(current - 1)})
+		}
+		if current < archives {
+			page.Links = append(page.Links, feedPageLink{"next-archive", pageURL(current + 1)})
+		}
+	default:
+		if current < 1 || (current > 1 && current > result.Pages) {
+			return nil, false
+		}
+		if result.Pages > 1 {
+			// The first page is the feed itself, without a page number
+			pagedURL := func(number int) string {
+				if number == 1 {
+					number = 0
+				}
+				return pageURL(number)
+			}
+			page.Links = append(page.Links, feedPageLink{"first", pagedURL(1)}, feedPageLink{"last", pagedURL(result.Pages)})
+			if current > 1 {
+				page.Links = append(page.Links, feedPageLink{"previous", pagedURL(current - 1)})
+			}
+			if current < result.Pages {
+				page.Links = append(page.Links, feedPageLink{"next", pagedURL(current + 1)})
+			}
+		}
+	}
+
+	// The feed changes with its items, or with the settings it is described by
+	hash := sha256.New()
+	fmt.Fprintln(hash, format, r.URL.RequestURI(), result.Total, WebSubHubURL(), viper.Get(routeConfigLocation))
+	for _, name := range []string{"title", "subtitle", "rooturl", "language", "icon", "author"} {
+		fmt.Fprintln(hash, viper.Get(name))
+	}
+	for _, item := range result.Items {
+		lastMod := itemLastMod(item)
+		fmt.Fprintln(hash, item.Repo, item.Slug, lastMod.UnixNano())
+		if lastMod.After(page.Modified) {
+			page.Modified = lastMod
+		}
+	}
+	page.ETag = fmt.Sprintf(`"%s"`, hex.EncodeToString(hash.Sum(nil)[:16]))
+	return page, true
+}
+
+// feedPageURL returns the URL of a numbered page of a feed, or of the feed itself for page 0,
+// keeping the request's other path variables and query parameters.  A feed paginated by a path
+// variable has no URL without a page number, so page 0 is page 1.
+func feedPageURL(r *http.Request, paginateName string, number int) string {
+	vars := mux.Vars(r)
+	if _, ok := vars[paginateName]; ok {
+		pairs := make([]string, 0, len(vars)*2)
+		for name, value := range vars {
+			if name == paginateName {
+				value = strconv.Itoa(max(number, 1))
+			}
+			pairs = append(pairs, name, value)
+		}
+		if u, err := mux.CurrentRoute(r).URLPath(pairs...); err == nil {
+			u.RawQuery = r.URL.RawQuery
+			return rootURL() + u.RequestURI()
+		}
+	}
+
+	u := *r.URL
+	query := u.Query()
+	if number > 0 {
+		query.Set(paginateName, strconv.Itoa(number))
+	} else {
+		query.Del(paginateName)
+	}
+	u.RawQuery = query.Encode()
+	return rootURL() + u.RequestURI()
+}
+
+// feedNotModified reports whether a conditional request already has the current page of a
+// feed, going by If-None-Match, or else by If-Modified-Since
+func feedNotModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,189 @@
+package sn
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+func setupFeedPagesTest(t *testing.T) *mux.Router {
+	t.Helper()
+	files := make(map[string]string)
+	for day := 1; day <= 5; day++ {
+		files[fmt.Sprintf("/blog/post%d.md", day)] = fmt.Sprintf("---\ntitle: Post %d\ndate: 2024-01-0%d\n---\n\nPost %d.", day, day, day)
+	}
+	setupLoaderTest(t, files)
+	DBLoadRepo("blog")
+
+	viper.Set("rooturl", "https://example.com/")
+	viper.Set("routes.paged.path", "/feed.atom")
+	viper.Set("routes.paged.handler", "feed")
+	viper.Set("routes.paged.feed", map[string]interface{}{"repo": "blog", "paginate_count": 2})
+	viper.Set("routes.archived.path", "/archive.atom")
+	viper.Set("routes.archived.handler", "feed")
+	viper.Set("routes.archived.feed", map[string]interface{}{"repo": "blog", "paginate_count": 2, "archive": true})
+
+	router := mux.NewRouter()
+	setupRoutes(router)
+	return router
+}
+
+func TestFeedPages(t *testing.T) {
+	router := setupFeedPagesTest(t)
+
+	type atomPage struct {
+		Links []struct {
+			Rel  string `xml:"rel,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+		Archive *struct{} `xml:"http://purl.org/syndication/history/1.0 archive"`
+		Titles  []string  `xml:"entry>title"`
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		links   map[string]string
+		titles  string
+		archive bool
+	}{
+		{"first page", "/feed.atom", map[string]string{
+			"first": "https://example.com/feed.atom",
+			"last":  "https://example.com/feed.atom?page=3",
+			"next":  "https://example.com/feed.atom?page=2",
+		}, "Post 5,Post 4", false},
+		{"middle page", "/feed.atom?page=2", map[string]string{
+			"first":    "https://example.com/feed.atom",
+			"previous": "https://example.com/feed.atom",
+			"next":     "https://example.com/feed.atom?page=3",
+			"last":     "https://example.com/feed.atom?page=3",
+		}, "Post 3,Post 2", false},
+		{"last page", "/feed.atom?page=3", map[string]string{
+			"previous": "https://example.com/feed.atom?page=2",
+		}, "Post 1", false},
+		{"subscription document", "/archive.atom", map[string]string{
+			"prev-archive": "https://example.com/archive.atom?page=2",
+		}, "Post 5,Post 4", false},
+		{"newest archive", "/archive.atom?page=2", map[string]string{
+			"current":      "https://example.com/archive.atom",
+			"prev-archive": "https://example.com/archive.atom?page=1",
+		}, "Post 4,Post 3", true},
+		{"oldest archive", "/archive.atom?page=1", map[string]string{
+			"current":      "https://example.com/archive.atom",
+			"next-archive": "https://example.com/archive.atom?page=2",
+		}, "Post 2,Post 1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Status = %d, want 200", rec.Code)
+			}
+
+			var page atomPage
+			if err := xml.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+				t.Fatalf("Invalid Atom feed: %v", err)
+			}
+			links := make(map[string]string)
+			for _, link := range page.Links {
+				links[link.Rel] = link.Href
+			}
+			for rel, href := range tt.links {
+				if links[rel] != href {
+					t.Errorf("%s link = %q, want %q", rel, links[rel], href)
+				}
+			}
+			if titles := strings.Join(page.Titles, ","); titles != tt.titles {
+				t.Errorf("Entries = %s, want %s", titles, tt.titles)
+			}
+			if (page.Archive != nil) != tt.archive {
+				t.Errorf("fh:archive = %v, want %v", page.Archive != nil, tt.archive)
+			}
+		})
+	}
+
+	// Pages past the last, and the incomplete newest archive, do not exist
+	for _, path := range []string{"/feed.atom?page=4", "/feed.atom?page=0", "/archive.atom?page=3"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want 404", path, rec.Code)
+		}
+	}
+}
+
+func TestFeedConditionalGet(t *testing.T) {
+	router := setupFeedPagesTest(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feed.atom", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag")
+	}
+	lastModified := rec.Header().Get("Last-Modified")
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		t.Fatalf("Expected a Last-Modified date, got %q", lastModified)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"matching etag", "If-None-Match", etag, http.StatusNotModified},
+		{"weak etag", "If-None-Match", "W/" + etag, http.StatusNotModified},
+		{"other etag", "If-None-Match", `"stale"`, http.StatusOK},
+		{"not modified since", "If-Modified-Since", lastModified, http.StatusNotModified},
+		{"modified since", "If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/feed.atom", nil)
+			req.Header.Set(tt.header, tt.value)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("Status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Errorf("Expected no body, got %s", rec.Body)
+			}
+		})
+	}
+
+	// Every page has its own validators
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feed.atom?page=2", nil))
+	if rec.Header().Get("ETag") == etag {
+		t.Error("Expected the second page to have another ETag")
+	}
+
+	// A new item changes the feed
+	afero.WriteFile(Vfs, "/blog/post6.md", []byte("---\ntitle: Post 6\ndate: 2024-01-06\n---\n\nPost 6."), 0644)
+	if _, err := reloadItem("blog", "/blog", "/blog/post6.md"); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/feed.atom", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("Expected a new item to change the feed, got status %d", rec.Code)
+	}
+	if updated, _ := http.ParseTime(rec.Header().Get("Last-Modified")); updated.Before(modified) {
+		t.Errorf("Last-Modified = %v, want no earlier than %v", updated, modified)
+	}
+}
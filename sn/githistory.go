@@ -0,0 +1,238 @@
+package sn
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Revision is a single commit that changed a source file
+type Revision struct {
+	Hash    string
+	Author  string
+	Email   string
+	Date    time.Time
+	Message string
+}
+
+// FileHistory is the git history of a single source file
+type FileHistory struct {
+	Created    time.Time
+	Updated    time.Time
+	LastEditor string
+	Revisions  []Revision // Newest first
+}
+
+var (
+	gitHistory        = make(map[string]*FileHistory)
+	gitHistoryCommits = make(map[plumbing.Hash]bool)
+	gitHistoryHead    plumbing.Hash
+	gitHistoryLock    sync.RWMutex
+)
+
+// gitHistoryPath converts a Vfs filename into the path git uses for it
+func gitHistoryPath(filename string) string {
+	return strings.TrimPrefix(path.Clean(filename), "/")
+}
+
+// GitFileHistory returns the git history of a source file, if Sn is running from a git repo
+func GitFileHistory(filename string) (*FileHistory, bool) {
+	gitHistoryLock.RLock()
+	defer gitHistoryLock.RUnlock()
+
+	history, ok := gitHistory[gitHistoryPath(filename)]
+	return history, ok
+}
+
+// LoadGitHistory walks the commits of the git repo that have not been seen yet and
+// merges them into the per-file history.  It returns the paths whose history changed.
+// The walk stops at the commits loaded before, whose ancestors are loaded too.  A renamed
+// file keeps the history of its previous paths.
+func LoadGitHistory() ([]string, error) {
+	if Repo == nil {
+		return nil, nil
+	}
+
+	ref, err := Repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo head: %w", err)
+	}
+
+	gitHistoryLock.RLock()
+	if ref.Hash() == gitHistoryHead {
+		gitHistoryLock.RUnlock()
+		return nil, nil
+	}
+	seen := make(map[plumbing.Hash]bool, len(gitHistoryCommits))
+	for hash := range gitHistoryCommits {
+		seen[hash] = true
+	}
+	gitHistoryLock.RUnlock()
+
+	head, err := Repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read git log: %w", err)
+	}
+	commits := object.NewCommitIterCTime(head, seen, nil)
+	defer commits.Close()
+
+	newRevisions := make(map[string][]Revision)
+	newCommits := make([]plumbing.Hash, 0)
+	// The walk is newest first, so the commits before a rename changed the file at an older path
+	renames := make(map[string]string)
+	currentPath := func(p string) string {
+		if renamed, ok := renames[p]; ok {
+			return renamed
+		}
+		return p
+	}
+	err = commits.ForEach(func(commit *object.Commit) error {
+		newCommits = append(newCommits, commit.Hash)
+
+		paths, renamed, err := commitChangedPaths(commit)
+		if err != nil {
+			slog.Warn("Could not diff commit for history", "commit", commit.Hash.String(), "error", err)
+			return nil
+		}
+
+		revision := Revision{
+			Hash:    commit.Hash.String(),
+			Author:  commit.Author.Name,
+			Email:   commit.Author.Email,
+			Date:    commit.Author.When,
+			Message: strings.TrimSpace(commit.Message),
+		}
+		for _, p := range paths {
+			newRevisions[currentPath(p)] = append(newRevisions[currentPath(p)], revision)
+		}
+		for to, from := range renamed {
+			renames[from] = currentPath(to)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk git log: %w", err)
+	}
+
+	gitHistoryLock.Lock()
+	defer gitHistoryLock.Unlock()
+
+	for _, hash := range newCommits {
+		gitHistoryCommits[hash] = true
+	}
+	gitHistoryHead = ref.Hash()
+
+	// Files renamed since the last load take the history loaded for their previous path
+	for from, to := range renames {
+		if previous, ok := gitHistory[from]; ok {
+			newRevisions[to] = append(newRevisions[to], previous.Revisions...)
+			delete(gitHistory, from)
+		}
+	}
+
+	changed := make([]string, 0, len(newRevisions))
+	for p, revisions := range newRevisions {
+		// Replace rather than modify the history, since readers may hold the previous one
+		history := &FileHistory{Revisions: revisions}
+		if previous, ok := gitHistory[p]; ok {
+			history.Revisions = append(history.Revisions, previous.Revisions...)
+		}
+		sort.SliceStable(history.Revisions, func(i, j int) bool {
+			return history.Revisions[i].Date.After(history.Revisions[j].Date)
+		})
+		history.Updated = history.Revisions[0].Date
+		history.LastEditor = history.Revisions[0].Author
+		history.Created = history.Revisions[len(history.Revisions)-1].Date
+		gitHistory[p] = history
+		changed = append(changed, p)
+	}
+
+	slog.Info("Loaded git history", "commits", len(newCommits), "files", len(changed))
+	return changed, nil
+}
+
+// commitChangedPaths returns the paths added, modified or renamed by a commit, compared to
+// its first parent, and the previous path of each renamed file
+func commitChangedPaths(commit *object.Commit) ([]string, map[string]string, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, nil, err
+		}
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	changes, err := object.DiffTreeWithOptions(context.Background(), parentTree, tree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths := make([]string, 0, len(changes))
+	renamed := make(map[string]string)
+	for _, change := range changes {
+		// Deleted files have no destination and no longer have items
+		if change.To.Name == "" {
+			continue
+		}
+		paths = append(paths, change.To.Name)
+		if change.From.Name != "" && change.From.Name != change.To.Name {
+			renamed[change.To.Name] = change.From.Name
+		}
+	}
+	return paths, renamed, nil
+}
+
+// RefreshGitHistory loads new commits into the history and updates the timestamps of
+// the items whose source files they changed.  An item without date frontmatter is published
+// when its file was first committed, which a later walk can find, as after a rename.
+func RefreshGitHistory() {
+	changed, err := LoadGitHistory()
+	if err != nil {
+		slog.Error("Failed to refresh git history", "error", err)
+		return
+	}
+	for _, p := range changed {
+		var repo, source string
+		err := db.QueryRow("SELECT repo, source FROM items WHERE ltrim(source, '/') = ?", p).Scan(&repo, &source)
+		switch {
+		case err == sql.ErrNoRows:
+			// Not the source of an item
+			continue
+		case err != nil:
+			slog.Error("Failed to look up item", "source", p, "error", err)
+			continue
+		}
+
+		// The dates are worked out as when the item loads, from its frontmatter and history
+		item, err := LoadItem(repo, "", source)
+		if err != nil {
+			slog.Error("Failed to update item history", "source", p, "error", err)
+			continue
+		}
+		_, err = db.Exec("UPDATE items SET publishedon = ?, rawpublishedon = ?, createdon = ?, updatedon = ?, lasteditor = ? WHERE ltrim(source, '/') = ?",
+			item.Date, item.RawDate, item.Created, item.Updated, item.LastEditor, p)
+		if err != nil {
+			slog.Error("Failed to update item history", "source", p, "error", err)
+			continue
+		}
+		InvalidateRepoPages(repo)
+	}
+}
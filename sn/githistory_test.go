@@ -0,0 +1,223 @@
+package sn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/c4milo/afero2billy"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// commitFile writes a file to the worktree and commits it as the given author
+func commitFile(t *testing.T, fs afero.Fs, worktree *git.Worktree, filename string, content string, author string, when time.Time) {
+	t.Helper()
+	if err := afero.WriteFile(fs, filename, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", filename, err)
+	}
+	if _, err := worktree.Add(filename); err != nil {
+		t.Fatalf("Failed to add %s: %v", filename, err)
+	}
+	_, err := worktree.Commit("Update "+filename, &git.CommitOptions{
+		Author: &object.Signature{Name: author, Email: author + "@example.com", When: when},
+	})
+	if err != nil {
+		t.Fatalf("Failed to commit %s: %v", filename, err)
+	}
+}
+
+// moveFile renames a file in the worktree and commits the rename as the given author
+func moveFile(t *testing.T, worktree *git.Worktree, from string, to string, author string, when time.Time) {
+	t.Helper()
+	if _, err := worktree.Move(from, to); err != nil {
+		t.Fatalf("Failed to move %s: %v", from, err)
+	}
+	_, err := worktree.Commit("Move "+from, &git.CommitOptions{
+		Author: &object.Signature{Name: author, Email: author + "@example.com", When: when},
+	})
+	if err != nil {
+		t.Fatalf("Failed to commit the move of %s: %v", from, err)
+	}
+}
+
+// setupGitHistoryTest creates an in-memory git repo and clears the loaded history
+func setupGitHistoryTest(t *testing.T) (afero.Fs, *git.Worktree) {
+	t.Helper()
+	memFs := afero.NewMemMapFs()
+	repo, err := git.Init(memory.NewStorage(), afero2billy.New(memFs))
+	if err != nil {
+		t.Fatalf("Failed to init repo: %v", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Failed to get worktree: %v", err)
+	}
+
+	origRepo := Repo
+	Repo = repo
+	t.Cleanup(func() { Repo = origRepo })
+	gitHistory = make(map[string]*FileHistory)
+	gitHistoryCommits = make(map[plumbing.Hash]bool)
+	gitHistoryHead = plumbing.ZeroHash
+	return memFs, worktree
+}
+
+// TestLoadGitHistory verifies created/updated/last editor are derived from commits,
+// and that later commits are merged in incrementally
+func TestLoadGitHistory(t *testing.T) {
+	memFs, worktree := setupGitHistoryTest(t)
+
+	first := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(48 * time.Hour)
+	third := second.Add(48 * time.Hour)
+
+	commitFile(t, memFs, worktree, "posts/a.md", "one", "alice", first)
+	commitFile(t, memFs, worktree, "posts/b.md", "two", "alice", second)
+	commitFile(t, memFs, worktree, "posts/a.md", "one, edited", "bob", second)
+
+	changed, err := LoadGitHistory()
+	if err != nil {
+		t.Fatalf("LoadGitHistory() error = %v", err)
+	}
+	if len(changed) != 2 {
+		t.Errorf("Expected 2 changed paths, got %v", changed)
+	}
+
+	history, ok := GitFileHistory("/posts/a.md")
+	if !ok {
+		t.Fatal("Expected history for posts/a.md")
+	}
+	if !history.Created.Equal(first) {
+		t.Errorf("Created = %v, want %v", history.Created, first)
+	}
+	if !history.Updated.Equal(second) {
+		t.Errorf("Updated = %v, want %v", history.Updated, second)
+	}
+	if history.LastEditor != "bob" {
+		t.Errorf("LastEditor = %q, want bob", history.LastEditor)
+	}
+	if len(history.Revisions) != 2 {
+		t.Errorf("Expected 2 revisions, got %d", len(history.Revisions))
+	}
+
+	// Only the newly committed file should be reported on the next load
+	commitFile(t, memFs, worktree, "posts/b.md", "two, edited", "carol", third)
+	changed, err = LoadGitHistory()
+	if err != nil {
+		t.Fatalf("LoadGitHistory() error = %v", err)
+	}
+	if len(changed) != 1 || changed[0] != "posts/b.md" {
+		t.Errorf("Expected only posts/b.md to change, got %v", changed)
+	}
+
+	history, _ = GitFileHistory("posts/b.md")
+	if !history.Created.Equal(second) || !history.Updated.Equal(third) || history.LastEditor != "carol" {
+		t.Errorf("Unexpected history for posts/b.md: %+v", history)
+	}
+	if len(history.Revisions) != 2 || history.Revisions[0].Author != "carol" {
+		t.Errorf("Expected newest revision first, got %+v", history.Revisions)
+	}
+
+	// Nothing is walked again while the head stays put
+	changed, err = LoadGitHistory()
+	if err != nil || len(changed) != 0 {
+		t.Errorf("LoadGitHistory() = %v, %v with no new commits; want no changes", changed, err)
+	}
+}
+
+// TestLoadGitHistory_Renames verifies a renamed file keeps the history of its previous path,
+// whether it was renamed before the first load or after it
+func TestLoadGitHistory_Renames(t *testing.T) {
+	memFs, worktree := setupGitHistoryTest(t)
+
+	first := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(48 * time.Hour)
+	third := second.Add(48 * time.Hour)
+
+	commitFile(t, memFs, worktree, "posts/a.md", "the first post", "alice", first)
+	moveFile(t, worktree, "posts/a.md", "posts/b.md", "bob", second)
+	if _, err := LoadGitHistory(); err != nil {
+		t.Fatalf("LoadGitHistory() error = %v", err)
+	}
+	history, ok := GitFileHistory("posts/b.md")
+	if !ok || !history.Created.Equal(first) || !history.Updated.Equal(second) || len(history.Revisions) != 2 {
+		t.Errorf("Expected posts/b.md to keep the history of posts/a.md, got %+v", history)
+	}
+
+	moveFile(t, worktree, "posts/b.md", "posts/c.md", "carol", third)
+	changed, err := LoadGitHistory()
+	if err != nil {
+		t.Fatalf("LoadGitHistory() error = %v", err)
+	}
+	if len(changed) != 1 || changed[0] != "posts/c.md" {
+		t.Errorf("Expected only posts/c.md to change, got %v", changed)
+	}
+	history, ok = GitFileHistory("posts/c.md")
+	if !ok || !history.Created.Equal(first) || history.LastEditor != "carol" || len(history.Revisions) != 3 {
+		t.Errorf("Expected posts/c.md to keep the loaded history of posts/b.md, got %+v", history)
+	}
+	if _, ok := GitFileHistory("posts/b.md"); ok {
+		t.Error("Expected no history for the previous path")
+	}
+}
+
+// TestLoadGitHistory_NoRepo verifies history is a no-op outside of git mode
+func TestLoadGitHistory_NoRepo(t *testing.T) {
+	origRepo := Repo
+	Repo = nil
+	defer func() { Repo = origRepo }()
+
+	changed, err := LoadGitHistory()
+	if err != nil || changed != nil {
+		t.Errorf("LoadGitHistory() = %v, %v; want nil, nil", changed, err)
+	}
+}
+
+// TestRefreshGitHistory_Rename verifies that an item loaded before the commit of its rename
+// takes the creation and publication dates of its first commit once the rename is walked
+func TestRefreshGitHistory_Rename(t *testing.T) {
+	setupLoaderTest(t, nil)
+	memFs, worktree := setupGitHistoryTest(t)
+	// The worktree holds the files at paths relative to its root, as git names them
+	Vfs = memFs
+	viper.Set("repos.blog.path", "blog")
+
+	first := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(48 * time.Hour)
+	dated := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	commitFile(t, memFs, worktree, "blog/a.md", "---\ntitle: Renamed\n---\n\nA post.", "alice", first)
+	commitFile(t, memFs, worktree, "blog/dated.md", "---\ntitle: Dated\ndate: 2023-06-01T00:00:00Z\n---\n\nA post.", "alice", first)
+	if _, err := LoadGitHistory(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Move("blog/a.md", "blog/b.md"); err != nil {
+		t.Fatal(err)
+	}
+	DBLoadRepo("blog")
+
+	_, err := worktree.Commit("Move blog/a.md", &git.CommitOptions{
+		Author: &object.Signature{Name: "bob", Email: "bob@example.com", When: second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, memFs, worktree, "blog/dated.md", "---\ntitle: Dated\ndate: 2023-06-01T00:00:00Z\n---\n\nAn edited post.", "bob", second)
+	RefreshGitHistory()
+
+	repo := "blog"
+	items := make(map[string]Item)
+	for _, item := range ItemsFromItemQuery(ItemQuery{Page: 1, PerPage: 10, Repo: &repo}).Items {
+		items[item.Slug] = item
+	}
+	if renamed := items["b"]; !renamed.Created.Equal(first) || !renamed.Date.Equal(first) || renamed.LastEditor != "bob" {
+		t.Errorf("Renamed item created %v, published %v, last edited by %q; want %v, %v, bob", renamed.Created, renamed.Date, renamed.LastEditor, first, first)
+	}
+	if item := items["dated"]; !item.Date.Equal(dated) || !item.Created.Equal(first) || !item.Updated.Equal(second) {
+		t.Errorf("Dated item published %v, created %v, updated %v; want %v, %v, %v", item.Date, item.Created, item.Updated, dated, first, second)
+	}
+}
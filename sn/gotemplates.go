@@ -0,0 +1,194 @@
+package sn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"text/template/parse"
+	"time"
+
+	"github.com/ringmaster/Sn/sn/util"
+)
+
+// goTemplateExt is the extension of templates rendered with html/template instead of raymond
+const goTemplateExt = ".gohtml"
+
+func isGoTemplate(filename string) bool {
+	return strings.HasSuffix(filename, goTemplateExt)
+}
+
+// goTemplateFuncs are the html/template equivalents of the raymond helpers.  Block helpers
+// become functions that return values to range over or use with "with".
+func goTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"dateformat": func(t time.Time, format string) string {
+			return t.Format(format)
+		},
+		"s3": s3URL,
+		"more": func(html string, pcount int) template.HTML {
+			return template.HTML(moreHTML(html, pcount))
+		},
+		"summary":  summaryText,
+		"head":     headText,
+		"paginate": paginatePages,
+		"delimit": func(items []string, delimiter string) string {
+			return strings.Join(items, delimiter)
+		},
+		"permalink": func(item interface{}) string {
+			return util.GetItemURL(item)
+		},
+		"revisions": itemRevisions,
+		"ogimage":   SocialCardURL,
+		"markdown": func(source string) template.HTML {
+			return template.HTML(renderMarkdown(source))
+		},
+		"truncatewords": truncateWords,
+		"tagurl":        util.GetTagURL,
+		"authorurl":     util.GetAuthorURL,
+		"pageurl": func(current *url.URL, page int) string {
+			return pageURL(current, page)
+		},
+		"feedlinks": func(pathvars any) template.HTML {
+			return template.HTML(feedLinks(stringMap(pathvars)))
+		},
+		"timeago": func(t time.Time) string {
+			return relativeTime(t, time.Now())
+		},
+		// eq and gt replace the builtins, which fail on values of different types
+		"eq": func(a any, b any) bool {
+			return fmt.Sprint(a) == fmt.Sprint(b)
+		},
+		"gt": func(a any, b any) bool {
+			return compareValues(a, b) > 0
+		},
+		"json": func(value any) (template.JS, error) {
+			encoded, err := json.Marshal(value)
+			return template.JS(encoded), err
+		},
+		// query takes the parameters of the raymond helper as name, value pairs:
+		// {{with query "repo" "posts" "paginate_count" 5}}{{range .Items}}...{{end}}{{end}}
+		"query": func(pairs ...any) (ItemResult, error) {
+			if len(pairs)%2 != 0 {
+				return ItemResult{}, fmt.Errorf("query needs name, value pairs")
+			}
+			hash := make(map[string]interface{}, len(pairs)/2)
+			for i := 0; i < len(pairs); i += 2 {
+				hash[fmt.Sprint(pairs[i])] = pairs[i+1]
+			}
+			return ItemsFromItemQuery(itemQueryFromHash(hash)), nil
+		},
+	}
+}
+
+// parseGoPartial checks that a .gohtml partial parses on its own
+func parseGoPartial(name string, source string) error {
+	_, err := template.New(name).Funcs(goTemplateFuncs()).Parse(source)
+	return err
+}
+
+// parseGoTemplateFiles parses the .gohtml files of a route into one template set.  The files
+// are parsed last to first, so a {{define}} in a page overrides the {{block}} of the same
+// name in the layout after it, like the define and block helpers of raymond templates.
+// Partials are available by name to {{template "name" .}}.
+func parseGoTemplateFiles(filenames []string, sources []string, set *partialSet) (*template.Template, error) {
+	root := template.New("").Funcs(goTemplateFuncs())
+	if set != nil {
+		for name, source := range set.goPartials {
+			// Only the body of a partial is added, so the defines of other pages do not leak in
+			partial, err := template.New(name).Funcs(goTemplateFuncs()).Parse(source)
+			if err != nil {
+				return nil, err
+			}
+			if partial.Tree == nil {
+				continue
+			}
+			if _, err := root.AddParseTree(name, partial.Tree); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := len(filenames) - 1; i >= 0; i-- {
+		if _, err := root.New(filenames[i]).Parse(sources[i]); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// goTemplateQueryDeps returns the page dependencies of the query calls in a Go template set:
+// the repo of a call that names it with a string constant, or else every repo
+func goTemplateQueryDeps(tpl *template.Template) map[string]bool {
+	deps := make(map[string]bool)
+	var walk func(node parse.Node)
+	walkBranch := func(branch *parse.BranchNode) {
+		walk(branch.Pipe)
+		walk(branch.List)
+		walk(branch.ElseList)
+	}
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n != nil {
+				for _, child := range n.Nodes {
+					walk(child)
+				}
+			}
+		case *parse.PipeNode:
+			if n != nil {
+				for _, cmd := range n.Cmds {
+					walk(cmd)
+				}
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walkBranch(&n.BranchNode)
+		case *parse.RangeNode:
+			walkBranch(&n.BranchNode)
+		case *parse.WithNode:
+			walkBranch(&n.BranchNode)
+		case *parse.CommandNode:
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && ident.Ident == "query" {
+				deps[goQueryPageDep(n.Args[1:])] = true
+			}
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	for _, t := range tpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+	return deps
+}
+
+// goQueryPageDep returns the dependency of the name, value arguments of a query call
+func goQueryPageDep(args []parse.Node) string {
+	for i := 0; i+1 < len(args); i += 2 {
+		if name, ok := args[i].(*parse.StringNode); ok && name.Text == "repo" {
+			if repo, ok := args[i+1].(*parse.StringNode); ok {
+				return queryPageDep(repo.Text)
+			}
+			return pageDepAllRepos
+		}
+	}
+	return pageDepAllRepos
+}
+
+// execGoTemplate renders the files of a route in order, as raymond renders their concatenation
+func execGoTemplate(tpl *template.Template, filenames []string, context interface{}) (string, error) {
+	var buf bytes.Buffer
+	for _, filename := range filenames {
+		if err := tpl.ExecuteTemplate(&buf, filename, context); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
@@ -1,86 +1,723 @@
 package sn
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"html"
+	"log/slog"
 	"maps"
 	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/feeds"
 	"github.com/gorilla/mux"
+	"github.com/ringmaster/Sn/sn/util"
 	"github.com/spf13/viper"
 )
 
-func rssHandler(w http.ResponseWriter, r *http.Request) {
-	feed := feedHandler(r)
+const atomNamespace = "http://www.w3.org/2005/Atom"
 
-	w.Header().Add("Content-Type", "text/rss+xml")
-	w.Header().Add("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-	w.Header().Add("X-Frame-Options", "SAMEORIGIN")
-	w.Header().Add("X-Content-Type-Options", "nosniff")
-	w.Header().Add("Upgrade-Insecure-Requests", "1")
-	w.Header().Add("Referrer-Policy", "strict-origin-when-cross-origin")
-	w.Header().Add("Permissions-Policy", "geolocation=(self), microphone=()")
-	w.WriteHeader(200)
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
 
-	response, _ := feed.ToRss()
-	w.Write([]byte(response))
+// feedContentTypes are the content types of the feed formats a feed route can render
+var feedContentTypes = map[string]string{
+	"rss":  "application/rss+xml; charset=utf-8",
+	"atom": "application/atom+xml; charset=utf-8",
+	"json": "application/feed+json; charset=utf-8",
 }
 
-func atomHandler(w http.ResponseWriter, r *http.Request) {
-	feed := feedHandler(r)
+// feedExtensions are the path extensions that select a feed format
+var feedExtensions = map[string]string{
+	".xml":  "rss",
+	".rss":  "rss",
+	".atom": "atom",
+	".json": "json",
+}
 
-	w.Header().Add("Content-Type", "text/rss+xml")
-	w.Header().Add("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-	w.Header().Add("X-Frame-Options", "SAMEORIGIN")
-	w.Header().Add("X-Content-Type-Options", "nosniff")
-	w.Header().Add("Upgrade-Insecure-Requests", "1")
-	w.Header().Add("Referrer-Policy", "strict-origin-when-cross-origin")
-	w.Header().Add("Permissions-Policy", "geolocation=(self), microphone=()")
-	w.WriteHeader(200)
+// feedDocument is a feed before it is rendered in one of the feed formats
+type feedDocument struct {
+	Title       string
+	Description string
+	Link        string // The page the feed is a version of
+	Self        string
+	Hub         string // The WebSub hub that announces changes to the feed
+	Language    string
+	Icon        string
+	Author      feedAuthor
+	Podcast     *feedPodcast // The show, for podcast feeds
+	Pages       []feedPageLink
+	Archive     bool // Whether the document is an archive of older items
+	Updated     time.Time
+	Items       []feedItem
+}
 
-	response, _ := feed.ToAtom()
-	w.Write([]byte(response))
+type feedAuthor struct {
+	Name  string
+	Email string
+	URL   string
 }
 
-func feedHandler(r *http.Request) *feeds.Feed {
-	routeConfigLocation := fmt.Sprintf("routes.%s", mux.CurrentRoute(r).GetName())
+type feedItem struct {
+	ID         string
+	PermaLink  bool // Whether the ID is the URL of the item
+	URL        string
+	Title      string
+	Summary    string
+	Content    string // The full HTML, empty when the feed only has summaries
+	Authors    []feedAuthor
+	Categories []string
+	Published  time.Time
+	Updated    time.Time
+	Enclosure  *feedEnclosure
+	Episode    *feedEpisode
+}
 
-	context := viper.GetStringMap(routeConfigLocation)
-	context["config"] = CopyMap(viper.AllSettings())
-	context["pathvars"] = mux.Vars(r)
-	context["params"] = r.URL.Query()
-	context["post"] = nil
-	context["url"] = r.URL
+// feedRouteHandler renders the items of a feed route in the format the route selects with
+// format, or with the extension of its path.  A route that selects neither answers in the
+// format the request accepts, which is RSS unless it asks for Atom or JSON Feed.  Conditional
+// requests for a page whose items have not changed are answered with 304 Not Modified.
+func feedRouteHandler(w http.ResponseWriter, r *http.Request) {
+	routeName := mux.CurrentRoute(r).GetName()
+	format, negotiated := feedFormat(routeName, r)
 
-	feedParams := maps.Clone(viper.GetStringMap(fmt.Sprintf("%s.feed", routeConfigLocation)))
-	itemResult := ItemsFromOutvals(feedParams, context)
+	page, ok := newFeedPage(r, format)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
 
-	now := time.Now()
-	feed := &feeds.Feed{
-		Title:       viper.GetString("title"),
-		Link:        &feeds.Link{Href: viper.GetString("rooturl")},
-		Description: viper.GetString(fmt.Sprintf("%s.feed.description", routeConfigLocation)),
-		//Author:      &feeds.Author{Name: "Jason Moiron", Email: "jmoiron@jmoiron.net"},
-		Created: now,
+	w.Header().Set("ETag", page.ETag)
+	if !page.Modified.IsZero() {
+		w.Header().Set("Last-Modified", page.Modified.UTC().Format(http.TimeFormat))
+	}
+	if negotiated {
+		w.Header().Set("Vary", "Accept")
+	}
+	if feedNotModified(r, page.ETag, page.Modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
 
-	for _, item := range itemResult.Items {
-		routeParameters := map[string]string{}
-		routeParameters["slug"] = item.Slug
-		url := viper.GetString(fmt.Sprintf("%s.feed.itemurl", routeConfigLocation))
-		for k1, v1 := range routeParameters {
-			url = strings.ReplaceAll(url, fmt.Sprintf("{%s}", k1), v1)
+	feed := buildFeed(r, page)
+
+	var output []byte
+	var err error
+	switch format {
+	case "rss":
+		output, err = rssFeedOutput(feed)
+	case "atom":
+		output, err = atomFeedOutput(feed)
+	case "json":
+		output, err = jsonFeedOutput(feed)
+	default:
+		err = fmt.Errorf("unknown feed format %q", format)
+	}
+	if err != nil {
+		slog.Error("Error rendering feed", "route", routeName, "error", err)
+		http.Error(w, "Error rendering feed", http.StatusInternalServerError)
+		return
+	}
+
+	writeRouteHeaders(w, feedContentTypes[format])
+	if feed.Hub != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, feed.Hub))
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="self"`, feed.Self))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
+
+// feedFormat returns the format of a feed route, and whether it was negotiated from the
+// Accept header of the request
+func feedFormat(routeName string, r *http.Request) (string, bool) {
+	if format, ok := routeFeedFormat(routeName); ok {
+		return format, false
+	}
+
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "atom"):
+		return "atom", true
+	case strings.Contains(accept, "feed+json"):
+		return "json", true
+	}
+	return "rss", true
+}
+
+// routeFeedFormat returns the format a feed route selects with format or the extension of its
+// path, or rss and false when it selects neither
+func routeFeedFormat(routeName string) (string, bool) {
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+	if format := viper.GetString(fmt.Sprintf("%s.format", routeConfigLocation)); format != "" {
+		return strings.ToLower(format), true
+	}
+	if format, ok := feedExtensions[path.Ext(viper.GetString(fmt.Sprintf("%s.path", routeConfigLocation)))]; ok {
+		return format, true
+	}
+	return "rss", false
+}
+
+// feedTaxonomyFields are the item query fields that a feed route filters by when its path has
+// a variable of the same name and its feed query does not use the field already
+var feedTaxonomyFields = map[string][]string{
+	"tag":    {"tag", "category"},
+	"author": {"author"},
+}
+
+// feedQuery returns the item query of a feed route, filtered by its {tag} and {author} path
+// variables
+func feedQuery(routeName string) map[string]interface{} {
+	return pathFilteredQuery(routeName, "feed", feedTaxonomyFields)
+}
+
+// pathFilteredQuery returns the item query a route sets under queryName, filtered by the path
+// variables named in pathFields when the query does not use one of their fields already
+func pathFilteredQuery(routeName string, queryName string, pathFields map[string][]string) map[string]interface{} {
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+	query := maps.Clone(viper.GetStringMap(fmt.Sprintf("%s.%s", routeConfigLocation, queryName)))
+	for _, match := range routeVariable.FindAllStringSubmatch(viper.GetString(fmt.Sprintf("%s.path", routeConfigLocation)), -1) {
+		fields, ok := pathFields[match[1]]
+		if !ok {
+			continue
+		}
+		filtered := false
+		for _, field := range fields {
+			_, set := query[field]
+			filtered = filtered || set
 		}
+		if !filtered {
+			query[match[1]] = fmt.Sprintf("{%s}", match[1])
+		}
+	}
+	return query
+}
 
-		feed.Items = append(feed.Items, &feeds.Item{
-			Title:       item.Title,
-			Link:        &feeds.Link{Href: url},
-			Description: item.Html,
-			Created:     item.Date,
+// feedHasTaxonomy reports whether a feed route has a {tag} or {author} path variable
+func feedHasTaxonomy(routeName string) bool {
+	for _, match := range routeVariable.FindAllStringSubmatch(viper.GetString(fmt.Sprintf("routes.%s.path", routeName)), -1) {
+		if _, ok := feedTaxonomyFields[match[1]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// feedTitle returns the title of a feed route with its path variables replaced.  Without a
+// title setting, the feed of a tag or author is titled with the site title and its name.
+func feedTitle(routeName string, vars map[string]string) string {
+	return queryTitle(fmt.Sprintf("routes.%s.feed.title", routeName), vars)
+}
+
+// queryTitle returns the title setting at titleLocation with path variables replaced, or the
+// site title followed by the tag or author
+func queryTitle(titleLocation string, vars map[string]string) string {
+	if viper.IsSet(titleLocation) {
+		title := viper.GetString(titleLocation)
+		for name, value := range vars {
+			title = strings.ReplaceAll(title, fmt.Sprintf("{%s}", name), value)
+		}
+		return title
+	}
+	title := viper.GetString("title")
+	for _, name := range []string{"tag", "author"} {
+		if value, ok := vars[name]; ok {
+			title = fmt.Sprintf("%s: %s", title, value)
+		}
+	}
+	return title
+}
+
+// feedLinks returns the link elements that announce the feeds of a listing: every feed route
+// whose path variables are among the listing's, the ones for its tag or author first
+func feedLinks(pathvars map[string]string) string {
+	type feedLink struct {
+		vars int
+		html string
+	}
+	links := make([]feedLink, 0)
+	for _, routeName := range sortedRouteNames() {
+		routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+		if viper.GetString(fmt.Sprintf("%s.handler", routeConfigLocation)) != "feed" {
+			continue
+		}
+		vars := make(map[string]string)
+		complete := true
+		feedPath := routeVariable.ReplaceAllStringFunc(viper.GetString(fmt.Sprintf("%s.path", routeConfigLocation)), func(match string) string {
+			name := routeVariable.FindStringSubmatch(match)[1]
+			value, ok := pathvars[name]
+			if !ok {
+				complete = false
+				return match
+			}
+			vars[name] = value
+			return url.PathEscape(value)
 		})
+		if !complete {
+			continue
+		}
+		format, _ := routeFeedFormat(routeName)
+		contentType, _, _ := strings.Cut(feedContentTypes[format], ";")
+		links = append(links, feedLink{len(vars), fmt.Sprintf(`<link rel="alternate" type="%s" title="%s" href="%s">`,
+			contentType, html.EscapeString(feedTitle(routeName, vars)), html.EscapeString(rootURL()+feedPath))})
+	}
+
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].vars > links[j].vars
+	})
+	elements := make([]string, len(links))
+	for i, link := range links {
+		elements[i] = link.html
+	}
+	return strings.Join(elements, "\n")
+}
+
+// buildFeed runs the item query of a page of the route's feed and describes the feed with the
+// route's feed settings, falling back to the site's settings
+func buildFeed(r *http.Request, page *feedPage) *feedDocument {
+	routeName := mux.CurrentRoute(r).GetName()
+	feedConfigLocation := fmt.Sprintf("routes.%s.feed", routeName)
+
+	itemResult := ItemsFromItemQuery(page.Query)
+	if page.Archive {
+		// Archives are paginated from the oldest item, but list the newest first like the feed
+		slices.Reverse(itemResult.Items)
+	}
+
+	feedSetting := func(name string) string {
+		return ConfigStringDefault(fmt.Sprintf("%s.%s", feedConfigLocation, name), viper.GetString(name))
+	}
+
+	// The alternate link is the page the feed is a version of, which is the site by default
+	feed := &feedDocument{
+		Title:       feedTitle(routeName, mux.Vars(r)),
+		Description: ConfigStringDefault(fmt.Sprintf("%s.description", feedConfigLocation), viper.GetString("subtitle")),
+		Link:        absoluteFeedURL(ConfigStringDefault(fmt.Sprintf("%s.link", feedConfigLocation), viper.GetString("rooturl"))),
+		Self:        rootURL() + r.URL.RequestURI(),
+		Hub:         WebSubHubURL(),
+		Pages:       page.Links,
+		Archive:     page.Archive,
+		Language:    feedSetting("language"),
+		Icon:        absoluteFeedURL(feedSetting("icon")),
+		Author: feedAuthor{
+			Name:  feedSetting("author.name"),
+			Email: feedSetting("author.email"),
+			URL:   absoluteFeedURL(feedSetting("author.url")),
+		},
+		Items: make([]feedItem, 0, len(itemResult.Items)),
+	}
+	feed.Podcast = newFeedPodcast(feedConfigLocation, feed)
+
+	fullContent := viper.GetString(fmt.Sprintf("%s.content", feedConfigLocation)) != "summary"
+	itemURL := viper.GetString(fmt.Sprintf("%s.itemurl", feedConfigLocation))
+	for _, item := range itemResult.Items {
+		entry := newFeedItem(item, itemURL, fullContent, feed.Podcast != nil)
+		if entry.Updated.After(feed.Updated) {
+			feed.Updated = entry.Updated
+		}
+		feed.Items = append(feed.Items, entry)
 	}
 
 	return feed
 }
+
+// newFeedItem describes an item in a feed.  Its URL is where the site publishes it, unless the
+// feed sets an itemurl with {repo} and {slug} to replace.  Podcast feeds look up the length of
+// an enclosure that has no audio_length.
+func newFeedItem(item Item, itemURL string, fullContent bool, podcast bool) feedItem {
+	entry := feedItem{
+		URL:        util.GetItemURL(item),
+		Title:      item.Title,
+		Summary:    feedItemSummary(item),
+		Categories: nonNilStrings(item.Categories),
+		Published:  item.Date,
+		Updated:    itemLastMod(item),
+	}
+	if itemURL != "" {
+		entry.URL = strings.NewReplacer("{repo}", item.Repo, "{slug}", item.Slug).Replace(itemURL)
+	}
+	if fullContent {
+		entry.Content = item.Html
+	}
+	entry.ID, entry.PermaLink = feedItemID(item, entry.URL)
+	if entry.Enclosure = newFeedEnclosure(item, podcast); entry.Enclosure != nil {
+		entry.Episode = newFeedEpisode(item)
+	}
+
+	for _, author := range item.Authors {
+		entry.Authors = append(entry.Authors, feedAuthor{Name: author, URL: authorPageURL(author)})
+	}
+	return entry
+}
+
+// feedItemSummary returns the summary or description frontmatter of an item, or else a few
+// sentences of its content
+func feedItemSummary(item Item) string {
+	for _, key := range []string{"summary", "description"} {
+		if summary, ok := item.Frontmatter[key]; ok && summary != "" {
+			return summary
+		}
+	}
+	return util.GenerateSummaryFromHTML(item.Html)
+}
+
+// feedItemID returns an ID for an item that does not change when the routes that publish it
+// do: its guid frontmatter, or a tag URI of the site, its date, repo and slug.  Items without
+// a date are identified by their URL.
+func feedItemID(item Item, itemURL string) (string, bool) {
+	if guid := item.Frontmatter["guid"]; guid != "" {
+		return guid, false
+	}
+	date := item.Date
+	if date.IsZero() {
+		date = item.Created
+	}
+	site, err := url.Parse(viper.GetString("rooturl"))
+	if date.IsZero() || err != nil || site.Hostname() == "" {
+		return itemURL, true
+	}
+	return fmt.Sprintf("tag:%s,%s:%s/%s", site.Hostname(), date.Format("2006-01-02"), item.Repo, item.Slug), false
+}
+
+// authorPageURL returns the URL of the page that lists an author's items, or "" when no route does
+func authorPageURL(author string) string {
+	authorURL, _ := util.LookupAuthorURL(author)
+	return authorURL
+}
+
+// absoluteFeedURL prefixes a path with the rooturl, as feed readers need absolute URLs
+func absoluteFeedURL(link string) string {
+	if strings.HasPrefix(link, "/") && !strings.HasPrefix(link, "//") {
+		return rootURL() + link
+	}
+	return link
+}
+
+type rssDocument struct {
+	XMLName          xml.Name   `xml:"rss"`
+	Version          string     `xml:"version,attr"`
+	ContentNamespace string     `xml:"xmlns:content,attr"`
+	DCNamespace      string     `xml:"xmlns:dc,attr"`
+	AtomNamespace    string     `xml:"xmlns:atom,attr"`
+	ItunesNamespace  string     `xml:"xmlns:itunes,attr,omitempty"`
+	PodcastNamespace string     `xml:"xmlns:podcast,attr,omitempty"`
+	HistoryNamespace string     `xml:"xmlns:fh,attr,omitempty"`
+	Channel          rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	*feeds.RssFeed
+	AtomLinks []atomLink `xml:"atom:link"`
+	Archive   *struct{}  `xml:"fh:archive,omitempty"`
+	*rssPodcastChannel
+	Items []rssItem `xml:"item"`
+}
+
+// rssItem adds the authors and categories of an item, which RSS can repeat, to the item
+// gorilla/feeds renders
+type rssItem struct {
+	*feeds.RssItem
+	Creators   []string `xml:"dc:creator"`
+	Categories []string `xml:"category"`
+	*rssPodcastItem
+}
+
+// newFeedsFeed describes a feed for gorilla/feeds, which renders what RSS and Atom have in
+// common.  Item descriptions are summaries, with the full content alongside.
+func newFeedsFeed(feed *feedDocument) *feeds.Feed {
+	feedsFeed := &feeds.Feed{
+		Title:       feed.Title,
+		Link:        &feeds.Link{Href: feed.Link},
+		Description: feed.Description,
+		Updated:     feed.Updated,
+		Items:       make([]*feeds.Item, 0, len(feed.Items)),
+	}
+	if feed.Author.Name != "" || feed.Author.Email != "" {
+		feedsFeed.Author = &feeds.Author{Name: feed.Author.Name, Email: feed.Author.Email}
+	}
+	if feed.Icon != "" {
+		feedsFeed.Image = &feeds.Image{Url: feed.Icon, Title: feed.Title, Link: feed.Link}
+	}
+	for _, item := range feed.Items {
+		entry := &feeds.Item{
+			Title:       item.Title,
+			Link:        &feeds.Link{Href: item.URL, Type: "text/html"},
+			Description: item.Summary,
+			Id:          item.ID,
+			IsPermaLink: strconv.FormatBool(item.PermaLink),
+			Created:     item.Published,
+			Updated:     item.Updated,
+			Content:     item.Content,
+		}
+		if item.Enclosure != nil {
+			entry.Enclosure = &feeds.Enclosure{Url: item.Enclosure.URL, Length: strconv.FormatInt(item.Enclosure.Length, 10), Type: item.Enclosure.Type}
+		}
+		feedsFeed.Items = append(feedsFeed.Items, entry)
+	}
+	return feedsFeed
+}
+
+// rssFeedOutput renders an RSS 2.0 feed with an atom:link to itself.  Item descriptions are
+// summaries, with the full content in content:encoded.
+func rssFeedOutput(feed *feedDocument) ([]byte, error) {
+	channel := rssChannel{
+		RssFeed:   (&feeds.Rss{Feed: newFeedsFeed(feed)}).RssFeed(),
+		AtomLinks: []atomLink{{Href: feed.Self, Rel: "self", Type: "application/rss+xml"}},
+		Items:     make([]rssItem, 0, len(feed.Items)),
+	}
+	channel.Language = feed.Language
+	if feed.Author.Email == "" {
+		// managingEditor is an email address, so a name alone is left to Atom and JSON Feed
+		channel.ManagingEditor = ""
+	}
+	if feed.Hub != "" {
+		channel.AtomLinks = append(channel.AtomLinks, atomLink{Href: feed.Hub, Rel: "hub"})
+	}
+	for _, link := range feed.Pages {
+		channel.AtomLinks = append(channel.AtomLinks, atomLink{Href: link.Href, Rel: link.Rel, Type: "application/rss+xml"})
+	}
+
+	for i, item := range feed.Items {
+		entry := rssItem{
+			RssItem:    channel.RssFeed.Items[i],
+			Categories: item.Categories,
+		}
+		for _, author := range item.Authors {
+			entry.Creators = append(entry.Creators, author.Name)
+		}
+		if item.Enclosure != nil && feed.Podcast != nil {
+			entry.rssPodcastItem = newRSSPodcastItem(item.Episode)
+		}
+		channel.Items = append(channel.Items, entry)
+	}
+
+	document := rssDocument{
+		Version:          "2.0",
+		ContentNamespace: "http://purl.org/rss/1.0/modules/content/",
+		DCNamespace:      "http://purl.org/dc/elements/1.1/",
+		AtomNamespace:    atomNamespace,
+		Channel:          channel,
+	}
+	if feed.Archive {
+		document.HistoryNamespace = feedHistoryNamespace
+		document.Channel.Archive = &struct{}{}
+	}
+	if feed.Podcast != nil {
+		document.ItunesNamespace = itunesNamespace
+		document.PodcastNamespace = podcastNamespace
+		document.Channel.rssPodcastChannel = newRSSPodcastChannel(feed.Podcast)
+	}
+	return marshalFeedXML(document)
+}
+
+// atomLink is a link element of an Atom feed, also used as atom:link in RSS
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomDocument struct {
+	*feeds.AtomFeed
+	History string      `xml:"xmlns:fh,attr,omitempty"`
+	Lang    string      `xml:"xml:lang,attr,omitempty"`
+	Links   []atomLink  `xml:"link"`
+	Archive *struct{}   `xml:"fh:archive,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomEntry adds the authors and categories of an item, which Atom can repeat, to the entry
+// gorilla/feeds renders
+type atomEntry struct {
+	*feeds.AtomEntry
+	Authors    []feeds.AtomAuthor `xml:"author"`
+	Categories []atomCategory     `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// atomFeedOutput renders an Atom feed with links to itself and to the page it is a version of
+func atomFeedOutput(feed *feedDocument) ([]byte, error) {
+	document := atomDocument{
+		AtomFeed: (&feeds.Atom{Feed: newFeedsFeed(feed)}).AtomFeed(),
+		Lang:     feed.Language,
+		Links: []atomLink{
+			{Href: feed.Self, Rel: "self", Type: "application/atom+xml"},
+			{Href: feed.Link, Rel: "alternate", Type: "text/html"},
+		},
+		Entries: make([]atomEntry, 0, len(feed.Items)),
+	}
+	// Atom requires an updated time, which a feed with no dated items only has when rendered
+	updated := feed.Updated
+	if updated.IsZero() {
+		updated = time.Now().UTC()
+	}
+	document.Id = feed.Self
+	document.Updated = atomTime(updated)
+	document.Icon = feed.Icon
+	document.Author = nil
+	if feed.Author.Name != "" {
+		document.Author = &feeds.AtomAuthor{AtomPerson: feeds.AtomPerson{Name: feed.Author.Name, Email: feed.Author.Email, Uri: feed.Author.URL}}
+	}
+	if feed.Hub != "" {
+		document.Links = append(document.Links, atomLink{Href: feed.Hub, Rel: "hub"})
+	}
+	for _, link := range feed.Pages {
+		document.Links = append(document.Links, atomLink{Href: link.Href, Rel: link.Rel, Type: "application/atom+xml"})
+	}
+	if feed.Archive {
+		document.History = feedHistoryNamespace
+		document.Archive = &struct{}{}
+	}
+
+	for i, item := range feed.Items {
+		entry := atomEntry{AtomEntry: document.AtomFeed.Entries[i]}
+		entry.Updated = atomTime(item.Updated, item.Published, updated)
+		if !item.Published.IsZero() {
+			entry.Published = atomTime(item.Published)
+		}
+		if entry.Summary != nil {
+			// Summaries are plain text, unlike the content
+			entry.Summary.Type = "text"
+		}
+		for _, author := range item.Authors {
+			entry.Authors = append(entry.Authors, feeds.AtomAuthor{AtomPerson: feeds.AtomPerson{Name: author.Name, Uri: author.URL}})
+		}
+		for _, category := range item.Categories {
+			entry.Categories = append(entry.Categories, atomCategory{Term: category})
+		}
+		document.Entries = append(document.Entries, entry)
+	}
+
+	return marshalFeedXML(document)
+}
+
+// atomTime formats the first of the times that is known for Atom
+func atomTime(times ...time.Time) string {
+	for _, t := range times {
+		if !t.IsZero() {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+func marshalFeedXML(document interface{}) ([]byte, error) {
+	output, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), output...), nil
+}
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url,omitempty"`
+	FeedURL     string           `json:"feed_url,omitempty"`
+	NextURL     string           `json:"next_url,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Icon        string           `json:"icon,omitempty"`
+	Language    string           `json:"language,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Hubs        []jsonFeedHub    `json:"hubs,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedHub struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	Title         string               `json:"title,omitempty"`
+	ContentHTML   string               `json:"content_html,omitempty"`
+	ContentText   string               `json:"content_text,omitempty"`
+	Summary       string               `json:"summary,omitempty"`
+	DatePublished string               `json:"date_published,omitempty"`
+	DateModified  string               `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor     `json:"authors,omitempty"`
+	Tags          []string             `json:"tags,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAttachment struct {
+	URL               string `json:"url"`
+	MimeType          string `json:"mime_type"`
+	SizeInBytes       int64  `json:"size_in_bytes,omitempty"`
+	DurationInSeconds int    `json:"duration_in_seconds,omitempty"`
+}
+
+// jsonFeedOutput renders a JSON Feed 1.1 document.  An item without content_html has its
+// summary as content_text, as every item needs one of them.
+func jsonFeedOutput(feed *feedDocument) ([]byte, error) {
+	document := jsonFeed{
+		Version:     jsonFeedVersion,
+		Title:       feed.Title,
+		HomePageURL: feed.Link,
+		FeedURL:     feed.Self,
+		Description: feed.Description,
+		Icon:        feed.Icon,
+		Language:    feed.Language,
+		Items:       make([]jsonFeedItem, 0, len(feed.Items)),
+	}
+	if feed.Author.Name != "" {
+		document.Authors = []jsonFeedAuthor{{Name: feed.Author.Name, URL: feed.Author.URL}}
+	}
+	if feed.Hub != "" {
+		document.Hubs = []jsonFeedHub{{Type: "WebSub", URL: feed.Hub}}
+	}
+	// JSON Feed pages only link to the next page, and have no archives
+	for _, link := range feed.Pages {
+		if link.Rel == "next" {
+			document.NextURL = link.Href
+		}
+	}
+
+	for _, item := range feed.Items {
+		entry := jsonFeedItem{
+			ID:          item.ID,
+			URL:         item.URL,
+			Title:       item.Title,
+			ContentHTML: item.Content,
+			Summary:     item.Summary,
+			Tags:        item.Categories,
+		}
+		if entry.ContentHTML == "" {
+			entry.ContentText = item.Summary
+		}
+		if !item.Published.IsZero() {
+			entry.DatePublished = item.Published.Format(time.RFC3339)
+		}
+		if !item.Updated.IsZero() {
+			entry.DateModified = item.Updated.Format(time.RFC3339)
+		}
+		for _, author := range item.Authors {
+			entry.Authors = append(entry.Authors, jsonFeedAuthor{Name: author.Name, URL: author.URL})
+		}
+		if item.Enclosure != nil {
+			attachment := jsonFeedAttachment{URL: item.Enclosure.URL, MimeType: item.Enclosure.Type, SizeInBytes: item.Enclosure.Length}
+			attachment.DurationInSeconds, _ = durationSeconds(item.Episode.Duration)
+			entry.Attachments = []jsonFeedAttachment{attachment}
+		}
+		document.Items = append(document.Items, entry)
+	}
+
+	return json.MarshalIndent(document, "", "  ")
+}
@@ -0,0 +1,286 @@
+package sn
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+func setupFeedTest(t *testing.T) *mux.Router {
+	t.Helper()
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md": "---\ntitle: One\ndate: 2024-01-01\nauthors: [alice]\ntags: [go, feeds]\n---\n\nFirst post.",
+		"/blog/two.md": "---\ntitle: Two\ndate: 2024-01-02\nsummary: The second one\nguid: urn:post:2\n---\n\nSecond post.",
+	})
+	DBLoadRepo("blog")
+
+	viper.Set("rooturl", "https://example.com/")
+	viper.Set("title", "Sn")
+	feed := map[string]interface{}{"repo": "blog", "description": "Posts", "itemurl": "https://example.com/posts/{slug}", "link": "/blog"}
+	for name, path := range map[string]string{"rss": "/feed.xml", "atom": "/feed.atom", "json": "/feed.json", "negotiated": "/feed"} {
+		viper.Set("routes."+name+".path", path)
+		viper.Set("routes."+name+".handler", "feed")
+		viper.Set("routes."+name+".feed", maps.Clone(feed))
+	}
+	viper.Set("routes.format.path", "/atom")
+	viper.Set("routes.format.handler", "feed")
+	viper.Set("routes.format.format", "atom")
+	viper.Set("routes.format.feed", feed)
+
+	router := mux.NewRouter()
+	setupRoutes(router)
+	return router
+}
+
+func TestFeedFormats(t *testing.T) {
+	router := setupFeedTest(t)
+
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		contentType string
+		vary        bool
+	}{
+		{"rss extension", "/feed.xml", "", "application/rss+xml; charset=utf-8", false},
+		{"atom extension", "/feed.atom", "", "application/atom+xml; charset=utf-8", false},
+		{"json extension", "/feed.json", "", "application/feed+json; charset=utf-8", false},
+		{"format setting", "/atom", "application/rss+xml", "application/atom+xml; charset=utf-8", false},
+		{"default", "/feed", "", "application/rss+xml; charset=utf-8", true},
+		{"accepts atom", "/feed", "application/atom+xml", "application/atom+xml; charset=utf-8", true},
+		{"accepts json feed", "/feed", "application/feed+json", "application/feed+json; charset=utf-8", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != 200 || rec.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("GET %s = %d %q, want %q", tt.path, rec.Code, rec.Header().Get("Content-Type"), tt.contentType)
+			}
+			if (rec.Header().Get("Vary") == "Accept") != tt.vary {
+				t.Errorf("Vary = %q", rec.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestFeedLinks(t *testing.T) {
+	router := setupFeedTest(t)
+	get := func(path string) []byte {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Body.Bytes()
+	}
+
+	var rss struct {
+		Channel struct {
+			// The namespaced field comes first, as link would also match atom:link
+			AtomLinks []struct {
+				Href string `xml:"href,attr"`
+				Rel  string `xml:"rel,attr"`
+			} `xml:"http://www.w3.org/2005/Atom link"`
+			Link  string `xml:"link"`
+			Items []struct {
+				Title string `xml:"title"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(get("/feed.xml"), &rss); err != nil {
+		t.Fatalf("Invalid RSS: %v", err)
+	}
+	if rss.Channel.Link != "https://example.com/blog" || len(rss.Channel.AtomLinks) != 1 || rss.Channel.AtomLinks[0].Href != "https://example.com/feed.xml" || len(rss.Channel.Items) != 2 {
+		t.Errorf("Expected the alternate link, a self link and the items, got %+v", rss.Channel)
+	}
+
+	var atom struct {
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+	}
+	if err := xml.Unmarshal(get("/feed.atom"), &atom); err != nil {
+		t.Fatalf("Invalid Atom: %v", err)
+	}
+	links := make([]string, 0)
+	for _, link := range atom.Links {
+		links = append(links, link.Rel+" "+link.Href)
+	}
+	if strings.Join(links, ", ") != "self https://example.com/feed.atom, alternate https://example.com/blog" {
+		t.Errorf("Atom links = %v", links)
+	}
+
+	var feed jsonFeed
+	if err := json.Unmarshal(get("/feed.json"), &feed); err != nil {
+		t.Fatalf("Invalid JSON Feed: %v", err)
+	}
+	if feed.Version != jsonFeedVersion || feed.FeedURL != "https://example.com/feed.json" || feed.HomePageURL != "https://example.com/blog" {
+		t.Errorf("Unexpected JSON Feed %+v", feed)
+	}
+	if len(feed.Items) != 2 || feed.Items[0].ID != "urn:post:2" || !strings.HasPrefix(feed.Items[0].DatePublished, "2024-01-02T") {
+		t.Errorf("Unexpected JSON Feed items %+v", feed.Items)
+	}
+}
+
+func TestFeedItems(t *testing.T) {
+	router := setupFeedTest(t)
+	viper.Set("routes.04_posts.path", "/posts/{slug}")
+	viper.Set("routes.04_posts.handler", "posts")
+	viper.Set("routes.04_posts.out.posts", map[string]interface{}{"repo": "blog", "slug": "{slug}"})
+	viper.Set("routes.05_authors.path", "/author/{author}")
+	viper.Set("routes.05_authors.handler", "posts")
+	viper.Set("routes.05_authors.out.posts", map[string]interface{}{"repo": "blog", "author": "{author}"})
+	viper.Set("language", "en")
+	viper.Set("author.name", "Site Owner")
+	viper.Set("routes.json.feed.icon", "/icon.png")
+	viper.Set("routes.json.feed.itemurl", "")
+
+	get := func(path string) jsonFeed {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var feed jsonFeed
+		if err := json.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("Invalid JSON Feed %q: %v", rec.Body.String(), err)
+		}
+		return feed
+	}
+
+	feed := get("/feed.json")
+	if feed.Language != "en" || feed.Icon != "https://example.com/icon.png" || len(feed.Authors) != 1 || feed.Authors[0].Name != "Site Owner" {
+		t.Errorf("Expected the feed language, icon and author from config, got %+v", feed)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"url from the item route", feed.Items[1].URL, "https://example.com/posts/one"},
+		{"tag URI id", feed.Items[1].ID, "tag:example.com,2024-01-01:blog/one"},
+		{"guid frontmatter id", feed.Items[0].ID, "urn:post:2"},
+		{"authors", fmt.Sprint(feed.Items[1].Authors), "[{alice https://example.com/author/alice}]"},
+		{"tags", strings.Join(feed.Items[1].Tags, ","), "go,feeds"},
+		{"summary frontmatter", feed.Items[0].Summary, "The second one"},
+		{"generated summary", feed.Items[1].Summary, "First post."},
+		{"full content", strings.Contains(feed.Items[1].ContentHTML, "<p>First post.</p>"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+
+	viper.Set("routes.json.feed.content", "summary")
+	feed = get("/feed.json")
+	if feed.Items[0].ContentHTML != "" || feed.Items[0].ContentText != "The second one" {
+		t.Errorf("Expected only summaries in summary mode, got %+v", feed.Items[0])
+	}
+}
+
+func TestAtomFeedUpdated(t *testing.T) {
+	updated := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	output, err := atomFeedOutput(&feedDocument{
+		Title:   "Sn",
+		Updated: updated,
+		Items: []feedItem{
+			{ID: "dated", Title: "Dated", Published: updated.Add(-time.Hour)},
+			{ID: "undated", Title: "Undated"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var atom struct {
+		Entries []struct {
+			ID      string `xml:"id"`
+			Updated string `xml:"updated"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(output, &atom); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"dated": "2024-01-01T23:00:00Z", "undated": "2024-01-02T00:00:00Z"}
+	for _, entry := range atom.Entries {
+		if entry.Updated != want[entry.ID] {
+			t.Errorf("Updated of %s = %s, want %s", entry.ID, entry.Updated, want[entry.ID])
+		}
+	}
+
+	output, _ = atomFeedOutput(&feedDocument{Title: "Sn"})
+	if strings.Contains(string(output), "1970") || !strings.Contains(string(output), "<updated>") {
+		t.Errorf("Expected a feed without dates to be updated when rendered, got %s", output)
+	}
+}
+
+func TestTaxonomyFeeds(t *testing.T) {
+	setupFeedTest(t)
+	viper.Set("routes.tagfeed.path", "/tag/{tag}/feed.xml")
+	viper.Set("routes.tagfeed.handler", "feed")
+	viper.Set("routes.tagfeed.feed", map[string]interface{}{"repo": "blog"})
+	viper.Set("routes.authorfeed.path", "/author/{author}/feed.json")
+	viper.Set("routes.authorfeed.handler", "feed")
+	viper.Set("routes.authorfeed.feed", map[string]interface{}{"repo": "blog", "title": "Posts by {author}"})
+	router := mux.NewRouter()
+	setupRoutes(router)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		title  string
+		items  int
+	}{
+		{"tag", "/tag/go/feed.xml", 200, "Sn: go", 1},
+		{"author", "/author/alice/feed.json", 200, "Posts by alice", 1},
+		{"unknown tag", "/tag/missing/feed.xml", 404, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("GET %s = %d, want %d", tt.path, rec.Code, tt.status)
+			}
+			if tt.status != 200 {
+				return
+			}
+			var feed struct {
+				Title    string     `xml:"channel>title" json:"title"`
+				XMLItems []struct{} `xml:"channel>item"`
+				Items    []struct{} `json:"items"`
+			}
+			if strings.HasSuffix(tt.path, ".json") {
+				json.Unmarshal(rec.Body.Bytes(), &feed)
+			} else {
+				xml.Unmarshal(rec.Body.Bytes(), &feed)
+				feed.Items = feed.XMLItems
+			}
+			if feed.Title != tt.title || len(feed.Items) != tt.items {
+				t.Errorf("Got feed %q with %d items, want %q with %d", feed.Title, len(feed.Items), tt.title, tt.items)
+			}
+		})
+	}
+
+	links := feedLinks(map[string]string{"tag": "go", "page": "2"})
+	if !strings.HasPrefix(links, `<link rel="alternate" type="application/rss+xml" title="Sn: go" href="https://example.com/tag/go/feed.xml">`) {
+		t.Errorf("Expected the tag feed first, got %q", links)
+	}
+	if strings.Contains(links, "/author/") || !strings.Contains(links, `type="application/feed+json" title="Sn" href="https://example.com/feed.json"`) {
+		t.Errorf("Expected the site feeds without the author feed, got %q", links)
+	}
+}
@@ -90,6 +90,9 @@ func gitHandler(w http.ResponseWriter, r *http.Request) {
 	commit, _ := repo.CommitObject(ref.Hash())
 	slog.Info("commit", "commit_text", commit, "commit_hash", ref.Hash())
 
+	// Pick up the history of the pulled commits before items are reloaded
+	RefreshGitHistory()
+
 	// After git pull, reload repositories to pick up new files
 	slog.Info("Webhook: reloading repositories after git pull")
 
@@ -0,0 +1,216 @@
+package sn
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringmaster/Sn/sn/util"
+	"github.com/spf13/viper"
+)
+
+// jsonItemFields are the item fields a json route can output, by their name in the output
+var jsonItemFields = map[string]func(item Item) interface{}{
+	"id":          func(item Item) interface{} { return item.Id },
+	"title":       func(item Item) interface{} { return item.Title },
+	"slug":        func(item Item) interface{} { return item.Slug },
+	"repo":        func(item Item) interface{} { return item.Repo },
+	"url":         func(item Item) interface{} { return util.GetItemURL(item) },
+	"date":        func(item Item) interface{} { return jsonTime(item.Date) },
+	"created":     func(item Item) interface{} { return jsonTime(item.Created) },
+	"updated":     func(item Item) interface{} { return jsonTime(item.Updated) },
+	"last_editor": func(item Item) interface{} { return item.LastEditor },
+	"tags":        func(item Item) interface{} { return nonNilStrings(item.Categories) },
+	"authors":     func(item Item) interface{} { return nonNilStrings(item.Authors) },
+	"frontmatter": func(item Item) interface{} { return item.Frontmatter },
+	"raw":         func(item Item) interface{} { return item.Raw },
+	"html":        func(item Item) interface{} { return item.Html },
+}
+
+// defaultJSONItemFields are the item fields output by a route without item_fields
+var defaultJSONItemFields = []string{"title", "slug", "repo", "url", "date", "updated", "tags", "authors", "frontmatter", "html"}
+
+type jsonPagination struct {
+	Page    int    `json:"page"`
+	Pages   int    `json:"pages"`
+	PerPage int    `json:"per_page"`
+	Total   int    `json:"total"`
+	Prev    string `json:"prev,omitempty"`
+	Next    string `json:"next,omitempty"`
+}
+
+type jsonTerm struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	URL   string `json:"url"`
+}
+
+type jsonTaxonomy struct {
+	Tags    []jsonTerm `json:"tags"`
+	Authors []jsonTerm `json:"authors"`
+}
+
+// jsonItemResult is the output of an out query of a json route
+type jsonItemResult struct {
+	Items      []map[string]interface{} `json:"items"`
+	Pagination jsonPagination           `json:"pagination"`
+	Taxonomy   jsonTaxonomy             `json:"taxonomy"`
+}
+
+// jsonHandler runs the out queries of a route like templateHandler, and writes their
+// results as JSON instead of rendering templates
+func jsonHandler(w http.ResponseWriter, r *http.Request) {
+	routeName := mux.CurrentRoute(r).GetName()
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+
+	fields, err := jsonFields(routeConfigLocation, r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	context := map[string]interface{}{
+		"pathvars": mux.Vars(r),
+		"params":   r.URL.Query(),
+	}
+	response := make(map[string]interface{})
+	for outVarName := range viper.GetStringMap(fmt.Sprintf("%s.out", routeConfigLocation)) {
+		qlocation := fmt.Sprintf("%s.out.%s", routeConfigLocation, outVarName)
+
+		switch v := viper.Get(qlocation).(type) {
+		case bool, int:
+			response[outVarName] = v
+		case string:
+			response[outVarName] = routeStringValue(r, v)
+		default:
+			outvals := maps.Clone(viper.GetStringMap(qlocation))
+			qry := ItemQueryFromOutvals(outvals, context)
+			if qry.Fields == nil {
+				// Only load the bodies that will be output
+				qry.Fields = make([]string, 0)
+				for _, body := range []string{"raw", "html"} {
+					if slices.Contains(fields, body) {
+						qry.Fields = append(qry.Fields, body)
+					}
+				}
+			}
+
+			itemResult := ItemsFromItemQuery(qry)
+			if len(itemResult.Items) == 0 && outvals["404_on_empty"] != nil {
+				writeJSONError(w, http.StatusNotFound, "not found")
+				return
+			}
+			taxonomy, err := TaxonomyFromItemQuery(qry)
+			if err != nil {
+				slog.Error("Error loading taxonomy", "route", routeName, "error", err)
+				writeJSONError(w, http.StatusInternalServerError, "error loading items")
+				return
+			}
+			response[outVarName] = newJSONItemResult(itemResult, qry.PerPage, taxonomy, fields, r.URL)
+		}
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		slog.Error("Error encoding json", "route", routeName, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "error encoding items")
+		return
+	}
+	writeRouteHeaders(w, "application/json")
+	w.Write(body)
+}
+
+// jsonFields returns the item fields to output: those in the route's item_fields, narrowed
+// to the comma separated "fields" query parameter when the request has one
+func jsonFields(routeConfigLocation string, params url.Values) ([]string, error) {
+	allowed := defaultJSONItemFields
+	if viper.IsSet(fmt.Sprintf("%s.item_fields", routeConfigLocation)) {
+		allowed = viper.GetStringSlice(fmt.Sprintf("%s.item_fields", routeConfigLocation))
+	}
+	for _, field := range allowed {
+		if _, ok := jsonItemFields[field]; !ok {
+			return nil, fmt.Errorf("unknown item field %q in item_fields", field)
+		}
+	}
+	if !params.Has("fields") {
+		return allowed, nil
+	}
+
+	fields := make([]string, 0)
+	for _, field := range strings.Split(params.Get("fields"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || slices.Contains(fields, field) {
+			continue
+		}
+		if !slices.Contains(allowed, field) {
+			return nil, fmt.Errorf("unknown field %q, available fields are: %s", field, strings.Join(allowed, ", "))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func newJSONItemResult(result ItemResult, perPage int, taxonomy Taxonomy, fields []string, current *url.URL) jsonItemResult {
+	output := jsonItemResult{
+		Items: make([]map[string]interface{}, len(result.Items)),
+		Pagination: jsonPagination{
+			Page:    result.Page,
+			Pages:   result.Pages,
+			PerPage: perPage,
+			Total:   result.Total,
+		},
+		Taxonomy: jsonTaxonomy{
+			Tags:    make([]jsonTerm, len(taxonomy.Categories)),
+			Authors: make([]jsonTerm, len(taxonomy.Authors)),
+		},
+	}
+
+	for i, item := range result.Items {
+		output.Items[i] = make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			output.Items[i][field] = jsonItemFields[field](item)
+		}
+	}
+	if result.Page > 1 && result.Page <= result.Pages {
+		output.Pagination.Prev = pageURL(current, result.Page-1)
+	}
+	if result.Page < result.Pages {
+		output.Pagination.Next = pageURL(current, result.Page+1)
+	}
+	for i, category := range taxonomy.Categories {
+		output.Taxonomy.Tags[i] = jsonTerm{Name: category.Name, Count: category.Count, URL: util.GetTagURL(category.Name)}
+	}
+	for i, author := range taxonomy.Authors {
+		output.Taxonomy.Authors[i] = jsonTerm{Name: author.Name, Count: author.Count, URL: util.GetAuthorURL(author.Name)}
+	}
+	return output
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeRouteHeaders(w, "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// jsonTime returns a time for JSON output, or nil for the zero time
+func jsonTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// nonNilStrings returns a list that is output as [] rather than null when it is empty
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,87 @@
+package sn
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+func TestJSONHandler(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md":   "---\ntitle: One\ndate: 2024-01-01\ntags: [go]\nauthors: [alice]\n---\n\nFirst post.",
+		"/blog/two.md":   "---\ntitle: Two\ndate: 2024-01-02\ntags: [go, sqlite]\n---\n\nSecond post.",
+		"/blog/three.md": "---\ntitle: Three\ndate: 2024-01-03\n---\n\nThird post.",
+	})
+	DBLoadRepo("blog")
+
+	viper.Set("rooturl", "https://example.com/")
+	viper.Set("routes.api.path", "/api")
+	viper.Set("routes.api.handler", "json")
+	viper.Set("routes.api.item_fields", []string{"title", "slug", "tags", "html"})
+	viper.Set("routes.api.out.posts", map[string]interface{}{"repo": "blog", "paginate_count": 2})
+	viper.Set("routes.api.out.label", "{params.label}")
+	viper.Set("routes.item.path", "/api/{slug}")
+	viper.Set("routes.item.handler", "json")
+	viper.Set("routes.item.out.posts", map[string]interface{}{"repo": "blog", "slug": "{slug}", "404_on_empty": "fof"})
+
+	router := mux.NewRouter()
+	setupRoutes(router)
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]json.RawMessage) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("GET %s returned invalid json %q: %v", path, rec.Body.String(), err)
+		}
+		return rec, body
+	}
+
+	rec, body := get("/api?label=latest")
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", rec.Header().Get("Content-Type"))
+	}
+	if string(body["label"]) != `"latest"` {
+		t.Errorf("label = %s, want the substituted string", body["label"])
+	}
+	var posts jsonItemResult
+	json.Unmarshal(body["posts"], &posts)
+	if len(posts.Items) != 2 || posts.Items[0]["title"] != "Three" || posts.Items[0]["html"] == nil || posts.Items[0]["url"] != nil {
+		t.Errorf("Expected the first page of items with the route's item_fields, got %v", posts.Items)
+	}
+	expectedPagination := jsonPagination{Page: 1, Pages: 2, PerPage: 2, Total: 3, Next: "/api?label=latest&page=2"}
+	if posts.Pagination != expectedPagination {
+		t.Errorf("pagination = %+v, want %+v", posts.Pagination, expectedPagination)
+	}
+	if len(posts.Taxonomy.Tags) != 2 || posts.Taxonomy.Tags[0] != (jsonTerm{Name: "go", Count: 2, URL: "https://example.com/tag/go"}) {
+		t.Errorf("Expected tag counts across every page, got %+v", posts.Taxonomy.Tags)
+	}
+	if len(posts.Taxonomy.Authors) != 1 || posts.Taxonomy.Authors[0].Name != "alice" {
+		t.Errorf("Expected author counts, got %+v", posts.Taxonomy.Authors)
+	}
+
+	_, body = get("/api?fields=slug,tags&page=2")
+	posts = jsonItemResult{}
+	json.Unmarshal(body["posts"], &posts)
+	if len(posts.Items) != 1 || len(posts.Items[0]) != 2 || posts.Items[0]["slug"] != "one" {
+		t.Errorf("Expected only the requested fields, got %v", posts.Items)
+	}
+	if posts.Pagination.Prev != "/api?fields=slug%2Ctags&page=1" || posts.Pagination.Next != "" {
+		t.Errorf("Expected a link to the previous page only, got %+v", posts.Pagination)
+	}
+
+	if rec, body := get("/api?fields=raw"); rec.Code != http.StatusBadRequest || body["error"] == nil {
+		t.Errorf("Expected a field outside of item_fields to be rejected, got %d %v", rec.Code, body)
+	}
+	if rec, _ := get("/api/missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404_on_empty to answer 404, got %d", rec.Code)
+	}
+	if rec, body := get("/api/two"); rec.Code != http.StatusOK || len(body) != 1 {
+		t.Errorf("Expected a single item, got %d %v", rec.Code, body)
+	}
+}
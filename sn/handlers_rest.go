@@ -136,6 +136,7 @@ func repoRestPostHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error": "Failed to write markdown file"}`, http.StatusInternalServerError)
 		return
 	}
+	NotifyWebSub(restItem(payload.Repo, payload.Slug, payload.Tags, username))
 
 	// Parse the date for ActivityPub
 	publishedTime, err := time.Parse("2006-01-02 15:04:05", payload.Date)
@@ -349,6 +350,7 @@ func repoRestPutHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error": "Failed to write markdown file"}`, http.StatusInternalServerError)
 		return
 	}
+	NotifyWebSub(restItem(repo, slug, payload.Tags, username))
 
 	// Parse the date for ActivityPub
 	publishedTime, err := time.Parse("2006-01-02 15:04:05", payload.Date)
@@ -553,3 +555,15 @@ func postsListHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// restItem returns the repo, slug, tags and author of a post written by the REST API, which
+// are enough to find the feeds that list it before the file watcher loads it
+func restItem(repo string, slug string, tags string, username string) Item {
+	item := Item{Repo: repo, Slug: slug, Categories: make([]string, 0), Authors: []string{username}}
+	if tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			item.Categories = append(item.Categories, strings.TrimSpace(tag))
+		}
+	}
+	return item
+}
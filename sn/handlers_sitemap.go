@@ -0,0 +1,255 @@
+package sn
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ringmaster/Sn/sn/util"
+	"github.com/spf13/viper"
+)
+
+// sitemapMaxURLs is the most URLs the sitemap protocol allows in one sitemap file
+const sitemapMaxURLs = 50000
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// sitemapHandler lists the listing pages, items and taxonomy pages of the site.  When there
+// are more URLs than fit in one sitemap, it answers with a sitemap index of its pages, which
+// are requested with ?page=n.
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	routeName := mux.CurrentRoute(r).GetName()
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+
+	maxURLs := viper.GetInt(fmt.Sprintf("%s.max_urls", routeConfigLocation))
+	if maxURLs <= 0 || maxURLs > sitemapMaxURLs {
+		maxURLs = sitemapMaxURLs
+	}
+
+	urls := sitemapURLs()
+	pages := int(math.Ceil(float64(len(urls)) / float64(maxURLs)))
+
+	var document interface{}
+	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil {
+		if page < 1 || page > pages {
+			http.NotFound(w, r)
+			return
+		}
+		document = sitemapURLSet{Xmlns: sitemapNamespace, URLs: urls[(page-1)*maxURLs : min(page*maxURLs, len(urls))]}
+	} else if pages > 1 {
+		index := sitemapIndex{Xmlns: sitemapNamespace}
+		partURL := *r.URL
+		for page := 1; page <= pages; page++ {
+			partURL.RawQuery = url.Values{"page": {strconv.Itoa(page)}}.Encode()
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: rootURL() + partURL.RequestURI()})
+		}
+		document = index
+	} else {
+		document = sitemapURLSet{Xmlns: sitemapNamespace, URLs: urls}
+	}
+
+	output, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		http.Error(w, "Error encoding sitemap", http.StatusInternalServerError)
+		return
+	}
+	writeRouteHeaders(w, "application/xml")
+	w.Write([]byte(xml.Header))
+	w.Write(output)
+}
+
+// robotsHandler writes a robots.txt that keeps crawlers out of the routes that are not
+// pages of the site, and points them at the sitemap
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	routeName := mux.CurrentRoute(r).GetName()
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+
+	disallow := viper.GetStringSlice(fmt.Sprintf("%s.disallow", routeConfigLocation))
+	sitemaps := make([]string, 0)
+	for _, name := range sortedRouteNames() {
+		location := fmt.Sprintf("routes.%s", name)
+		routePath := viper.GetString(fmt.Sprintf("%s.path", location))
+		switch viper.GetString(fmt.Sprintf("%s.handler", location)) {
+		case "frontend", "upload", "git", "debug", "websub":
+			disallow = append(disallow, routePath)
+		case "sitemap":
+			sitemaps = append(sitemaps, rootURL()+routePath)
+		}
+	}
+
+	var robots strings.Builder
+	robots.WriteString("User-agent: *\n")
+	if len(disallow) == 0 {
+		robots.WriteString("Disallow:\n")
+	}
+	for _, path := range disallow {
+		fmt.Fprintf(&robots, "Disallow: %s\n", path)
+	}
+	for _, sitemap := range sitemaps {
+		fmt.Fprintf(&robots, "\nSitemap: %s\n", sitemap)
+	}
+
+	writeRouteHeaders(w, "text/plain; charset=utf-8")
+	w.Write([]byte(robots.String()))
+}
+
+// sitemapURLs returns the URLs of the listing pages without path variables, every
+// indexable item, and the tag and author pages of those items
+func sitemapURLs() []sitemapURL {
+	urls := make([]sitemapURL, 0)
+	seen := make(map[string]bool)
+	add := func(loc string, lastMod time.Time) {
+		if seen[loc] {
+			return
+		}
+		seen[loc] = true
+		entry := sitemapURL{Loc: loc}
+		if !lastMod.IsZero() {
+			entry.LastMod = lastMod.Format(time.RFC3339)
+		}
+		urls = append(urls, entry)
+	}
+
+	for _, routeName := range sortedRouteNames() {
+		if sitemapListsRoute(routeName) {
+			add(rootURL()+viper.GetString(fmt.Sprintf("routes.%s.path", routeName)), time.Time{})
+		}
+	}
+
+	tags := make(map[string]bool)
+	authors := make(map[string]bool)
+	repos := make([]string, 0)
+	for repo := range viper.GetStringMap("repos") {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		if !repoIsPublic(repo) {
+			continue
+		}
+		result := ItemsFromItemQuery(ItemQuery{Repo: &repo, Page: 1, PerPage: math.MaxInt32, Fields: []string{}})
+		for _, item := range result.Items {
+			if !itemIsIndexable(item) {
+				continue
+			}
+			add(util.GetItemURL(item), itemLastMod(item))
+			for _, tag := range item.Categories {
+				tags[tag] = true
+			}
+			for _, author := range item.Authors {
+				authors[author] = true
+			}
+		}
+	}
+
+	for _, taxonomy := range []struct {
+		names  map[string]bool
+		lookup func(string) (string, bool)
+	}{{tags, util.LookupTagURL}, {authors, util.LookupAuthorURL}} {
+		names := make([]string, 0, len(taxonomy.names))
+		for name := range taxonomy.names {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if loc, ok := taxonomy.lookup(name); ok {
+				add(loc, time.Time{})
+			}
+		}
+	}
+
+	return urls
+}
+
+// sitemapListsRoute reports whether a route renders one fixed page worth listing: a posts
+// route without path variables, query parameters or an error status, unless it sets
+// sitemap: false
+func sitemapListsRoute(routeName string) bool {
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+	if viper.GetString(fmt.Sprintf("%s.handler", routeConfigLocation)) != "posts" {
+		return false
+	}
+	if viper.IsSet(fmt.Sprintf("%s.sitemap", routeConfigLocation)) && !viper.GetBool(fmt.Sprintf("%s.sitemap", routeConfigLocation)) {
+		return false
+	}
+	if status := viper.GetInt(fmt.Sprintf("%s.http_status", routeConfigLocation)); status != 0 && status != http.StatusOK {
+		return false
+	}
+	if routeVariable.MatchString(viper.GetString(fmt.Sprintf("%s.path", routeConfigLocation))) {
+		return false
+	}
+	for _, query := range routeOutQueries(routeName) {
+		if paramReference.MatchString(fmt.Sprint(query)) {
+			return false
+		}
+	}
+	return true
+}
+
+// repoIsPublic reports whether the items of a repo are published at their own URLs.  A repo
+// is not public when it sets public: false, or when no route displays its items by slug.
+func repoIsPublic(repo string) bool {
+	publicLocation := fmt.Sprintf("repos.%s.public", repo)
+	if viper.IsSet(publicLocation) && !viper.GetBool(publicLocation) {
+		return false
+	}
+	return util.GetRoutePatternForRepo(repo) != ""
+}
+
+// itemIsIndexable reports whether search engines may list an item, which excludes drafts
+// and items with noindex frontmatter
+func itemIsIndexable(item Item) bool {
+	for _, key := range []string{"draft", "noindex"} {
+		if excluded, err := strconv.ParseBool(item.Frontmatter[key]); err == nil && excluded {
+			return false
+		}
+	}
+	return true
+}
+
+// itemLastMod returns when an item last changed: its last commit from git history, or
+// else its date
+func itemLastMod(item Item) time.Time {
+	if item.Updated.After(item.Date) {
+		return item.Updated
+	}
+	return item.Date
+}
+
+// rootURL returns the rooturl of the site without a trailing slash
+func rootURL() string {
+	return strings.TrimSuffix(viper.GetString("rooturl"), "/")
+}
+
+func sortedRouteNames() []string {
+	routeNames := make([]string, 0)
+	for routeName := range viper.GetStringMap("routes") {
+		routeNames = append(routeNames, routeName)
+	}
+	sort.Strings(routeNames)
+	return routeNames
+}
@@ -0,0 +1,119 @@
+package sn
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+func setupSitemapTest(t *testing.T) *mux.Router {
+	t.Helper()
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md":    "---\ntitle: One\ndate: 2024-01-01\ntags: [go]\n---\n\nFirst post.",
+		"/blog/two.md":    "---\ntitle: Two\ndate: 2024-01-02\ntags: [secret]\ndraft: true\n---\n\nSecond post.",
+		"/blog/three.md":  "---\ntitle: Three\ndate: 2024-01-03\nnoindex: true\n---\n\nThird post.",
+		"/notes/note.md":  "---\ntitle: Note\n---\n\nA private note.",
+		"/hidden/page.md": "---\ntitle: Page\n---\n\nA page in a repo that is not public.",
+	})
+	viper.Set("repos.notes.path", "/notes")
+	viper.Set("repos.hidden.path", "/hidden")
+	viper.Set("repos.hidden.public", false)
+	// Without git history an item was updated when its file changed, here before its date
+	modified := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	Vfs.Chtimes("/blog/one.md", modified, modified)
+	DBLoadRepo("blog")
+	DBLoadRepo("notes")
+	DBLoadRepo("hidden")
+
+	viper.Set("rooturl", "https://example.com/")
+	viper.Set("routes.01_index.path", "/")
+	viper.Set("routes.01_index.handler", "posts")
+	viper.Set("routes.01_index.out.posts.repo", "blog")
+	viper.Set("routes.02_search.path", "/search")
+	viper.Set("routes.02_search.handler", "posts")
+	viper.Set("routes.02_search.out.posts", map[string]interface{}{"repo": "blog", "search": "{params.q}"})
+	viper.Set("routes.03_tags.path", "/tag/{tag}")
+	viper.Set("routes.03_tags.handler", "posts")
+	viper.Set("routes.03_tags.out.posts", map[string]interface{}{"repo": "blog", "tag": "{tag}"})
+	viper.Set("routes.04_posts.path", "/posts/{slug}")
+	viper.Set("routes.04_posts.handler", "posts")
+	viper.Set("routes.04_posts.out.posts", map[string]interface{}{"repo": "blog", "slug": "{slug}"})
+	viper.Set("routes.05_hidden.path", "/hidden/{slug}")
+	viper.Set("routes.05_hidden.handler", "posts")
+	viper.Set("routes.05_hidden.out.posts", map[string]interface{}{"repo": "hidden", "slug": "{slug}"})
+	viper.Set("routes.06_sitemap.path", "/sitemap.xml")
+	viper.Set("routes.06_sitemap.handler", "sitemap")
+	viper.Set("routes.07_robots.path", "/robots.txt")
+	viper.Set("routes.07_robots.handler", "robots")
+	viper.Set("routes.07_robots.disallow", []string{"/search"})
+	viper.Set("routes.08_debug.path", "/_/debug")
+	viper.Set("routes.08_debug.handler", "debug")
+
+	router := mux.NewRouter()
+	setupRoutes(router)
+	return router
+}
+
+func TestSitemapHandler(t *testing.T) {
+	router := setupSitemapTest(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/sitemap.xml", nil))
+	var sitemap sitemapURLSet
+	if err := xml.Unmarshal(rec.Body.Bytes(), &sitemap); err != nil {
+		t.Fatalf("Invalid sitemap %q: %v", rec.Body.String(), err)
+	}
+
+	locs := make([]string, len(sitemap.URLs))
+	for i, entry := range sitemap.URLs {
+		locs[i] = entry.Loc
+	}
+	expected := []string{"https://example.com/", "https://example.com/posts/one", "https://example.com/tag/go"}
+	if strings.Join(locs, " ") != strings.Join(expected, " ") {
+		t.Errorf("sitemap URLs = %v, want %v", locs, expected)
+	}
+	if !strings.HasPrefix(sitemap.URLs[1].LastMod, "2024-01-01T") {
+		t.Errorf("Expected the item date as lastmod, got %q", sitemap.URLs[1].LastMod)
+	}
+
+	viper.Set("routes.06_sitemap.max_urls", 2)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/sitemap.xml", nil))
+	var index sitemapIndex
+	if err := xml.Unmarshal(rec.Body.Bytes(), &index); err != nil || len(index.Sitemaps) != 2 {
+		t.Fatalf("Expected a sitemap index of two sitemaps, got %q", rec.Body.String())
+	}
+	if index.Sitemaps[1].Loc != "https://example.com/sitemap.xml?page=2" {
+		t.Errorf("Expected the second sitemap at ?page=2, got %q", index.Sitemaps[1].Loc)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/sitemap.xml?page=2", nil))
+	sitemap = sitemapURLSet{}
+	xml.Unmarshal(rec.Body.Bytes(), &sitemap)
+	if len(sitemap.URLs) != 1 || sitemap.URLs[0].Loc != "https://example.com/tag/go" {
+		t.Errorf("Expected the rest of the URLs on the second sitemap, got %v", sitemap.URLs)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/sitemap.xml?page=3", nil))
+	if rec.Code != 404 {
+		t.Errorf("Expected 404 past the last sitemap, got %d", rec.Code)
+	}
+}
+
+func TestRobotsHandler(t *testing.T) {
+	router := setupSitemapTest(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/robots.txt", nil))
+	expected := "User-agent: *\nDisallow: /search\nDisallow: /_/debug\n\nSitemap: https://example.com/sitemap.xml\n"
+	if rec.Body.String() != expected {
+		t.Errorf("robots.txt = %q, want %q", rec.Body.String(), expected)
+	}
+}
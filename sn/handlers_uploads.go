@@ -59,6 +59,7 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	uploadConfigLocation := fmt.Sprintf("%s.s3", routeConfigLocation)
 	spaceConfigName := viper.GetString(uploadConfigLocation)
 	spaceConfData := viper.GetStringMapString(fmt.Sprintf("s3.%s", spaceConfigName))
+	spaceConf := spacesConfig(spaceConfigName)
 
 	uploadPasswordHash := viper.GetString(fmt.Sprintf("%s.passwordhash", routeConfigLocation))
 
@@ -87,14 +88,6 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	spaceConf := SpacesConfig{
-		SpaceName:   spaceConfData["spacename"],
-		Endpoint:    spaceConfData["endpoint"],
-		Region:      spaceConfData["region"],
-		AccessKeyID: spaceConfData["accesskeyid"],
-		SecretKey:   spaceConfData["secretkey"],
-	}
-
 	err = uploadToSpaces(file, header.Filename, spaceConf, contentType)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -107,7 +100,20 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(output))
 }
 
-func uploadToSpaces(file io.ReadSeeker, filename string, spaceConf SpacesConfig, contentType string) error {
+// spacesConfig returns the settings of the s3 bucket with a name
+func spacesConfig(name string) SpacesConfig {
+	spaceConfData := viper.GetStringMapString(fmt.Sprintf("s3.%s", name))
+	return SpacesConfig{
+		SpaceName:   spaceConfData["spacename"],
+		Endpoint:    spaceConfData["endpoint"],
+		Region:      spaceConfData["region"],
+		AccessKeyID: spaceConfData["accesskeyid"],
+		SecretKey:   spaceConfData["secretkey"],
+	}
+}
+
+// newSpacesClient connects to the endpoint of an s3 bucket with its credentials
+func newSpacesClient(spaceConf SpacesConfig) (*s3.S3, error) {
 	s3Config := &aws.Config{
 		Credentials:      credentials.NewStaticCredentials(spaceConf.AccessKeyID, spaceConf.SecretKey, ""), // Specifies your credentials.
 		Endpoint:         aws.String(spaceConf.Endpoint),                                                   // Find your endpoint in the control panel, under Settings. Prepend "https://".
@@ -119,9 +125,16 @@ func uploadToSpaces(file io.ReadSeeker, filename string, spaceConf SpacesConfig,
 	newSession, err := session.NewSession(s3Config)
 	if err != nil {
 		slog.Error("Could not create new S3 session", "error", err.Error())
+		return nil, err
+	}
+	return s3.New(newSession), nil
+}
+
+func uploadToSpaces(file io.ReadSeeker, filename string, spaceConf SpacesConfig, contentType string) error {
+	s3Client, err := newSpacesClient(spaceConf)
+	if err != nil {
 		return err
 	}
-	s3Client := s3.New(newSession)
 
 	// Step 4: Define the parameters of the object you want to upload.
 	object := s3.PutObjectInput{
@@ -141,7 +154,7 @@ func uploadToSpaces(file io.ReadSeeker, filename string, spaceConf SpacesConfig,
 	_, err = s3Client.PutObject(&object)
 	if err != nil {
 		fmt.Println(err.Error())
-		fmt.Println(s3Config)
+		fmt.Println(s3Client.Config)
 		fmt.Println(object)
 		return err
 	}
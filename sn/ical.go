@@ -0,0 +1,342 @@
+package sn
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/araddon/dateparse"
+	"github.com/gorilla/mux"
+	"github.com/ringmaster/Sn/sn/util"
+	"github.com/spf13/viper"
+)
+
+// icalMaxItems is the number of items an ical route looks through for events when its ical
+// query has no paginate_count
+const icalMaxItems = 1000
+
+const (
+	icalUTCFormat   = "20060102T150405Z"
+	icalLocalFormat = "20060102T150405"
+	icalDateFormat  = "20060102"
+)
+
+// icalQueryFields are the item query fields that an ical route filters by when its path has a
+// variable of the same name: the feed taxonomies, and the slug of a single event
+var icalQueryFields = map[string][]string{
+	"tag":    {"tag", "category"},
+	"author": {"author"},
+	"slug":   {"slug"},
+}
+
+// icalDate matches event dates without a time, which are all-day events
+var icalDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// icalEvent is an item with event_start frontmatter
+type icalEvent struct {
+	Item     Item
+	URL      string
+	Start    time.Time
+	End      time.Time // Zero when the event has no end
+	AllDay   bool
+	Location *time.Location // The timezone of the event, nil for times in UTC
+}
+
+// icalQuery returns the item query of an ical route, filtered by its {tag}, {author} and
+// {slug} path variables
+func icalQuery(routeName string) map[string]interface{} {
+	return pathFilteredQuery(routeName, "ical", icalQueryFields)
+}
+
+// icalHandler renders the events among the items of an ical route as an iCalendar file.  The
+// calendar of a tag, author or event without events is not found, and the calendar of a
+// single event is a download named by its slug.
+func icalHandler(w http.ResponseWriter, r *http.Request) {
+	routeName := mux.CurrentRoute(r).GetName()
+	vars := mux.Vars(r)
+
+	events := icalEvents(routeName, vars)
+	if len(events) == 0 && len(vars) > 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	title := queryTitle(fmt.Sprintf("routes.%s.ical.title", routeName), vars)
+	writeRouteHeaders(w, "text/calendar; charset=utf-8")
+	if slug, ok := vars["slug"]; ok {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ics"`, path.Base(slug)))
+		title = events[0].Item.Title
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(icalOutput(title, events)))
+}
+
+// icalEvents returns the events among the items of an ical route's query, in order of their
+// start
+func icalEvents(routeName string, vars map[string]string) []icalEvent {
+	query := icalQuery(routeName)
+	if _, ok := query["paginate_count"]; !ok {
+		query["paginate_count"] = icalMaxItems
+	}
+	context := map[string]interface{}{
+		"pathvars": maps.Clone(vars),
+		"params":   url.Values{},
+	}
+
+	events := make([]icalEvent, 0)
+	for _, item := range ItemsFromOutvals(query, context).Items {
+		if event, ok := newICalEvent(item); ok {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+	return events
+}
+
+// newICalEvent reads the event_start, event_end, location and timezone frontmatter of an item.
+// Times without an offset are in the timezone frontmatter, or else the site's timezone, or
+// else UTC.  Dates without a time are all-day events.
+func newICalEvent(item Item) (icalEvent, bool) {
+	start := strings.TrimSpace(item.Frontmatter["event_start"])
+	if start == "" {
+		return icalEvent{}, false
+	}
+
+	event := icalEvent{Item: item, URL: util.GetItemURL(item), AllDay: icalDate.MatchString(start)}
+	location := time.UTC
+	if name := item.Frontmatter["timezone"]; name != "" {
+		location = icalLocation(name, item)
+	} else if name := viper.GetString("timezone"); name != "" {
+		location = icalLocation(name, item)
+	}
+	if location != time.UTC && !event.AllDay {
+		event.Location = location
+	}
+
+	var err error
+	if event.Start, err = dateparse.ParseIn(start, location); err != nil {
+		slog.Warn("Invalid event_start", "repo", item.Repo, "slug", item.Slug, "event_start", start, "error", err)
+		return icalEvent{}, false
+	}
+	if end := strings.TrimSpace(item.Frontmatter["event_end"]); end != "" {
+		if event.End, err = dateparse.ParseIn(end, location); err != nil || event.End.Before(event.Start) {
+			slog.Warn("Invalid event_end", "repo", item.Repo, "slug", item.Slug, "event_end", end)
+			event.End = time.Time{}
+		}
+	}
+	if event.AllDay {
+		// The end of an all-day event is the day after its last day
+		if event.End.IsZero() {
+			event.End = event.Start
+		}
+		event.End = event.End.AddDate(0, 0, 1)
+	}
+	return event, true
+}
+
+// icalLocation loads a timezone by its IANA name, or returns UTC when there is no such zone
+func icalLocation(name string, item Item) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		slog.Warn("Unknown event timezone", "repo", item.Repo, "slug", item.Slug, "timezone", name, "error", err)
+		return time.UTC
+	}
+	return location
+}
+
+// icalOutput renders events as an iCalendar (RFC 5545) calendar, with a VTIMEZONE for every
+// timezone its events use
+func icalOutput(title string, events []icalEvent) string {
+	var lines []string
+	add := func(name string, value string) {
+		lines = append(lines, name+":"+value)
+	}
+
+	add("BEGIN", "VCALENDAR")
+	add("VERSION", "2.0")
+	add("PRODID", "-//Sn//Sn Calendar//EN")
+	add("CALSCALE", "GREGORIAN")
+	add("METHOD", "PUBLISH")
+	if title != "" {
+		add("NAME", icalText(title))
+		add("X-WR-CALNAME", icalText(title))
+	}
+
+	// Each timezone is described for the years of the events that use it
+	zones := make(map[string][2]time.Time)
+	zoneNames := make([]string, 0)
+	for _, event := range events {
+		if event.Location == nil {
+			continue
+		}
+		name := event.Location.String()
+		span, ok := zones[name]
+		if !ok {
+			zoneNames = append(zoneNames, name)
+			span = [2]time.Time{event.Start, event.Start}
+		}
+		for _, t := range []time.Time{event.Start, event.End} {
+			if !t.IsZero() && t.Before(span[0]) {
+				span[0] = t
+			}
+			if t.After(span[1]) {
+				span[1] = t
+			}
+		}
+		zones[name] = span
+	}
+	sort.Strings(zoneNames)
+	for _, name := range zoneNames {
+		location, _ := time.LoadLocation(name)
+		lines = append(lines, icalTimezone(location, zones[name][0], zones[name][1])...)
+	}
+
+	for _, event := range events {
+		add("BEGIN", "VEVENT")
+		add("UID", event.URL)
+		stamp := itemLastMod(event.Item)
+		if stamp.IsZero() {
+			stamp = time.Now()
+		}
+		add("DTSTAMP", stamp.UTC().Format(icalUTCFormat))
+		lines = append(lines, icalTime("DTSTART", event.Start, event))
+		if !event.End.IsZero() {
+			lines = append(lines, icalTime("DTEND", event.End, event))
+		}
+		add("SUMMARY", icalText(event.Item.Title))
+		if summary := feedItemSummary(event.Item); summary != "" {
+			add("DESCRIPTION", icalText(summary))
+		}
+		if location := event.Item.Frontmatter["location"]; location != "" {
+			add("LOCATION", icalText(location))
+		}
+		add("URL;VALUE=URI", event.URL)
+		categories := make([]string, 0, len(event.Item.Categories))
+		for _, category := range event.Item.Categories {
+			if category != "" {
+				categories = append(categories, icalText(category))
+			}
+		}
+		if len(categories) > 0 {
+			add("CATEGORIES", strings.Join(categories, ","))
+		}
+		if !event.Item.Updated.IsZero() {
+			add("LAST-MODIFIED", event.Item.Updated.UTC().Format(icalUTCFormat))
+		}
+		add("END", "VEVENT")
+	}
+	add("END", "VCALENDAR")
+
+	var output strings.Builder
+	for _, line := range lines {
+		output.WriteString(icalFold(line))
+	}
+	return output.String()
+}
+
+// icalTime formats a time property of an event: a date for all-day events, a local time with
+// the event's TZID, or a UTC time
+func icalTime(name string, t time.Time, event icalEvent) string {
+	switch {
+	case event.AllDay:
+		return fmt.Sprintf("%s;VALUE=DATE:%s", name, t.Format(icalDateFormat))
+	case event.Location != nil:
+		return fmt.Sprintf("%s;TZID=%s:%s", name, event.Location.String(), t.In(event.Location).Format(icalLocalFormat))
+	default:
+		return fmt.Sprintf("%s:%s", name, t.UTC().Format(icalUTCFormat))
+	}
+}
+
+// icalTimezone describes a timezone from the start of the year of from to the end of the year
+// of to, with an observance for each of its offset changes in that time.  Go does not expose
+// the rules of a zone, so the observances have a DTSTART each instead of an RRULE.
+func icalTimezone(location *time.Location, from time.Time, to time.Time) []string {
+	from = time.Date(from.In(location).Year(), time.January, 1, 0, 0, 0, 0, location)
+	to = time.Date(to.In(location).Year()+1, time.January, 1, 0, 0, 0, 0, location)
+
+	lines := []string{"BEGIN:VTIMEZONE", "TZID:" + location.String()}
+	observance := func(at time.Time, offsetFrom int) {
+		kind := "STANDARD"
+		if at.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		name, offset := at.Zone()
+		lines = append(lines,
+			"BEGIN:"+kind,
+			// The start of an observance is in the local time it replaces
+			"DTSTART:"+at.UTC().Add(time.Duration(offsetFrom)*time.Second).Format(icalLocalFormat),
+			"TZOFFSETFROM:"+icalOffset(offsetFrom),
+			"TZOFFSETTO:"+icalOffset(offset),
+			"TZNAME:"+name,
+			"END:"+kind,
+		)
+	}
+
+	_, offset := from.Zone()
+	observance(from, offset)
+	for day := from; day.Before(to); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		if _, nextOffset := next.Zone(); nextOffset != offset {
+			// Narrow the change down to the second
+			before, after := day, next
+			for after.Sub(before) > time.Second {
+				middle := before.Add(after.Sub(before) / 2)
+				if _, middleOffset := middle.Zone(); middleOffset == offset {
+					before = middle
+				} else {
+					after = middle
+				}
+			}
+			observance(after.Truncate(time.Second), offset)
+			offset = nextOffset
+		}
+	}
+	return append(lines, "END:VTIMEZONE")
+}
+
+// icalOffset formats a UTC offset in seconds as +hhmm, or +hhmmss when it has seconds
+func icalOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	formatted := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		formatted += fmt.Sprintf("%02d", seconds%60)
+	}
+	return formatted
+}
+
+// icalText escapes a TEXT value
+func icalText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "").Replace(text)
+}
+
+// icalFold ends a content line with CRLF, folding it into lines of at most 75 octets without
+// splitting a character
+func icalFold(line string) string {
+	var folded strings.Builder
+	width := 0
+	for _, r := range line {
+		size := utf8.RuneLen(r)
+		if width+size > 75 {
+			folded.WriteString("\r\n ")
+			width = 1
+		}
+		folded.WriteRune(r)
+		width += size
+	}
+	folded.WriteString("\r\n")
+	return folded.String()
+}
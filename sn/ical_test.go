@@ -0,0 +1,134 @@
+package sn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+func setupICalTest(t *testing.T) *mux.Router {
+	t.Helper()
+	setupLoaderTest(t, map[string]string{
+		"/blog/meetup.md": "---\ntitle: Spring meetup\ndate: 2024-03-01\ntags: [meetup]\nevent_start: 2024-03-30 19:00\nevent_end: 2024-03-30 21:00\ntimezone: Europe/Berlin\nlocation: Café Central, Berlin\n---\n\nWe meet; bring friends.",
+		"/blog/talk.md":   "---\ntitle: Talk\ndate: 2024-05-01\ntags: [talk]\nevent_start: 2024-06-01T15:00:00Z\n---\n\nA talk.",
+		"/blog/conf.md":   "---\ntitle: Conference\ndate: 2024-08-01\nevent_start: 2024-09-10\nevent_end: 2024-09-12\n---\n\nThree days.",
+		"/blog/post.md":   "---\ntitle: Post\ndate: 2024-02-01\ntags: [meetup]\n---\n\nNot an event.",
+	})
+	DBLoadRepo("blog")
+
+	viper.Set("rooturl", "https://example.com/")
+	viper.Set("title", "Sn")
+	viper.Set("routes.all.path", "/events.ics")
+	viper.Set("routes.all.handler", "ical")
+	viper.Set("routes.all.ical", map[string]interface{}{"repo": "blog", "title": "Events"})
+	viper.Set("routes.tag.path", "/tag/{tag}/events.ics")
+	viper.Set("routes.tag.handler", "ical")
+	viper.Set("routes.tag.ical", map[string]interface{}{"repo": "blog"})
+	viper.Set("routes.event.path", "/events/{slug}.ics")
+	viper.Set("routes.event.handler", "ical")
+	viper.Set("routes.event.ical", map[string]interface{}{"repo": "blog"})
+
+	router := mux.NewRouter()
+	setupRoutes(router)
+	return router
+}
+
+func TestICalCalendar(t *testing.T) {
+	router := setupICalTest(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events.ics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", rec.Code)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "text/calendar; charset=utf-8" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+
+	body := rec.Body.String()
+	if !strings.HasSuffix(body, "END:VCALENDAR\r\n") {
+		t.Error("Expected CRLF line endings")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("Line longer than 75 octets: %q", line)
+		}
+	}
+
+	// Unfold the content lines to check the properties
+	unfolded := strings.ReplaceAll(body, "\r\n ", "")
+	for _, want := range []string{
+		"X-WR-CALNAME:Events\r\n",
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20240331T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\nTZNAME:CEST\r\nEND:DAYLIGHT\r\n",
+		"BEGIN:STANDARD\r\nDTSTART:20241027T030000\r\nTZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\nTZNAME:CET\r\nEND:STANDARD\r\n",
+		"UID:https://example.com/posts/meetup\r\n",
+		"DTSTART;TZID=Europe/Berlin:20240330T190000\r\nDTEND;TZID=Europe/Berlin:20240330T210000\r\n",
+		"SUMMARY:Spring meetup\r\n",
+		"DESCRIPTION:We meet\\; bring friends.\r\n",
+		"LOCATION:Café Central\\, Berlin\r\n",
+		"CATEGORIES:meetup\r\n",
+		"DTSTART:20240601T150000Z\r\n",
+		"DTSTART;VALUE=DATE:20240910\r\nDTEND;VALUE=DATE:20240913\r\n",
+	} {
+		if !strings.Contains(unfolded, want) {
+			t.Errorf("Expected %q in\n%s", want, unfolded)
+		}
+	}
+	if strings.Contains(body, "SUMMARY:Post") {
+		t.Error("Expected items without event_start to be left out")
+	}
+	if strings.Index(unfolded, "SUMMARY:Spring meetup") > strings.Index(unfolded, "SUMMARY:Conference") {
+		t.Error("Expected events in order of their start")
+	}
+}
+
+func TestICalRoutes(t *testing.T) {
+	router := setupICalTest(t)
+
+	tests := []struct {
+		name        string
+		path        string
+		status      int
+		events      int
+		disposition string
+	}{
+		{"tag", "/tag/meetup/events.ics", http.StatusOK, 1, ""},
+		{"tag without events", "/tag/none/events.ics", http.StatusNotFound, 0, ""},
+		{"event", "/events/talk.ics", http.StatusOK, 1, `attachment; filename="talk.ics"`},
+		{"item that is not an event", "/events/post.ics", http.StatusNotFound, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("Status = %d, want %d", rec.Code, tt.status)
+			}
+			if events := strings.Count(rec.Body.String(), "BEGIN:VEVENT"); tt.status == http.StatusOK && events != tt.events {
+				t.Errorf("Events = %d, want %d", events, tt.events)
+			}
+			if disposition := rec.Header().Get("Content-Disposition"); disposition != tt.disposition {
+				t.Errorf("Content-Disposition = %q, want %q", disposition, tt.disposition)
+			}
+		})
+	}
+}
+
+func TestICalFold(t *testing.T) {
+	line := "DESCRIPTION:" + strings.Repeat("é", 60)
+	folded := icalFold(line)
+	if unfolded := strings.ReplaceAll(strings.TrimSuffix(folded, "\r\n"), "\r\n ", ""); unfolded != line {
+		t.Errorf("Unfolded line = %q, want %q", unfolded, line)
+	}
+	for _, part := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(part) > 75 || !utf8.ValidString(part) {
+			t.Errorf("Invalid folded line %q", part)
+		}
+	}
+}
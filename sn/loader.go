@@ -0,0 +1,327 @@
+package sn
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// loaderProgressInterval is the number of rendered items between progress reports
+const loaderProgressInterval = 250
+
+// RepoLoadResult reports the outcome of loading a single repo into the database
+type RepoLoadResult struct {
+	Repo     string
+	Path     string
+	Files    int
+	Loaded   int
+	Errors   []error
+	Duration time.Duration
+}
+
+// DBLoadRepos loads every configured repo into the database, one repo at a time
+func DBLoadRepos() []RepoLoadResult {
+	repoNames := make([]string, 0, len(viper.GetStringMap("repos")))
+	for repoName := range viper.GetStringMap("repos") {
+		repoNames = append(repoNames, repoName)
+	}
+	sort.Strings(repoNames)
+
+	results := make([]RepoLoadResult, 0, len(repoNames))
+	for _, repoName := range repoNames {
+		results = append(results, DBLoadRepo(repoName))
+	}
+	return results
+}
+
+// DBLoadRepo renders the markdown files of a repo with a pool of workers, then replaces
+// the indexed items of the repo in a single transaction.  Requests never see a partial
+// set of items: with a database file they read the previous items until the transaction
+// commits, and with the in-memory database, whose tables are locked by the transaction,
+// they wait for it.  Rendering happens before the transaction so the wait stays short.
+func DBLoadRepo(repoName string) RepoLoadResult {
+	start := time.Now()
+	result := RepoLoadResult{Repo: repoName}
+
+	repoPath := ConfigPath(fmt.Sprintf("repos.%s.path", repoName), OptionallyExist())
+	result.Path = repoPath
+	if !DirExistsFs(Vfs, repoPath) {
+		result.Errors = append(result.Errors, fmt.Errorf("repo path %s does not exist", repoPath))
+		logRepoLoadResult(result)
+		return result
+	}
+
+	paths := make([]string, 0)
+	err := afero.Walk(Vfs, repoPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".md" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Errorf("error walking repo path %s: %w", repoPath, err))
+		logRepoLoadResult(result)
+		return result
+	}
+	result.Files = len(paths)
+	slog.Info("Loading repo", "repo", repoName, "path", repoPath, "files", result.Files)
+
+	items, renderErrors := renderRepoItems(repoName, repoPath, paths)
+	result.Errors = append(result.Errors, renderErrors...)
+
+	loaded, insertErrors, err := replaceRepoItems(repoName, items)
+	result.Loaded = loaded
+	result.Errors = append(result.Errors, insertErrors...)
+	if err != nil {
+		result.Errors = append(result.Errors, err)
+	}
+
+	result.Duration = time.Since(start)
+	logRepoLoadResult(result)
+	return result
+}
+
+// loaderWorkers returns the number of workers used to render markdown
+func loaderWorkers() int {
+	if workers := viper.GetInt("load_workers"); workers > 0 {
+		return workers
+	}
+	return runtime.NumCPU()
+}
+
+// renderRepoItems renders the given files in a bounded pool of workers, returning the
+// items in the same order as the paths
+func renderRepoItems(repoName string, repoPath string, paths []string) ([]Item, []error) {
+	type rendered struct {
+		index int
+		item  Item
+		err   error
+	}
+
+	jobs := make(chan int)
+	results := make(chan rendered)
+
+	var wg sync.WaitGroup
+	workers := MinOf(loaderWorkers(), MaxOf(len(paths), 1))
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				item, err := loadItemSafely(repoName, repoPath, paths[index])
+				results <- rendered{index: index, item: item, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for index := range paths {
+			jobs <- index
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	items := make([]*Item, len(paths))
+	errors := make([]error, 0)
+	done := 0
+	for r := range results {
+		done++
+		if r.err != nil {
+			errors = append(errors, r.err)
+		} else {
+			item := r.item
+			items[r.index] = &item
+		}
+		if done%loaderProgressInterval == 0 {
+			slog.Info("Rendering repo", "repo", repoName, "rendered", done, "total", len(paths))
+		}
+	}
+
+	ordered := make([]Item, 0, len(paths))
+	for _, item := range items {
+		if item != nil {
+			ordered = append(ordered, *item)
+		}
+	}
+	return ordered, errors
+}
+
+// loadItemSafely loads an item, turning a panic while rendering into an error
+func loadItemSafely(repoName string, repoPath string, filename string) (item Item, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while rendering %s: %v", filename, r)
+		}
+	}()
+	item, err = LoadItem(repoName, repoPath, filename)
+	if err != nil {
+		err = fmt.Errorf("error loading %s: %w", filename, err)
+	}
+	return item, err
+}
+
+// replaceRepoItems deletes the indexed items of a repo and inserts the given items in
+// one transaction.  Items that fail to insert are reported without aborting the load.
+// The in-memory database locks its tables until the commit, so the items are rendered
+// beforehand and the transaction only writes them.
+func replaceRepoItems(repoName string, items []Item) (int, []error, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, fmt.Errorf("error starting transaction for repo %s: %w", repoName, err)
+	}
+
+	deletes := []string{
+		"DELETE FROM items_categories WHERE item_id IN (SELECT id FROM items WHERE repo = ?)",
+		"DELETE FROM items_authors WHERE item_id IN (SELECT id FROM items WHERE repo = ?)",
+		"DELETE FROM frontmatter WHERE item_id IN (SELECT id FROM items WHERE repo = ?)",
+		"DELETE FROM items WHERE repo = ?",
+	}
+	for _, query := range deletes {
+		if _, err := tx.Exec(query, repoName); err != nil {
+			tx.Rollback()
+			return 0, nil, fmt.Errorf("error clearing repo %s: %w", repoName, err)
+		}
+	}
+
+	inserter, err := newItemInserter(tx)
+	if err != nil {
+		tx.Rollback()
+		return 0, nil, err
+	}
+
+	loaded := 0
+	errors := make([]error, 0)
+	for _, item := range items {
+		if _, err := inserter.Insert(item); err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		loaded++
+	}
+	inserter.Close()
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors, fmt.Errorf("error committing repo %s: %w", repoName, err)
+	}
+	InvalidateRepoPages(repoName)
+	return loaded, errors, nil
+}
+
+func logRepoLoadResult(result RepoLoadResult) {
+	for _, err := range result.Errors {
+		slog.Error("Error loading repo item", "repo", result.Repo, "error", err)
+	}
+	slog.Info("Loaded repo", "repo", result.Repo, "files", result.Files, "loaded", result.Loaded,
+		"errors", len(result.Errors), "duration", fmt.Sprintf("%dms", result.Duration.Milliseconds()))
+}
+
+// repoWatcher is the watcher of a repo's path, with no stop function when the path does not
+// exist
+type repoWatcher struct {
+	path string
+	stop func()
+}
+
+// repoWatchers holds the watcher of every repo once StartWatchingRepos or LoadRepos has run
+var (
+	repoWatchers     map[string]repoWatcher
+	repoWatchersLock sync.Mutex
+)
+
+// LoadRepos loads every configured repo into the database and watches the repos it loaded.
+// A SyncRepos made while the repos load waits for them, then applies any configuration
+// change that happened in the meantime.
+func LoadRepos() {
+	repoWatchersLock.Lock()
+	defer repoWatchersLock.Unlock()
+	configLock.RLock()
+	defer configLock.RUnlock()
+	results := DBLoadRepos()
+	repoWatchers = make(map[string]repoWatcher)
+	for _, result := range results {
+		watchRepo(result.Repo, result.Path)
+	}
+}
+
+// StartWatchingRepos watches the path of every configured repo for changes
+func StartWatchingRepos() {
+	repoWatchersLock.Lock()
+	defer repoWatchersLock.Unlock()
+	repoWatchers = make(map[string]repoWatcher)
+	for repoName := range viper.GetStringMap("repos") {
+		watchRepo(repoName, ConfigPath(fmt.Sprintf("repos.%s.path", repoName), OptionallyExist()))
+	}
+}
+
+// watchRepo starts watching the path of a repo, if it exists.  The caller holds
+// repoWatchersLock.
+func watchRepo(repoName string, repoPath string) {
+	watcher := repoWatcher{path: repoPath}
+	if DirExistsFs(Vfs, watcher.path) {
+		watcher.stop = StartWatching(watcher.path, repoName)
+	}
+	repoWatchers[repoName] = watcher
+}
+
+// SyncRepos brings the watched repos in line with the configuration: repos that were added,
+// or whose path changed, are loaded and watched, and repos that were removed are no longer
+// watched and their items are removed.  Until StartWatchingRepos or LoadRepos has run, the
+// repos are left to the initial load.
+func SyncRepos() {
+	repoWatchersLock.Lock()
+	defer repoWatchersLock.Unlock()
+	syncRepos()
+}
+
+// syncRepos is SyncRepos for a caller that holds repoWatchersLock
+func syncRepos() {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if repoWatchers == nil {
+		return
+	}
+
+	configured := viper.GetStringMap("repos")
+	for repoName, watcher := range repoWatchers {
+		_, ok := configured[repoName]
+		if ok && ConfigPath(fmt.Sprintf("repos.%s.path", repoName), OptionallyExist()) == watcher.path {
+			continue
+		}
+		if watcher.stop != nil {
+			watcher.stop()
+		}
+		delete(repoWatchers, repoName)
+		if !ok {
+			if _, _, err := replaceRepoItems(repoName, nil); err != nil {
+				slog.Error("Error removing repo", "repo", repoName, "error", err)
+				continue
+			}
+			slog.Info("Removed repo", "repo", repoName)
+		}
+	}
+
+	repoNames := make([]string, 0, len(configured))
+	for repoName := range configured {
+		if _, ok := repoWatchers[repoName]; !ok {
+			repoNames = append(repoNames, repoName)
+		}
+	}
+	sort.Strings(repoNames)
+	for _, repoName := range repoNames {
+		result := DBLoadRepo(repoName)
+		watchRepo(repoName, result.Path)
+	}
+}
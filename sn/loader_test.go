@@ -0,0 +1,169 @@
+package sn
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// setupLoaderTest creates an in-memory repo and database for loader tests
+func setupLoaderTest(t testing.TB, files map[string]string) {
+	t.Helper()
+	memFs := afero.NewMemMapFs()
+	origVfs := Vfs
+	Vfs = memFs
+	t.Cleanup(func() { Vfs = origVfs })
+
+	viper.Reset()
+	viper.Set("repos.blog.path", "/blog")
+	viper.Set("load_workers", 3)
+	memFs.MkdirAll("/blog", 0755)
+	for name, content := range files {
+		afero.WriteFile(memFs, name, []byte(content), 0644)
+	}
+
+	DBConnect()
+	t.Cleanup(DBClose)
+}
+
+func TestDBLoadRepo(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md":       "---\ntitle: One\ntags: [go, sqlite]\nauthors: [alice]\n---\n\nFirst post.",
+		"/blog/two.md":       "---\ntitle: Two\ntags: [go]\nauthors: [alice, bob]\n---\n\nSecond post.",
+		"/blog/sub/three.md": "---\ntitle: Three\n---\n\nThird post.",
+		"/blog/short.md":     "x",
+		"/blog/notes.txt":    "not markdown",
+	})
+
+	result := DBLoadRepo("blog")
+
+	if result.Files != 4 {
+		t.Errorf("Files = %d, want 4", result.Files)
+	}
+	if result.Loaded != 3 {
+		t.Errorf("Loaded = %d, want 3", result.Loaded)
+	}
+	if len(result.Errors) != 1 {
+		t.Errorf("Expected 1 error for the short file, got %v", result.Errors)
+	}
+
+	var categories, authors int
+	db.QueryRow("SELECT count(*) FROM categories WHERE category IN ('go', 'sqlite')").Scan(&categories)
+	if categories != 2 {
+		t.Errorf("Expected categories to be inserted once each, got %d", categories)
+	}
+	db.QueryRow("SELECT count(*) FROM authors").Scan(&authors)
+	if authors != 2 {
+		t.Errorf("Expected 2 authors, got %d", authors)
+	}
+
+	repo := "blog"
+	tag := "go"
+	tagged := ItemsFromItemQuery(ItemQuery{Page: 1, PerPage: 10, Repo: &repo, Category: &tag})
+	if tagged.Total != 2 {
+		t.Errorf("Expected 2 items tagged go, got %d", tagged.Total)
+	}
+}
+
+// TestDBLoadRepo_InsertErrors verifies that an item whose categories, authors or frontmatter
+// fail to insert is reported and leaves none of its rows behind
+func TestDBLoadRepo_InsertErrors(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/a.md": "---\ntitle: A\ntags: [go]\nbroken: yes\n---\n\nBroken post.",
+		"/blog/b.md": "---\ntitle: B\ntags: [go]\n---\n\nSecond post.",
+	})
+	_, err := db.Exec(`CREATE TRIGGER fail_frontmatter BEFORE INSERT ON frontmatter
+		WHEN NEW.fieldname = 'broken' BEGIN SELECT RAISE(ABORT, 'broken frontmatter'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DROP TRIGGER IF EXISTS fail_frontmatter") })
+
+	result := DBLoadRepo("blog")
+
+	if result.Loaded != 1 || len(result.Errors) != 1 {
+		t.Fatalf("Loaded = %d with errors %v, want 1 item and 1 error", result.Loaded, result.Errors)
+	}
+	var items int
+	db.QueryRow("SELECT count(*) FROM items WHERE slug = 'a'").Scan(&items)
+	if items != 0 {
+		t.Error("Expected the failed item to be rolled back")
+	}
+	repo := "blog"
+	tag := "go"
+	if tagged := ItemsFromItemQuery(ItemQuery{Page: 1, PerPage: 10, Repo: &repo, Category: &tag}); tagged.Total != 1 {
+		t.Errorf("Expected the next item to keep its category, got %d tagged items", tagged.Total)
+	}
+}
+
+// TestDBLoadRepo_Reload verifies that loading a repo again replaces its items
+func TestDBLoadRepo_FileReadersSeePreviousItems(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md": "---\ntitle: One\n---\n\nFirst post.",
+	})
+	DBClose()
+	viper.Set("dbfile", filepath.Join(t.TempDir(), "sn.db"))
+	DBConnect()
+	DBLoadRepo("blog")
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM items WHERE repo = 'blog'"); err != nil {
+		t.Fatal(err)
+	}
+
+	counted := make(chan int, 1)
+	go func() {
+		var count int
+		db.QueryRow("SELECT count(*) FROM items WHERE repo = 'blog'").Scan(&count)
+		counted <- count
+	}()
+	select {
+	case count := <-counted:
+		if count != 1 {
+			t.Errorf("Items read during a load = %d, want the previous 1", count)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a read during a load not to wait for the transaction")
+	}
+}
+
+func TestDBLoadRepo_Reload(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md": "---\ntitle: One\ntags: [go]\n---\n\nFirst post.",
+	})
+
+	DBLoadRepo("blog")
+	afero.WriteFile(Vfs, "/blog/two.md", []byte("---\ntitle: Two\n---\n\nSecond post."), 0644)
+	result := DBLoadRepo("blog")
+
+	if len(result.Errors) != 0 {
+		t.Errorf("Expected no errors on reload, got %v", result.Errors)
+	}
+
+	var items, itemCategories int
+	db.QueryRow("SELECT count(*) FROM items WHERE repo = 'blog'").Scan(&items)
+	if items != 2 {
+		t.Errorf("Expected 2 items after reload, got %d", items)
+	}
+	db.QueryRow("SELECT count(*) FROM items_categories").Scan(&itemCategories)
+	if itemCategories != 1 {
+		t.Errorf("Expected stale item categories to be removed, got %d", itemCategories)
+	}
+}
+
+func TestDBLoadRepo_MissingPath(t *testing.T) {
+	setupLoaderTest(t, nil)
+	viper.Set("repos.missing.path", "/nope")
+
+	result := DBLoadRepo("missing")
+	if len(result.Errors) != 1 || result.Loaded != 0 {
+		t.Errorf("Expected a single error for a missing repo path, got %+v", result)
+	}
+}
@@ -0,0 +1,257 @@
+package sn
+
+import (
+	"embed"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a single versioned change to the database schema.
+// Migrations are embedded from migrations/NNNN_name.sql and applied in version order.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus reports whether a migration has been applied to the database
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedOn time.Time
+}
+
+// Migrations returns the embedded migrations in version order
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		filename := entry.Name()
+		versionText, name, found := strings.Cut(strings.TrimSuffix(filename, ".sql"), "_")
+		version, err := strconv.Atoi(versionText)
+		if !found || err != nil {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name.sql", filename)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, filename)
+		}
+		seen[version] = filename
+
+		sql, err := migrationFiles.ReadFile(path.Join("migrations", filename))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", filename, err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(sql)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// ensureSchemaVersionTable creates the schema_version table.  A database that was
+// created before migrations existed is recorded at the version its tables match.
+func ensureSchemaVersionTable() error {
+	var exists int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check for schema_version table: %w", err)
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	baseline, err := legacySchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE "schema_version" (
+		"version" integer PRIMARY KEY NOT NULL,
+		"name" varchar(255) NOT NULL,
+		"appliedon" timestamp NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	if baseline == 0 {
+		return nil
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if migration.Version > baseline {
+			break
+		}
+		_, err := db.Exec(`INSERT INTO schema_version (version, name, appliedon) VALUES (?, ?, ?)`, migration.Version, migration.Name, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to record baseline migration %d: %w", migration.Version, err)
+		}
+	}
+	slog.Info("Recorded schema version of existing database", "version", baseline)
+	return nil
+}
+
+// legacyTables are the tables and columns of the schema that databases were created with
+// before migrations were introduced, which the initial migration reproduces
+var legacyTables = map[string][]string{
+	"items":            {"id", "slug", "repo", "publishedon", "rawpublishedon", "raw", "html", "source", "title", "frontmatter"},
+	"authors":          {"id", "author"},
+	"categories":       {"id", "category"},
+	"frontmatter":      {"id", "item_id", "fieldname", "value"},
+	"items_authors":    {"id", "item_id", "author_id"},
+	"items_categories": {"id", "item_id", "category_id"},
+	"comments": {"id", "comment_id", "activity_id", "in_reply_to", "author", "author_name", "author_url", "content", "content_html",
+		"published", "updated", "verified", "approved", "hidden", "post_slug", "post_repo", "item_id"},
+}
+
+// legacySchemaVersion inspects a database without a schema_version table to find which
+// migrations its tables already contain: none for an empty database, or the initial
+// migration for one created before migrations were introduced.  Any other schema is an
+// error, since its tables cannot be matched to a version.
+func legacySchemaVersion() (int, error) {
+	rows, err := db.Query(`SELECT m.name, p.name FROM sqlite_master AS m, pragma_table_info(m.name) AS p
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' ORDER BY m.name, p.cid`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect the database tables: %w", err)
+	}
+	defer rows.Close()
+
+	tables := make(map[string][]string)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return 0, fmt.Errorf("failed to inspect the database tables: %w", err)
+		}
+		tables[table] = append(tables[table], column)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to inspect the database tables: %w", err)
+	}
+
+	if len(tables) == 0 {
+		return 0, nil
+	}
+	if maps.EqualFunc(tables, legacyTables, slices.Equal) {
+		return 1, nil
+	}
+	return 0, fmt.Errorf("the database has no schema_version table and its tables do not match the schema from before migrations; remove the database file, or set cleandb, to rebuild the index")
+}
+
+// DBMigrationStatus lists every migration and whether it has been applied, without
+// changing the database
+func DBMigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		appliedOn, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{Migration: migration, Applied: ok, AppliedOn: appliedOn})
+	}
+	return statuses, nil
+}
+
+// appliedMigrations returns when each applied migration was applied.  Without a
+// schema_version table, the migrations an existing database already contains are
+// reported with a zero time.
+func appliedMigrations() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+
+	var exists int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check for schema_version table: %w", err)
+	}
+	if exists == 0 {
+		baseline, err := legacySchemaVersion()
+		if err != nil {
+			return nil, err
+		}
+		for version := 1; version <= baseline; version++ {
+			applied[version] = time.Time{}
+		}
+		return applied, nil
+	}
+
+	rows, err := db.Query(`SELECT version, appliedon FROM schema_version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_version: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedOn string
+		if err := rows.Scan(&version, &appliedOn); err != nil {
+			return nil, fmt.Errorf("failed to read schema_version: %w", err)
+		}
+		applied[version], _ = dateparse.ParseLocal(appliedOn)
+	}
+	return applied, rows.Err()
+}
+
+// DBMigrate applies the pending migrations in order, each in its own transaction,
+// and returns the number of migrations applied
+func DBMigrate() (int, error) {
+	if err := ensureSchemaVersionTable(); err != nil {
+		return 0, err
+	}
+
+	statuses, err := DBMigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, status := range statuses {
+		if status.Applied {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return count, fmt.Errorf("failed to start migration %d: %w", status.Version, err)
+		}
+		if _, err := tx.Exec(status.SQL); err != nil {
+			tx.Rollback()
+			return count, fmt.Errorf("migration %d (%s) failed: %w", status.Version, status.Name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_version (version, name, appliedon) VALUES (?, ?, ?)`, status.Version, status.Name, time.Now()); err != nil {
+			tx.Rollback()
+			return count, fmt.Errorf("failed to record migration %d: %w", status.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return count, fmt.Errorf("failed to commit migration %d: %w", status.Version, err)
+		}
+
+		slog.Info("Applied migration", "version", status.Version, "name", status.Name)
+		count++
+	}
+	return count, nil
+}
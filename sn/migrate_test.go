@@ -0,0 +1,162 @@
+package sn
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// openTestDB opens an empty in-memory database without migrating it
+func openTestDB(t *testing.T) {
+	t.Helper()
+	origVfs := Vfs
+	Vfs = afero.NewMemMapFs()
+	viper.Reset()
+	DBOpen()
+	t.Cleanup(func() {
+		DBClose()
+		Vfs = origVfs
+	})
+}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("Migration %q has version %d, want %d", migration.Name, migration.Version, i+1)
+		}
+		if migration.SQL == "" {
+			t.Errorf("Migration %d is empty", migration.Version)
+		}
+	}
+}
+
+func TestDBMigrate(t *testing.T) {
+	openTestDB(t)
+
+	migrations, _ := Migrations()
+	count, err := DBMigrate()
+	if err != nil {
+		t.Fatalf("DBMigrate() error = %v", err)
+	}
+	if count != len(migrations) {
+		t.Errorf("DBMigrate() applied %d migrations, want %d", count, len(migrations))
+	}
+
+	// A second run has nothing left to do
+	count, err = DBMigrate()
+	if err != nil || count != 0 {
+		t.Errorf("Second DBMigrate() = %d, %v; want 0, nil", count, err)
+	}
+
+	statuses, err := DBMigrationStatus()
+	if err != nil {
+		t.Fatalf("DBMigrationStatus() error = %v", err)
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedOn.IsZero() {
+			t.Errorf("Expected migration %d to be applied, got %+v", status.Version, status)
+		}
+	}
+
+	if _, err := db.Exec("INSERT INTO items (slug, repo, title, lasteditor) VALUES ('a', 'b', 'c', 'd')"); err != nil {
+		t.Errorf("Expected migrated items table to accept history columns: %v", err)
+	}
+}
+
+// TestDBMigrate_LegacyDatabase verifies a database created before migrations is upgraded
+func TestDBMigrate_LegacyDatabase(t *testing.T) {
+	openTestDB(t)
+
+	baseline, err := os.ReadFile("testdata/baseline_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(baseline)); err != nil {
+		t.Fatalf("Failed to create the schema from before migrations: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO items (slug, repo, publishedon, rawpublishedon, raw, html, source, title, frontmatter) VALUES ('one', 'blog', '2024-01-01', '2024-01-01', 'One', '<p>One</p>', '/blog/one.md', 'One', '{}')`); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := DBMigrationStatus()
+	if err != nil {
+		t.Fatalf("DBMigrationStatus() error = %v", err)
+	}
+	if !statuses[0].Applied || statuses[1].Applied {
+		t.Errorf("Expected only the initial migration to be considered applied, got %+v", statuses)
+	}
+
+	count, err := DBMigrate()
+	if err != nil {
+		t.Fatalf("DBMigrate() error = %v", err)
+	}
+	if count != len(statuses)-1 {
+		t.Errorf("DBMigrate() applied %d migrations, want %d", count, len(statuses)-1)
+	}
+	var title string
+	if err := db.QueryRow("SELECT title FROM items WHERE slug = 'one' AND createdon IS NULL").Scan(&title); err != nil || title != "One" {
+		t.Errorf("Expected the item to be kept with the history columns added, got %q, %v", title, err)
+	}
+}
+
+// TestDBMigrate_UnknownDatabase verifies a database whose tables match no version is not
+// migrated
+func TestDBMigrate_UnknownDatabase(t *testing.T) {
+	openTestDB(t)
+
+	if _, err := db.Exec(`CREATE TABLE "items" ("id" integer PRIMARY KEY AUTOINCREMENT NOT NULL, "slug" varchar(255) NOT NULL, "repo" varchar(255) NOT NULL, "title" varchar(255) NOT NULL, "createdon" timestamp(128))`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DBMigrate(); err == nil {
+		t.Error("Expected an error for tables that match no schema version")
+	}
+}
+
+func TestDBURI(t *testing.T) {
+	tests := []struct {
+		dbfile   string
+		expected string
+	}{
+		{":memory:", "file:sn?mode=memory&cache=shared"},
+		{"file:sn?mode=memory&cache=shared", "file:sn?mode=memory&cache=shared"},
+		{"site/file:sn?mode=memory&cache=shared", "file:site/file:sn?mode=memory&cache=shared"},
+		{"site/sn.db", "file:site/sn.db?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"},
+		{"file:site/sn.db?cache=private", "file:site/sn.db?cache=private&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"},
+	}
+	for _, tt := range tests {
+		if uri := dbURI(tt.dbfile); uri != tt.expected {
+			t.Errorf("dbURI(%q) = %q, want %q", tt.dbfile, uri, tt.expected)
+		}
+	}
+}
+
+// TestDBOpen_ShippedConfig opens the database the shipped sn.yaml configures
+func TestDBOpen_ShippedConfig(t *testing.T) {
+	shipped := viper.New()
+	shipped.SetConfigFile("../sn.yaml")
+	if err := shipped.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	origVfs := Vfs
+	Vfs = afero.NewMemMapFs()
+	t.Cleanup(func() { Vfs = origVfs })
+	viper.Reset()
+	viper.Set("dbfile", shipped.GetString("dbfile"))
+
+	DBConnect()
+	t.Cleanup(DBClose)
+
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		t.Fatal(err)
+	}
+	if journalMode != "memory" {
+		t.Errorf("journal_mode = %q, want memory", journalMode)
+	}
+}
@@ -0,0 +1,99 @@
+-- The schema of the index before migrations were introduced
+
+CREATE TABLE IF NOT EXISTS "items" (
+  "id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "slug" varchar(255) NOT NULL,
+  "repo" varchar(255) NOT NULL,
+  "publishedon" timestamp(128),
+  "rawpublishedon" varchar(128),
+  "raw" text(128),
+  "html" text(128),
+  "source" varchar(128),
+  "title" varchar(255) NOT NULL,
+  "frontmatter" text(128)
+);
+
+CREATE INDEX IF NOT EXISTS items_repo ON "items" ("repo" ASC);
+
+CREATE UNIQUE INDEX IF NOT EXISTS items_repo_slug ON "items" ("slug" ASC, "repo" ASC);
+
+CREATE INDEX IF NOT EXISTS items_published_on ON "items" ("publishedon" ASC);
+
+CREATE TABLE IF NOT EXISTS "authors" (
+  "id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "author" varchar(128)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS authors_author ON "authors" ("author" ASC);
+
+CREATE TABLE IF NOT EXISTS "categories" (
+  "id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "category" varchar(128) NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS categories_category ON "categories" ("category" ASC);
+
+CREATE TABLE IF NOT EXISTS "frontmatter" (
+  "id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "item_id" integer(128) NOT NULL,
+  "fieldname" varchar(255) NOT NULL,
+  "value" text(128),
+  FOREIGN KEY (item_id) REFERENCES "items" (id)
+);
+
+CREATE INDEX IF NOT EXISTS frontmatter_fieldname ON "frontmatter" ("fieldname" ASC);
+
+CREATE INDEX IF NOT EXISTS frontmatter_item_id ON "frontmatter" ("item_id" ASC);
+
+CREATE INDEX IF NOT EXISTS frontmatter_fieldname_value ON "frontmatter" ("fieldname" ASC, "value" ASC);
+
+CREATE TABLE IF NOT EXISTS "items_authors" (
+  "id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "item_id" integer(128) NOT NULL,
+  "author_id" integer(128),
+  FOREIGN KEY (item_id) REFERENCES "items" (id),
+  FOREIGN KEY (author_id) REFERENCES "authors" (id)
+);
+
+CREATE INDEX IF NOT EXISTS items_authors_author_id ON "items_authors" ("author_id" ASC);
+
+CREATE UNIQUE INDEX IF NOT EXISTS items_authors_item_id_author_id ON "items_authors" ("item_id" ASC, "author_id" ASC);
+
+CREATE INDEX IF NOT EXISTS iterms_authors_item_id ON "items_authors" ("item_id" ASC);
+
+CREATE TABLE IF NOT EXISTS "items_categories" (
+  "id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "item_id" integer(128),
+  "category_id" integer(128),
+  FOREIGN KEY (item_id) REFERENCES "items" (id),
+  FOREIGN KEY (category_id) REFERENCES "categories" (id)
+);
+
+CREATE INDEX IF NOT EXISTS items_categories_item_id ON "items_categories" ("item_id" ASC);
+
+CREATE INDEX IF NOT EXISTS items_categories_category_id ON "items_categories" ("category_id" ASC);
+
+CREATE TABLE IF NOT EXISTS "comments" (
+  "id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "comment_id" varchar(255) NOT NULL,
+  "activity_id" varchar(255),
+  "in_reply_to" varchar(255) NOT NULL,
+  "author" varchar(255) NOT NULL,
+  "author_name" varchar(255),
+  "author_url" varchar(255),
+  "content" text NOT NULL,
+  "content_html" text,
+  "published" timestamp,
+  "updated" timestamp,
+  "verified" boolean DEFAULT 0,
+  "approved" boolean DEFAULT 1,
+  "hidden" boolean DEFAULT 0,
+  "post_slug" varchar(255) NOT NULL,
+  "post_repo" varchar(255) NOT NULL,
+  "item_id" integer,
+  FOREIGN KEY (item_id) REFERENCES "items" (id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS comments_comment_id ON "comments" ("comment_id");
+CREATE INDEX IF NOT EXISTS comments_post ON "comments" ("post_repo", "post_slug");
+CREATE INDEX IF NOT EXISTS comments_published ON "comments" ("published" DESC);
@@ -0,0 +1,7 @@
+-- Item timestamps and last editor, derived from git history in git mode
+
+ALTER TABLE "items" ADD COLUMN "createdon" timestamp(128);
+
+ALTER TABLE "items" ADD COLUMN "updatedon" timestamp(128);
+
+ALTER TABLE "items" ADD COLUMN "lasteditor" varchar(255);
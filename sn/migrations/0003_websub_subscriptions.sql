@@ -0,0 +1,14 @@
+-- Subscriptions to the feeds of the built-in WebSub hub
+
+CREATE TABLE IF NOT EXISTS "websub_subscriptions" (
+  "id" integer PRIMARY KEY AUTOINCREMENT NOT NULL,
+  "topic" varchar(255) NOT NULL,
+  "callback" varchar(255) NOT NULL,
+  "secret" varchar(200),
+  "expires" integer NOT NULL,
+  "created" integer NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS websub_subscriptions_topic_callback ON "websub_subscriptions" ("topic" ASC, "callback" ASC);
+
+CREATE INDEX IF NOT EXISTS websub_subscriptions_expires ON "websub_subscriptions" ("expires" ASC);
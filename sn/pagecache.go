@@ -0,0 +1,194 @@
+package sn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// defaultPageCacheEntries is the number of rendered pages kept when page_cache.max_entries is not set
+const defaultPageCacheEntries = 1000
+
+// Page dependencies name the data a cached page was rendered from.  A page that queried
+// items without limiting the repo depends on every repo.
+const pageDepAllRepos = "repo:*"
+
+func pageDepRepo(repo string) string {
+	return "repo:" + repo
+}
+
+// queryPageDep returns the dependency of a page on the items of a query helper, by the
+// query's repo parameter
+func queryPageDep(repo interface{}) string {
+	if repo == nil || fmt.Sprint(repo) == "" {
+		return pageDepAllRepos
+	}
+	return pageDepRepo(fmt.Sprint(repo))
+}
+
+func pageDepComments(repo string, slug string) string {
+	return fmt.Sprintf("comments:%s/%s", repo, slug)
+}
+
+// cachedPage is a rendered page and the data it depends on
+type cachedPage struct {
+	body     []byte
+	mime     string
+	etag     string
+	modified time.Time
+	deps     map[string]bool
+}
+
+var (
+	pageCache     = make(map[string]*cachedPage)
+	pageCacheLock sync.RWMutex
+	// pageGeneration counts invalidations, so a page rendered while its data changed is not stored
+	pageGeneration uint64
+)
+
+// paramReference finds the query parameters a route substitutes into its output queries
+var paramReference = regexp.MustCompile(`\{params\.([^}]+)\}`)
+
+// pageCacheEnabled reports whether rendered pages of a route may be cached
+func pageCacheEnabled(routeName string) bool {
+	if !viper.GetBool("page_cache.enabled") {
+		return false
+	}
+	routeCacheLocation := fmt.Sprintf("routes.%s.cache", routeName)
+	return !viper.IsSet(routeCacheLocation) || viper.GetBool(routeCacheLocation)
+}
+
+// pageCacheKey builds the cache key of a request to a route from the route name, the path
+// variables, and the query parameters that can change what the route renders: those
+// referenced as {params.name} by its queries, their paginate_name, and its cache_vary list.
+func pageCacheKey(routeName string, pathvars map[string]string, query url.Values) string {
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+
+	relevant := make(map[string]bool)
+	for _, name := range viper.GetStringSlice(fmt.Sprintf("%s.cache_vary", routeConfigLocation)) {
+		relevant[name] = true
+	}
+	for outVarName := range viper.GetStringMap(fmt.Sprintf("%s.out", routeConfigLocation)) {
+		qlocation := fmt.Sprintf("%s.out.%s", routeConfigLocation, outVarName)
+		for _, match := range paramReference.FindAllStringSubmatch(fmt.Sprint(viper.Get(qlocation)), -1) {
+			relevant[match[1]] = true
+		}
+		if _, ok := viper.Get(qlocation).(map[string]interface{}); ok {
+			relevant[ConfigStringDefault(qlocation+".paginate_name", "page")] = true
+		}
+	}
+
+	parts := []string{routeName}
+	for name, value := range pathvars {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, value))
+	}
+	for name := range relevant {
+		if values, ok := query[name]; ok {
+			parts = append(parts, fmt.Sprintf("params.%s=%s", name, strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(parts[1:])
+	return strings.Join(parts, "\n")
+}
+
+// getCachedPage returns the cached page for a key, or nil
+func getCachedPage(key string) *cachedPage {
+	pageCacheLock.RLock()
+	defer pageCacheLock.RUnlock()
+	return pageCache[key]
+}
+
+// currentPageGeneration returns the invalidation count to pass to storeCachedPage
+func currentPageGeneration() uint64 {
+	pageCacheLock.RLock()
+	defer pageCacheLock.RUnlock()
+	return pageGeneration
+}
+
+// storeCachedPage caches a rendered page, evicting the oldest page when the cache is full.
+// The page is not stored if the cache was invalidated since generation, since it may have
+// been rendered from data that has since changed.
+func storeCachedPage(key string, body []byte, mime string, deps map[string]bool, generation uint64) *cachedPage {
+	sum := sha256.Sum256(body)
+	page := &cachedPage{
+		body:     body,
+		mime:     mime,
+		etag:     fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:16])),
+		modified: time.Now().UTC().Truncate(time.Second),
+		deps:     deps,
+	}
+
+	maxEntries := viper.GetInt("page_cache.max_entries")
+	if maxEntries <= 0 {
+		maxEntries = defaultPageCacheEntries
+	}
+
+	pageCacheLock.Lock()
+	defer pageCacheLock.Unlock()
+	if generation != pageGeneration {
+		return page
+	}
+	if _, exists := pageCache[key]; !exists && len(pageCache) >= maxEntries {
+		oldestKey := ""
+		for k, p := range pageCache {
+			if oldestKey == "" || p.modified.Before(pageCache[oldestKey].modified) {
+				oldestKey = k
+			}
+		}
+		delete(pageCache, oldestKey)
+	}
+	pageCache[key] = page
+	return page
+}
+
+// writeCachedPage writes a cached page with its validators, answering conditional
+// requests with 304 Not Modified
+func writeCachedPage(w http.ResponseWriter, r *http.Request, page *cachedPage) {
+	writeRouteHeaders(w, page.mime)
+	w.Header().Set("ETag", page.etag)
+	http.ServeContent(w, r, "", page.modified, bytes.NewReader(page.body))
+}
+
+// invalidatePages removes the cached pages that depend on any of the given dependencies
+func invalidatePages(deps ...string) {
+	pageCacheLock.Lock()
+	defer pageCacheLock.Unlock()
+	pageGeneration++
+	for key, page := range pageCache {
+		for _, dep := range deps {
+			if page.deps[dep] {
+				delete(pageCache, key)
+				break
+			}
+		}
+	}
+}
+
+// InvalidateRepoPages removes the cached pages that display items of a repo
+func InvalidateRepoPages(repo string) {
+	invalidatePages(pageDepRepo(repo), pageDepAllRepos)
+}
+
+// InvalidateCommentPages removes the cached pages that display the comments of an item
+func InvalidateCommentPages(repo string, slug string) {
+	invalidatePages(pageDepComments(repo, slug))
+}
+
+// ClearPageCache removes every cached page, for changes that can affect any page such
+// as templates or configuration
+func ClearPageCache() {
+	pageCacheLock.Lock()
+	defer pageCacheLock.Unlock()
+	pageGeneration++
+	pageCache = make(map[string]*cachedPage)
+}
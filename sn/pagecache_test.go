@@ -0,0 +1,227 @@
+package sn
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+func TestPageCacheKey(t *testing.T) {
+	viper.Reset()
+	viper.Set("routes.search.out.results.search", "{params.q}")
+	viper.Set("routes.search.out.results.paginate_name", "p")
+	viper.Set("routes.search.cache_vary", []string{"theme"})
+
+	tests := []struct {
+		name     string
+		pathvars map[string]string
+		query    string
+		expected string
+	}{
+		{
+			name:     "path vars are sorted",
+			pathvars: map[string]string{"b": "2", "a": "1"},
+			expected: "search\na=1\nb=2",
+		},
+		{
+			name:     "referenced params are included",
+			pathvars: map[string]string{},
+			query:    "q=go&p=2",
+			expected: "search\nparams.p=2\nparams.q=go",
+		},
+		{
+			name:     "cache_vary params are included",
+			pathvars: map[string]string{},
+			query:    "theme=dark",
+			expected: "search\nparams.theme=dark",
+		},
+		{
+			name:     "unrelated params are ignored",
+			pathvars: map[string]string{},
+			query:    "utm_source=feed",
+			expected: "search",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			if key := pageCacheKey("search", tt.pathvars, query); key != tt.expected {
+				t.Errorf("pageCacheKey() = %q, want %q", key, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTemplateHandler_PageCache verifies pages are served from the cache with validators
+// until the items they display change
+func TestTemplateHandler_PageCache(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md": "---\ntitle: One\n---\n\nFirst post.",
+	})
+	ClearPageCache()
+	t.Cleanup(ClearPageCache)
+
+	viper.Set("page_cache.enabled", true)
+	viper.Set("template_dir", "/tpl")
+	viper.Set("routes.index.path", "/")
+	viper.Set("routes.index.templates", []string{"index.html.hb"})
+	viper.Set("routes.index.out.posts.repo", "blog")
+	viper.Set("routes.about.path", "/about")
+	viper.Set("routes.about.templates", []string{"about.html.hb"})
+	afero.WriteFile(Vfs, "/tpl/index.html.hb", []byte("{{#each posts.Items}}{{title}};{{/each}}"), 0644)
+	afero.WriteFile(Vfs, "/tpl/about.html.hb", []byte("About"), 0644)
+	RegisterPartials()
+	DBLoadRepo("blog")
+
+	router := mux.NewRouter()
+	router.HandleFunc("/", catchallHandler).Name("index")
+	router.HandleFunc("/about", catchallHandler).Name("about")
+
+	get := func(path string, header http.Header) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	first := get("/", nil)
+	body, _ := io.ReadAll(first.Body)
+	etag := first.Header.Get("ETag")
+	if string(body) != "One;" {
+		t.Fatalf("Expected rendered page, got %q", body)
+	}
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Errorf("Expected a strong ETag, got %q", etag)
+	}
+	if first.Header.Get("Last-Modified") == "" {
+		t.Error("Expected a Last-Modified header")
+	}
+
+	notModified := get("/", http.Header{"If-None-Match": {etag}})
+	if notModified.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", notModified.StatusCode)
+	}
+
+	about := get("/about", nil)
+	aboutKey := pageCacheKey("about", map[string]string{}, url.Values{})
+	if about.StatusCode != http.StatusOK || getCachedPage(aboutKey) == nil {
+		t.Fatalf("Expected the about page to be cached")
+	}
+
+	afero.WriteFile(Vfs, "/blog/two.md", []byte("---\ntitle: Two\n---\n\nSecond post."), 0644)
+	reloadItem("blog", "/blog", "/blog/two.md")
+
+	if getCachedPage(aboutKey) == nil {
+		t.Error("Expected a page without items to stay cached when an item changes")
+	}
+	changed := get("/", http.Header{"If-None-Match": {etag}})
+	body, _ = io.ReadAll(changed.Body)
+	if changed.StatusCode != http.StatusOK || !strings.Contains(string(body), "Two;") {
+		t.Errorf("Expected the page to be rendered again after an item changed, got %d %q", changed.StatusCode, body)
+	}
+	if changed.Header.Get("ETag") == etag {
+		t.Error("Expected a new ETag after an item changed")
+	}
+
+	afero.WriteFile(Vfs, "/tpl/about.html.hb", []byte("About us"), 0644)
+	RegisterPartials()
+	if getCachedPage(aboutKey) != nil {
+		t.Error("Expected a template reload to clear the page cache")
+	}
+}
+
+// TestTemplateHandler_PageCacheQueryHelper verifies pages that read items through the query
+// helper of a template are rendered again when those items change
+func TestTemplateHandler_PageCacheQueryHelper(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		source   string
+		rerender bool
+	}{
+		{"raymond query of the repo", "sidebar.html.hb", `{{#query repo="blog"}}{{#each Items}}{{title}};{{/each}}{{/query}}`, true},
+		{"raymond query of every repo", "sidebar.html.hb", `{{#query}}{{#each Items}}{{title}};{{/each}}{{/query}}`, true},
+		{"raymond query of another repo", "sidebar.html.hb", `{{#query repo="notes"}}{{#each Items}}{{title}};{{/each}}{{/query}}`, false},
+		{"go query of the repo", "sidebar.gohtml", `{{with query "repo" "blog"}}{{range .Items}}{{.Title}};{{end}}{{end}}`, true},
+		{"go query of a variable repo", "sidebar.gohtml", `{{with query "repo" .config.sidebar_repo}}{{range .Items}}{{.Title}};{{end}}{{end}}`, true},
+		{"go query of another repo", "sidebar.gohtml", `{{with query "repo" "notes"}}{{range .Items}}{{.Title}};{{end}}{{end}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupLoaderTest(t, map[string]string{
+				"/blog/one.md": "---\ntitle: One\n---\n\nFirst post.",
+			})
+			ClearPageCache()
+			t.Cleanup(ClearPageCache)
+
+			registerHelpersOnce.Do(RegisterTemplateHelpers)
+			viper.Set("page_cache.enabled", true)
+			viper.Set("template_dir", "/tpl")
+			viper.Set("sidebar_repo", "blog")
+			viper.Set("routes.sidebar.path", "/sidebar")
+			viper.Set("routes.sidebar.templates", []string{tt.file})
+			afero.WriteFile(Vfs, "/tpl/"+tt.file, []byte(tt.source), 0644)
+			RegisterPartials()
+			DBLoadRepo("blog")
+
+			router := mux.NewRouter()
+			router.HandleFunc("/sidebar", catchallHandler).Name("sidebar")
+			key := pageCacheKey("sidebar", map[string]string{}, url.Values{})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/sidebar", nil))
+			if getCachedPage(key) == nil {
+				t.Fatalf("Expected the page to be cached, got %d %q", rec.Code, rec.Body)
+			}
+
+			afero.WriteFile(Vfs, "/blog/two.md", []byte("---\ntitle: Two\n---\n\nSecond post."), 0644)
+			reloadItem("blog", "/blog", "/blog/two.md")
+			if rerendered := getCachedPage(key) == nil; rerendered != tt.rerender {
+				t.Errorf("Page removed from the cache = %v, want %v", rerendered, tt.rerender)
+			}
+			if !tt.rerender {
+				return
+			}
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/sidebar", nil))
+			if !strings.Contains(rec.Body.String(), "Two;") {
+				t.Errorf("Expected the page to show the changed items, got %q", rec.Body)
+			}
+		})
+	}
+}
+
+func TestStoreCachedPage_StaleGeneration(t *testing.T) {
+	viper.Reset()
+	ClearPageCache()
+	t.Cleanup(ClearPageCache)
+
+	generation := currentPageGeneration()
+	InvalidateRepoPages("blog")
+	storeCachedPage("stale", []byte("old"), "text/html", map[string]bool{pageDepRepo("blog"): true}, generation)
+	if getCachedPage("stale") != nil {
+		t.Error("Expected a page rendered before an invalidation not to be cached")
+	}
+
+	storeCachedPage("fresh", []byte("new"), "text/html", map[string]bool{pageDepComments("blog", "one"): true}, currentPageGeneration())
+	InvalidateCommentPages("blog", "two")
+	if getCachedPage("fresh") == nil {
+		t.Error("Expected comments on another item to leave the page cached")
+	}
+	InvalidateCommentPages("blog", "one")
+	if getCachedPage("fresh") != nil {
+		t.Error("Expected a new comment to invalidate the page")
+	}
+}
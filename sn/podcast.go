@@ -0,0 +1,294 @@
+package sn
+
+import (
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/spf13/viper"
+)
+
+const (
+	itunesNamespace  = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+	podcastNamespace = "https://podcastindex.org/namespace/1.0"
+)
+
+// feedPodcast is the show a podcast feed describes to podcast directories
+type feedPodcast struct {
+	Author      string
+	Owner       feedAuthor
+	Image       string
+	Category    string
+	Subcategory string
+	Explicit    bool
+	Type        string // episodic or serial
+	GUID        string
+	Locked      bool
+}
+
+// feedEnclosure is the media file of an item
+type feedEnclosure struct {
+	URL    string
+	Length int64
+	Type   string
+}
+
+// feedEpisode is the podcast frontmatter of an item
+type feedEpisode struct {
+	Duration    string
+	Episode     string
+	Season      string
+	EpisodeType string
+	Explicit    string
+	Image       string
+}
+
+// enclosureLengthTTL is how long the length of an enclosure, or the failure to find it, is
+// cached before it is looked up again
+const enclosureLengthTTL = time.Hour
+
+// cachedLength is the length of an enclosure, 0 when it could not be found
+type cachedLength struct {
+	length  int64
+	expires time.Time
+}
+
+// enclosureLengths caches the byte lengths of enclosures, which can take a request to find
+var enclosureLengths sync.Map
+
+// newFeedPodcast returns the podcast settings of a feed route, or nil when the feed is not a
+// podcast.  The image, author and owner default to those of the feed.
+func newFeedPodcast(feedConfigLocation string, feed *feedDocument) *feedPodcast {
+	podcastLocation := fmt.Sprintf("%s.podcast", feedConfigLocation)
+	if _, ok := viper.Get(podcastLocation).(map[string]interface{}); !ok && !viper.GetBool(podcastLocation) {
+		return nil
+	}
+
+	setting := func(name string) string {
+		return viper.GetString(fmt.Sprintf("%s.%s", podcastLocation, name))
+	}
+	podcast := &feedPodcast{
+		Author:      ConfigStringDefault(fmt.Sprintf("%s.author", podcastLocation), feed.Author.Name),
+		Owner:       feed.Author,
+		Image:       feed.Icon,
+		Category:    setting("category"),
+		Subcategory: setting("subcategory"),
+		Explicit:    viper.GetBool(fmt.Sprintf("%s.explicit", podcastLocation)),
+		Type:        setting("type"),
+		GUID:        setting("guid"),
+		Locked:      viper.GetBool(fmt.Sprintf("%s.locked", podcastLocation)),
+	}
+	if image := setting("image"); image != "" {
+		podcast.Image = absoluteFeedURL(s3URL(image))
+	}
+	if name := setting("owner.name"); name != "" {
+		podcast.Owner = feedAuthor{Name: name, Email: setting("owner.email")}
+	}
+	return podcast
+}
+
+// newFeedEnclosure returns the audio frontmatter of an item as an enclosure, or nil when it
+// has none.  The audio is an s3:// reference, a URL, or a path inside of the root path.  Its
+// length is the audio_length frontmatter, or else looked up when lookup is set.
+func newFeedEnclosure(item Item, lookup bool) *feedEnclosure {
+	audio := item.Frontmatter["audio"]
+	if audio == "" {
+		return nil
+	}
+
+	enclosure := &feedEnclosure{
+		URL:  absoluteFeedURL(s3URL(audio)),
+		Type: item.Frontmatter["audio_type"],
+	}
+	if length, err := strconv.ParseInt(item.Frontmatter["audio_length"], 10, 64); err == nil && length >= 0 {
+		enclosure.Length = length
+	} else if lookup {
+		enclosure.Length = enclosureLength(audio)
+	}
+	if enclosure.Type == "" {
+		enclosure.Type = mime.TypeByExtension(path.Ext(strings.SplitN(audio, "?", 2)[0]))
+	}
+	if enclosure.Type == "" {
+		enclosure.Type = "audio/mpeg"
+	}
+	return enclosure
+}
+
+// newFeedEpisode returns the podcast frontmatter of an item
+func newFeedEpisode(item Item) *feedEpisode {
+	episode := &feedEpisode{
+		Duration:    item.Frontmatter["duration"],
+		Episode:     item.Frontmatter["episode"],
+		Season:      item.Frontmatter["season"],
+		EpisodeType: item.Frontmatter["episode_type"],
+	}
+	if explicit, err := strconv.ParseBool(item.Frontmatter["explicit"]); err == nil {
+		episode.Explicit = strconv.FormatBool(explicit)
+	}
+	if hero := item.Frontmatter["hero"]; hero != "" {
+		episode.Image = absoluteFeedURL(s3URL(hero))
+	}
+	return episode
+}
+
+// enclosureLength returns the byte length of an enclosure from the Vfs, or a HEAD request
+// to its s3 bucket or URL, or 0 when it cannot be found.  Either answer is cached for
+// enclosureLengthTTL.
+func enclosureLength(src string) int64 {
+	if cached, ok := enclosureLengths.Load(src); ok && time.Now().Before(cached.(cachedLength).expires) {
+		return cached.(cachedLength).length
+	}
+
+	var length int64
+	var err error
+	if bucket, filename, ok := s3Location(src); ok && spacesConfig(bucket).Endpoint != "" {
+		length, err = s3ObjectLength(bucket, filename)
+	} else if target := s3URL(src); strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		length, err = remoteLength(target)
+	} else if info, statErr := Vfs.Stat(path.Join(viper.GetString("path"), src)); statErr != nil {
+		err = statErr
+	} else {
+		length = info.Size()
+	}
+	if err != nil {
+		slog.Warn("Could not find the length of an enclosure", "src", src, "error", err)
+		length = 0
+	}
+
+	enclosureLengths.Store(src, cachedLength{length: length, expires: time.Now().Add(enclosureLengthTTL)})
+	return length
+}
+
+// s3ObjectLength asks the s3 bucket for the size of a file
+func s3ObjectLength(bucket string, filename string) (int64, error) {
+	spaceConf := spacesConfig(bucket)
+	if spaceConf.SpaceName == "" {
+		spaceConf.SpaceName = bucket
+	}
+	client, err := newSpacesClient(spaceConf)
+	if err != nil {
+		return 0, err
+	}
+	head, err := client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(spaceConf.SpaceName), Key: aws.String(filename)})
+	if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(head.ContentLength), nil
+}
+
+// remoteLength returns the Content-Length of a HEAD request to a URL
+func remoteLength(target string) (int64, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Head(target)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s returned status %d", target, resp.StatusCode)
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("%s has no Content-Length", target)
+	}
+	return resp.ContentLength, nil
+}
+
+// durationSeconds parses a duration of seconds, mm:ss or hh:mm:ss
+func durationSeconds(duration string) (int, bool) {
+	seconds := 0
+	for _, part := range strings.Split(duration, ":") {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || value < 0 {
+			return 0, false
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, duration != ""
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type itunesCategory struct {
+	Text        string          `xml:"text,attr"`
+	Subcategory *itunesCategory `xml:"itunes:category,omitempty"`
+}
+
+type itunesOwner struct {
+	Name  string `xml:"itunes:name"`
+	Email string `xml:"itunes:email,omitempty"`
+}
+
+// rssPodcastChannel is the iTunes and Podcasting 2.0 description of a show
+type rssPodcastChannel struct {
+	ItunesAuthor   string          `xml:"itunes:author,omitempty"`
+	ItunesOwner    *itunesOwner    `xml:"itunes:owner,omitempty"`
+	ItunesImage    *itunesImage    `xml:"itunes:image,omitempty"`
+	ItunesCategory *itunesCategory `xml:"itunes:category,omitempty"`
+	ItunesExplicit string          `xml:"itunes:explicit"`
+	ItunesType     string          `xml:"itunes:type,omitempty"`
+	PodcastLocked  string          `xml:"podcast:locked,omitempty"`
+	PodcastGUID    string          `xml:"podcast:guid,omitempty"`
+}
+
+// rssPodcastItem is the iTunes and Podcasting 2.0 description of an episode
+type rssPodcastItem struct {
+	ItunesDuration    string       `xml:"itunes:duration,omitempty"`
+	ItunesEpisode     string       `xml:"itunes:episode,omitempty"`
+	ItunesSeason      string       `xml:"itunes:season,omitempty"`
+	ItunesEpisodeType string       `xml:"itunes:episodeType,omitempty"`
+	ItunesExplicit    string       `xml:"itunes:explicit,omitempty"`
+	ItunesImage       *itunesImage `xml:"itunes:image,omitempty"`
+	PodcastEpisode    string       `xml:"podcast:episode,omitempty"`
+	PodcastSeason     string       `xml:"podcast:season,omitempty"`
+}
+
+func newRSSPodcastChannel(podcast *feedPodcast) *rssPodcastChannel {
+	channel := &rssPodcastChannel{
+		ItunesAuthor:   podcast.Author,
+		ItunesExplicit: strconv.FormatBool(podcast.Explicit),
+		ItunesType:     podcast.Type,
+		PodcastGUID:    podcast.GUID,
+	}
+	if podcast.Owner.Name != "" || podcast.Owner.Email != "" {
+		channel.ItunesOwner = &itunesOwner{Name: podcast.Owner.Name, Email: podcast.Owner.Email}
+	}
+	if podcast.Image != "" {
+		channel.ItunesImage = &itunesImage{Href: podcast.Image}
+	}
+	if podcast.Category != "" {
+		channel.ItunesCategory = &itunesCategory{Text: podcast.Category}
+		if podcast.Subcategory != "" {
+			channel.ItunesCategory.Subcategory = &itunesCategory{Text: podcast.Subcategory}
+		}
+	}
+	if podcast.Locked {
+		channel.PodcastLocked = "yes"
+	}
+	return channel
+}
+
+func newRSSPodcastItem(episode *feedEpisode) *rssPodcastItem {
+	item := &rssPodcastItem{
+		ItunesDuration:    episode.Duration,
+		ItunesEpisode:     episode.Episode,
+		ItunesSeason:      episode.Season,
+		ItunesEpisodeType: episode.EpisodeType,
+		ItunesExplicit:    episode.Explicit,
+		PodcastEpisode:    episode.Episode,
+		PodcastSeason:     episode.Season,
+	}
+	if episode.Image != "" {
+		item.ItunesImage = &itunesImage{Href: episode.Image}
+	}
+	return item
+}
@@ -0,0 +1,134 @@
+package sn
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+func TestPodcastFeed(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/show/one.md": "---\ntitle: Pilot\ndate: 2024-01-01\naudio: /audio/one.mp3\nduration: \"01:02:03\"\nepisode: 1\nseason: 2\nexplicit: false\n---\n\nThe first episode.",
+		"/show/two.md": "---\ntitle: Notes\ndate: 2024-01-02\n---\n\nShow notes without audio.",
+	})
+	afero.WriteFile(Vfs, "/audio/one.mp3", []byte(strings.Repeat("a", 1234)), 0644)
+	viper.Set("repos.show.path", "/show")
+	DBLoadRepo("show")
+
+	viper.Set("rooturl", "https://example.com/")
+	viper.Set("title", "The Show")
+	viper.Set("author.name", "Host")
+	viper.Set("author.email", "host@example.com")
+	viper.Set("routes.podcast.path", "/podcast.xml")
+	viper.Set("routes.podcast.handler", "feed")
+	viper.Set("routes.podcast.feed", map[string]interface{}{
+		"repo":    "show",
+		"podcast": map[string]interface{}{"category": "Technology", "image": "s3://media/cover.jpg", "type": "episodic"},
+	})
+	viper.Set("routes.json.path", "/podcast.json")
+	viper.Set("routes.json.handler", "feed")
+	viper.Set("routes.json.feed", map[string]interface{}{"repo": "show"})
+	viper.Set("s3.media.cdn", "https://cdn.example.com/")
+
+	router := mux.NewRouter()
+	setupRoutes(router)
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Body.String()
+	}
+
+	rss := get("/podcast.xml")
+	for _, expected := range []string{
+		`xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"`,
+		`xmlns:podcast="https://podcastindex.org/namespace/1.0"`,
+		`<itunes:author>Host</itunes:author>`,
+		`<itunes:image href="https://cdn.example.com/cover.jpg"></itunes:image>`,
+		`<itunes:category text="Technology"></itunes:category>`,
+		`<itunes:explicit>false</itunes:explicit>`,
+		`<enclosure url="https://example.com/audio/one.mp3" length="1234" type="audio/mpeg"></enclosure>`,
+		`<itunes:duration>01:02:03</itunes:duration>`,
+		`<itunes:episode>1</itunes:episode>`,
+		`<podcast:season>2</podcast:season>`,
+	} {
+		if !strings.Contains(rss, expected) {
+			t.Errorf("Expected %s in the podcast feed %s", expected, rss)
+		}
+	}
+	if strings.Count(rss, "<enclosure") != 1 {
+		t.Errorf("Expected an enclosure only for the item with audio")
+	}
+
+	if strings.Contains(get("/podcast.json"), "itunes") {
+		t.Error("Expected no podcast namespaces outside of podcast mode")
+	}
+	var feed jsonFeed
+	json.Unmarshal([]byte(get("/podcast.json")), &feed)
+	// Feeds that are not podcasts do not look up the length
+	expected := jsonFeedAttachment{URL: "https://example.com/audio/one.mp3", MimeType: "audio/mpeg", DurationInSeconds: 3723}
+	if len(feed.Items) != 2 || len(feed.Items[1].Attachments) != 1 || feed.Items[1].Attachments[0] != expected {
+		t.Errorf("Expected the audio as an attachment, got %+v", feed.Items)
+	}
+}
+
+func TestFeedEnclosureLength(t *testing.T) {
+	var heads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		heads.Add(1)
+		if r.URL.Path == "/missing.mp3" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", "4321")
+	}))
+	defer server.Close()
+	enclosure := func(frontmatter map[string]string, lookup bool) *feedEnclosure {
+		return newFeedEnclosure(Item{Frontmatter: frontmatter}, lookup)
+	}
+
+	if length := enclosure(map[string]string{"audio": server.URL + "/one.mp3", "audio_length": "555"}, true).Length; length != 555 || heads.Load() != 0 {
+		t.Errorf("Length = %d after %d requests, want the audio_length 555 without a request", length, heads.Load())
+	}
+	if length := enclosure(map[string]string{"audio": server.URL + "/one.mp3"}, false).Length; length != 0 || heads.Load() != 0 {
+		t.Errorf("Length = %d after %d requests, want 0 without a request outside of podcast feeds", length, heads.Load())
+	}
+	for range 2 {
+		if length := enclosure(map[string]string{"audio": server.URL + "/one.mp3"}, true).Length; length != 4321 {
+			t.Errorf("Length = %d, want 4321", length)
+		}
+		if length := enclosure(map[string]string{"audio": server.URL + "/missing.mp3"}, true).Length; length != 0 {
+			t.Errorf("Length of a missing file = %d, want 0", length)
+		}
+	}
+	if heads.Load() != 2 {
+		t.Errorf("Requests = %d, want 2 with the lengths and failures cached", heads.Load())
+	}
+}
+
+func TestDurationSeconds(t *testing.T) {
+	tests := []struct {
+		duration string
+		seconds  int
+		ok       bool
+	}{
+		{"90", 90, true},
+		{"01:30", 90, true},
+		{"1:02:03", 3723, true},
+		{"", 0, false},
+		{"an hour", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.duration, func(t *testing.T) {
+			if seconds, ok := durationSeconds(tt.duration); seconds != tt.seconds || ok != tt.ok {
+				t.Errorf("durationSeconds(%q) = %d, %v", tt.duration, seconds, ok)
+			}
+		})
+	}
+}
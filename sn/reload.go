@@ -0,0 +1,207 @@
+package sn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+var (
+	reloadLock sync.Mutex
+	// configLock guards the global configuration, which Reload replaces under the write lock.
+	// Requests, repo loads and the watchers read it under the read lock, taken where they
+	// start so that it is never taken twice by one goroutine.
+	configLock sync.RWMutex
+	// appliedConfig is the content of the configuration file the server runs with, which a
+	// reload that fails restores
+	appliedConfig []byte
+)
+
+// ReloadOnConfigChange reloads the running server whenever the configuration file changes,
+// until the returned function is called.  The file is read again only by Reload, so an edit
+// that is not valid never reaches the configuration the server runs with.
+func ReloadOnConfigChange() func() {
+	filename := viper.ConfigFileUsed()
+	reloadLock.Lock()
+	appliedConfig, _ = afero.ReadFile(Vfs, filename)
+	reloadLock.Unlock()
+
+	prevModTime := configModTime(filename)
+	ticker := time.NewTicker(1 * time.Second)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			currModTime := configModTime(filename)
+			if currModTime.Equal(prevModTime) {
+				continue
+			}
+			prevModTime = currModTime
+			slog.Info("Configuration changed", "file", filename)
+			if err := Reload(); err != nil {
+				slog.Error("Keeping the previous configuration", "error", err)
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// configModTime returns when the configuration file was last changed, or the zero time
+// when it cannot be read
+func configModTime(filename string) time.Time {
+	info, err := Vfs.Stat(filename)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Reload rereads the configuration file and applies it to the running server.  The new
+// configuration is validated and its router built before anything is swapped in; then the
+// partials are registered again, the template and page caches are cleared, the router is
+// replaced and the repos are synced with the configuration.  The server keeps its
+// connections, and requests in flight finish with the configuration they started with.
+//
+// When the file does not parse, is not valid, or its routes or templates cannot be set up,
+// the configuration the server runs with stays in place.
+func Reload() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	// A repo load reads the configuration until it is done, so wait for it before holding up
+	// requests behind the write lock
+	repoWatchersLock.Lock()
+	defer repoWatchersLock.Unlock()
+
+	configLock.RLock()
+	filename := viper.ConfigFileUsed()
+	configLock.RUnlock()
+	source, err := afero.ReadFile(Vfs, filename)
+	if err != nil {
+		return fmt.Errorf("reading the configuration: %w", err)
+	}
+	candidate := viper.New()
+	candidate.SetConfigType(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if err := candidate.ReadConfig(bytes.NewReader(source)); err != nil {
+		return fmt.Errorf("parsing the configuration: %w", err)
+	}
+	if err := validateConfig(candidate); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if err := swapConfig(source); err != nil {
+		return err
+	}
+	syncRepos()
+
+	slog.Info("Reloaded configuration", "file", filename)
+	return nil
+}
+
+// swapConfig replaces the global configuration with source and applies it, or puts back the
+// configuration the server runs with when it cannot be applied
+func swapConfig(source []byte) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	if err := viper.ReadConfig(bytes.NewReader(source)); err != nil {
+		return restoreConfig(fmt.Errorf("parsing the configuration: %w", err))
+	}
+	if err := applyConfig(); err != nil {
+		return restoreConfig(fmt.Errorf("applying the configuration: %w", err))
+	}
+	appliedConfig = source
+	return nil
+}
+
+// restoreConfig puts back the configuration the server runs with and returns err.  The
+// caller holds the write lock of configLock.
+func restoreConfig(err error) error {
+	if appliedConfig != nil {
+		if restoreErr := viper.ReadConfig(bytes.NewReader(appliedConfig)); restoreErr != nil {
+			return errors.Join(err, restoreErr)
+		}
+	}
+	return err
+}
+
+// applyConfig builds the router and registers the partials for the current configuration,
+// then swaps the router in.  Setting up a missing directory panics, which is an error here so
+// the previous router stays in place.
+func applyConfig() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	newRouter := serverRouter()
+	// A route whose path does not compile has its error instead of its name
+	err = newRouter.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		return route.GetError()
+	})
+	if err != nil {
+		return err
+	}
+
+	RegisterPartials()
+	clearTemplateCache()
+	router.Store(newRouter)
+	return nil
+}
+
+// validateConfig checks that every route has a path and a known handler, with a target for
+// redirects, and that every repo has a path
+func validateConfig(config *viper.Viper) error {
+	var errs []error
+
+	routeNames := make([]string, 0, len(config.GetStringMap("routes")))
+	for routeName := range config.GetStringMap("routes") {
+		routeNames = append(routeNames, routeName)
+	}
+	sort.Strings(routeNames)
+	for _, routeName := range routeNames {
+		routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+		if config.GetString(fmt.Sprintf("%s.path", routeConfigLocation)) == "" {
+			errs = append(errs, fmt.Errorf("route %s has no path", routeName))
+		}
+		handler := config.GetString(fmt.Sprintf("%s.handler", routeConfigLocation))
+		if handler != "" && !slices.Contains(routeHandlers, handler) {
+			errs = append(errs, fmt.Errorf("route %s has unknown handler %q", routeName, handler))
+		}
+		if handler == "redirect" && config.GetString(fmt.Sprintf("%s.to", routeConfigLocation)) == "" {
+			errs = append(errs, fmt.Errorf("redirect route %s has no to", routeName))
+		}
+	}
+
+	repoNames := make([]string, 0, len(config.GetStringMap("repos")))
+	for repoName := range config.GetStringMap("repos") {
+		repoNames = append(repoNames, repoName)
+	}
+	sort.Strings(repoNames)
+	for _, repoName := range repoNames {
+		if config.GetString(fmt.Sprintf("repos.%s.path", repoName)) == "" {
+			errs = append(errs, fmt.Errorf("repo %s has no path", repoName))
+		}
+	}
+
+	return errors.Join(errs...)
+}
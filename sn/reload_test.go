@@ -0,0 +1,312 @@
+package sn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+const reloadTestConfig = `template_dir: /tpl
+repos:
+  blog:
+    path: /blog
+routes:
+  about:
+    path: /about
+    templates: [about.html.hb]
+`
+
+func setupReloadTest(t *testing.T) {
+	t.Helper()
+	setupLoaderTest(t, map[string]string{
+		"/sn.yaml":             reloadTestConfig,
+		"/tpl/about.html.hb":   "About",
+		"/tpl/contact.html.hb": "Contact",
+	})
+	ClearPageCache()
+	t.Cleanup(ClearPageCache)
+	origRouter := router.Load()
+	t.Cleanup(func() { router.Store(origRouter) })
+
+	// The repos come from the configuration file, which settings made with viper.Set would hide
+	viper.Reset()
+	viper.SetFs(Vfs)
+	viper.SetConfigFile("/sn.yaml")
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	RegisterPartials()
+	ReloadRouter()
+	appliedConfig = []byte(reloadTestConfig)
+	t.Cleanup(func() { appliedConfig = nil })
+}
+
+func TestReload(t *testing.T) {
+	setupReloadTest(t)
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.Load().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+	if status, _ := get("/contact"); status != http.StatusNotFound {
+		t.Fatalf("Status of /contact = %d before the reload, want 404", status)
+	}
+
+	previous := router.Load()
+	afero.WriteFile(Vfs, "/sn.yaml", []byte(reloadTestConfig+"  contact:\n    path: /contact\n    templates: [contact.html.hb]\n"), 0644)
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := get("/contact"); status != http.StatusOK || body != "Contact" {
+		t.Errorf("/contact = %d %q after the reload, want 200 Contact", status, body)
+	}
+	if router.Load() == previous {
+		t.Error("Expected the reload to swap in a new router")
+	}
+
+	// A configuration that does not parse leaves the current one in place
+	afero.WriteFile(Vfs, "/sn.yaml", []byte("routes: [unclosed"), 0644)
+	if err := Reload(); err == nil {
+		t.Error("Expected an error for an invalid configuration")
+	}
+	if status, _ := get("/contact"); status != http.StatusOK {
+		t.Errorf("Status of /contact = %d after a failed reload, want 200", status)
+	}
+}
+
+func TestReloadKeepsConfigWhenInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"route without a path", reloadTestConfig + "  contact:\n    templates: [contact.html.hb]\n"},
+		{"unknown handler", reloadTestConfig + "  contact:\n    path: /contact\n    handler: contacts\n"},
+		{"redirect without a target", reloadTestConfig + "  contact:\n    path: /contact\n    handler: redirect\n"},
+		{"route path that does not compile", reloadTestConfig + "  contact:\n    path: /{contact\n"},
+		{"repo without a path", strings.Replace(reloadTestConfig, "repos:\n", "repos:\n  notes:\n    activitypub: false\n", 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupReloadTest(t)
+			previous := router.Load()
+
+			afero.WriteFile(Vfs, "/sn.yaml", []byte(tt.config), 0644)
+			if err := Reload(); err == nil {
+				t.Fatal("Expected an error for an invalid configuration")
+			}
+			if viper.IsSet("routes.contact") || viper.IsSet("repos.notes") {
+				t.Error("Expected the previous configuration to stay in place")
+			}
+			if router.Load() != previous {
+				t.Error("Expected the previous router to stay in place")
+			}
+		})
+	}
+}
+
+func TestReloadOnConfigChange(t *testing.T) {
+	setupReloadTest(t)
+	t.Cleanup(ReloadOnConfigChange())
+
+	// An edit that is not valid is never read into the configuration
+	previous := router.Load()
+	afero.WriteFile(Vfs, "/sn.yaml", []byte(reloadTestConfig+"  contact:\n    path: /contact\n    handler: contacts\n"), 0644)
+	for deadline := time.Now().Add(1500 * time.Millisecond); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		configLock.RLock()
+		contact := viper.IsSet("routes.contact")
+		configLock.RUnlock()
+		if contact {
+			t.Fatal("Expected an invalid edit to stay out of the configuration")
+		}
+	}
+	if router.Load() != previous {
+		t.Error("Expected the previous router to stay in place")
+	}
+
+	afero.WriteFile(Vfs, "/sn.yaml", []byte(reloadTestConfig+"  contact:\n    path: /contact\n    templates: [contact.html.hb]\n"), 0644)
+	waitFor(t, "the edit to be applied", func() bool {
+		rec := httptest.NewRecorder()
+		router.Load().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/contact", nil))
+		return rec.Code == http.StatusOK && rec.Body.String() == "Contact"
+	})
+}
+
+// stopRepoWatchersOnCleanup stops the repo watchers started by a test
+func stopRepoWatchersOnCleanup(t *testing.T) {
+	t.Cleanup(func() {
+		repoWatchersLock.Lock()
+		defer repoWatchersLock.Unlock()
+		for _, watcher := range repoWatchers {
+			if watcher.stop != nil {
+				watcher.stop()
+			}
+		}
+		repoWatchers = nil
+	})
+}
+
+func countRepoItems(t *testing.T, repo string) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM items WHERE repo = ?", repo).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestReloadSyncsRepos(t *testing.T) {
+	setupReloadTest(t)
+	afero.WriteFile(Vfs, "/notes/one.md", []byte("---\ntitle: One\n---\n\nA note."), 0644)
+	StartWatchingRepos()
+	stopRepoWatchersOnCleanup(t)
+	countItems := func(repo string) int {
+		t.Helper()
+		return countRepoItems(t, repo)
+	}
+
+	afero.WriteFile(Vfs, "/sn.yaml", []byte(strings.Replace(reloadTestConfig, "repos:\n", "repos:\n  notes:\n    path: /notes\n", 1)), 0644)
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if count := countItems("notes"); count != 1 {
+		t.Errorf("Items of an added repo = %d, want 1", count)
+	}
+	repoWatchersLock.Lock()
+	watcher, watched := repoWatchers["notes"]
+	repoWatchersLock.Unlock()
+	if !watched || watcher.stop == nil {
+		t.Error("Expected an added repo to be watched")
+	}
+
+	afero.WriteFile(Vfs, "/sn.yaml", []byte(reloadTestConfig), 0644)
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if count := countItems("notes"); count != 0 {
+		t.Errorf("Items of a removed repo = %d, want 0", count)
+	}
+	repoWatchersLock.Lock()
+	_, watched = repoWatchers["notes"]
+	_, blogWatched := repoWatchers["blog"]
+	repoWatchersLock.Unlock()
+	if watched {
+		t.Error("Expected a removed repo to no longer be watched")
+	}
+	if !blogWatched {
+		t.Error("Expected the repos that stay to be watched")
+	}
+}
+
+func TestReloadDuringInitialLoad(t *testing.T) {
+	setupReloadTest(t)
+	afero.WriteFile(Vfs, "/blog/post.md", []byte("---\ntitle: Post\n---\n\nA post."), 0644)
+	afero.WriteFile(Vfs, "/notes/one.md", []byte("---\ntitle: One\n---\n\nA note."), 0644)
+	stopRepoWatchersOnCleanup(t)
+
+	// An open write keeps the initial load waiting on the database
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("DELETE FROM items WHERE repo = 'none'"); err != nil {
+		t.Fatal(err)
+	}
+	loaded := make(chan struct{})
+	go func() {
+		LoadRepos()
+		close(loaded)
+	}()
+	waitFor(t, "the initial load to start", func() bool {
+		if repoWatchersLock.TryLock() {
+			repoWatchersLock.Unlock()
+			return false
+		}
+		return true
+	})
+
+	afero.WriteFile(Vfs, "/sn.yaml", []byte(strings.Replace(reloadTestConfig, "repos:\n", "repos:\n  notes:\n    path: /notes\n", 1)), 0644)
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- Reload() }()
+	waitFor(t, "the reload to start", func() bool {
+		if reloadLock.TryLock() {
+			reloadLock.Unlock()
+			return false
+		}
+		return true
+	})
+	// The configuration does not change under the load
+	time.Sleep(50 * time.Millisecond)
+	if viper.IsSet("repos.notes") {
+		t.Error("Expected the reload to wait for the initial load")
+	}
+	tx.Rollback()
+	<-loaded
+	if err := <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+
+	if count := countRepoItems(t, "blog"); count != 1 {
+		t.Errorf("Items of the initial repo = %d, want 1", count)
+	}
+	if count := countRepoItems(t, "notes"); count != 1 {
+		t.Errorf("Items of a repo added during the initial load = %d, want 1", count)
+	}
+	repoWatchersLock.Lock()
+	_, watched := repoWatchers["notes"]
+	repoWatchersLock.Unlock()
+	if !watched {
+		t.Error("Expected a repo added during the initial load to be watched")
+	}
+}
+
+func TestWebserverShutdown(t *testing.T) {
+	setupReloadTest(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	viper.Set("port", port)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- WebserverStart(ctx) }()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/about", port)
+	waitFor(t, "the server to answer", func() bool {
+		resp, err := http.Get(url)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode == http.StatusOK && string(body) == "About"
+	})
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("WebserverStart returned %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the server to shut down")
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("Expected the server to stop accepting connections")
+	}
+}
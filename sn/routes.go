@@ -0,0 +1,144 @@
+package sn
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ringmaster/Sn/sn/activitypub"
+	"github.com/spf13/viper"
+)
+
+// RouteInfo describes a route of the router, in the order the router tries them
+type RouteInfo struct {
+	Name    string
+	Handler string // The handler setting of the configured route the route belongs to
+	Path    string // The path template, ending in * for a path prefix
+	Methods []string
+}
+
+// RouteMatchInfo describes the route that answers a request
+type RouteMatchInfo struct {
+	Route   RouteInfo
+	Vars    map[string]string
+	Queries map[string]map[string]interface{} // The item queries of the route, with the path variables and query parameters of the request
+	Shadows []RouteInfo                       // Later routes that would also match the request
+}
+
+// RouteTable lists the routes of the configuration, including the ActivityPub routes, without
+// starting the server
+func RouteTable() []RouteInfo {
+	routes := make([]RouteInfo, 0)
+	routeTableRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if isTableRoute(route) {
+			routes = append(routes, newRouteInfo(route))
+		}
+		return nil
+	})
+	return routes
+}
+
+// MatchRoute finds the route that answers a request for a URL path, which may have a query
+// string, with a method and Accept header
+func MatchRoute(target string, method string, accept string) (RouteMatchInfo, bool) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return RouteMatchInfo{}, false
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	var info RouteMatchInfo
+	found := false
+	routeTableRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if !isTableRoute(route) {
+			return nil
+		}
+		var match mux.RouteMatch
+		if !route.Match(req, &match) || match.MatchErr != nil {
+			return nil
+		}
+		if found {
+			info.Shadows = append(info.Shadows, newRouteInfo(route))
+			return nil
+		}
+		found = true
+		info.Route = newRouteInfo(route)
+		info.Vars = match.Vars
+		if info.Vars == nil {
+			info.Vars = make(map[string]string)
+		}
+		return nil
+	})
+	if !found {
+		return info, false
+	}
+
+	params := maps.Clone(info.Vars)
+	for param, values := range req.URL.Query() {
+		params[fmt.Sprintf("params.%s", param)] = values[0]
+	}
+	info.Queries = make(map[string]map[string]interface{})
+	for name, query := range routeNamedQueries(info.Route.Name) {
+		info.Queries[name] = replaceParams(maps.Clone(query), params)
+	}
+	return info, true
+}
+
+// routeTableRouter builds the router of the configuration.  When ActivityPub has not been
+// initialized, its routes come from a manager that has no services.
+func routeTableRouter() *mux.Router {
+	if ActivityPubManager == nil {
+		ActivityPubManager = activitypub.NewRouteManager()
+		defer func() { ActivityPubManager = nil }()
+	}
+	tableRouter := mux.NewRouter()
+	setupRoutes(tableRouter)
+	return tableRouter
+}
+
+// isTableRoute reports whether a route answers requests itself, rather than holding a
+// subrouter, and has a path
+func isTableRoute(route *mux.Route) bool {
+	_, err := route.GetPathTemplate()
+	return err == nil && route.GetHandler() != nil
+}
+
+func newRouteInfo(route *mux.Route) RouteInfo {
+	info := RouteInfo{Name: route.GetName(), Handler: routeHandlerName(route.GetName())}
+	info.Path, _ = route.GetPathTemplate()
+	if pathRegexp, err := route.GetPathRegexp(); err == nil && !strings.HasSuffix(pathRegexp, "$") {
+		info.Path += "*"
+	}
+	info.Methods, _ = route.GetMethods()
+	return info
+}
+
+// routeHandlerName returns the handler of the configured route a router route belongs to.
+// Configured routes can add routes named with their own name as a prefix, such as the API of
+// the frontend handler.
+func routeHandlerName(name string) string {
+	routeNames := sortedRouteNames()
+	sort.Slice(routeNames, func(i, j int) bool {
+		return len(routeNames[i]) > len(routeNames[j])
+	})
+	for _, routeName := range routeNames {
+		if name == routeName || strings.HasPrefix(strings.TrimPrefix(name, "000"), routeName+"_") {
+			if handler := viper.GetString(fmt.Sprintf("routes.%s.handler", routeName)); handler != "" {
+				return handler
+			}
+			return "catchall"
+		}
+	}
+	switch {
+	case strings.HasPrefix(name, "activitypub-"):
+		return "activitypub"
+	case name == "well-known-webfinger":
+		return "webfinger"
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package sn
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+func setupRoutesTest(t *testing.T) {
+	t.Helper()
+	setupLoaderTest(t, map[string]string{})
+	viper.Set("routes.01_feed.path", "/tag/{tag}/feed.atom")
+	viper.Set("routes.01_feed.handler", "feed")
+	viper.Set("routes.01_feed.feed", map[string]interface{}{"repo": "blog", "tag": "{tag}"})
+	viper.Set("routes.02_post.path", "/posts/{slug}")
+	viper.Set("routes.02_post.out", map[string]interface{}{
+		"item": map[string]interface{}{"repo": "blog", "slug": "{slug}"},
+	})
+	viper.Set("routes.03_page.path", "/{slug}")
+	viper.Set("routes.03_page.out", map[string]interface{}{
+		"page":   map[string]interface{}{"repo": "blog", "slug": "{slug}", "search": "{params.q}"},
+		"recent": map[string]interface{}{"repo": "blog", "paginate_count": 5},
+	})
+}
+
+func TestRouteTable(t *testing.T) {
+	tests := []struct {
+		name        string
+		activitypub bool
+		want        []RouteInfo
+	}{
+		{"without activitypub", false, []RouteInfo{
+			{Name: "01_feed", Handler: "feed", Path: "/tag/{tag}/feed.atom"},
+			{Name: "02_post", Handler: "catchall", Path: "/posts/{slug}"},
+			{Name: "03_page", Handler: "catchall", Path: "/{slug}"},
+			{Name: "well-known-webfinger", Handler: "webfinger", Path: "/.well-known/webfinger"},
+		}},
+		{"with activitypub", true, []RouteInfo{
+			{Name: "activitypub-webfinger", Handler: "activitypub", Path: "/.well-known/webfinger", Methods: []string{"GET"}},
+			{Name: "activitypub-inbox", Handler: "activitypub", Path: "/@{username}/inbox", Methods: []string{"POST"}},
+			{Name: "01_feed", Handler: "feed", Path: "/tag/{tag}/feed.atom"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupRoutesTest(t)
+			viper.Set("activitypub.enabled", tt.activitypub)
+
+			routes := RouteTable()
+			if ActivityPubManager != nil {
+				t.Error("Expected the route table to leave ActivityPub uninitialized")
+			}
+			// Listing the routes opens no ActivityPub storage, so it writes no keys
+			if exists, _ := afero.DirExists(Vfs, "/.activitypub"); exists {
+				t.Error("Expected the route table to write no ActivityPub storage")
+			}
+			if _, err := os.Stat(".activitypub"); err == nil {
+				t.Error("Expected the route table to write nothing into the working directory")
+			}
+			last := -1
+			for _, want := range tt.want {
+				index := slices.IndexFunc(routes, func(route RouteInfo) bool { return route.Name == want.Name })
+				if index < 0 {
+					t.Errorf("Expected route %s in %v", want.Name, routes)
+					continue
+				}
+				if index < last {
+					t.Errorf("Expected route %s after the routes before it", want.Name)
+				}
+				last = index
+				got := routes[index]
+				if got.Handler != want.Handler || got.Path != want.Path || !slices.Equal(got.Methods, want.Methods) {
+					t.Errorf("Route = %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	setupRoutesTest(t)
+
+	tests := []struct {
+		name    string
+		target  string
+		method  string
+		route   string
+		vars    map[string]string
+		queries map[string]map[string]interface{}
+	}{
+		{"feed", "/tag/go/feed.atom", "GET", "01_feed",
+			map[string]string{"tag": "go"},
+			map[string]map[string]interface{}{"feed": {"repo": "blog", "tag": "go"}}},
+		{"post", "/posts/hello", "GET", "02_post",
+			map[string]string{"slug": "hello"},
+			map[string]map[string]interface{}{"item": {"repo": "blog", "slug": "hello"}}},
+		{"page with a query parameter", "/about?q=cats", "GET", "03_page",
+			map[string]string{"slug": "about"},
+			map[string]map[string]interface{}{
+				"page":   {"repo": "blog", "slug": "about", "search": "cats"},
+				"recent": {"repo": "blog", "paginate_count": 5},
+			}},
+		{"path next to webfinger", "/.well-known", "GET", "03_page",
+			map[string]string{"slug": ".well-known"}, nil},
+		{"not found", "/posts/a/b", "GET", "", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, ok := MatchRoute(tt.target, tt.method, "")
+			if ok != (tt.route != "") {
+				t.Fatalf("Matched = %v, want %v", ok, tt.route != "")
+			}
+			if !ok {
+				return
+			}
+			if match.Route.Name != tt.route {
+				t.Errorf("Route = %s, want %s", match.Route.Name, tt.route)
+			}
+			for name, value := range tt.vars {
+				if match.Vars[name] != value {
+					t.Errorf("Var %s = %q, want %q", name, match.Vars[name], value)
+				}
+			}
+			for name, query := range tt.queries {
+				for field, value := range query {
+					if match.Queries[name][field] != value {
+						t.Errorf("Query %s.%s = %v, want %v", name, field, match.Queries[name][field], value)
+					}
+				}
+			}
+		})
+	}
+
+	// A route is shadowed by the earlier routes that match the same request
+	match, ok := MatchRoute("/posts", "GET", "")
+	if !ok || match.Route.Name != "03_page" {
+		t.Fatalf("Expected /posts to match 03_page, got %+v", match)
+	}
+	viper.Set("routes.00_all.path", "/{section}")
+	match, _ = MatchRoute("/posts", "GET", "")
+	if match.Route.Name != "00_all" || len(match.Shadows) != 1 || match.Shadows[0].Name != "03_page" {
+		t.Errorf("Expected 00_all to shadow 03_page, got %+v", match)
+	}
+}
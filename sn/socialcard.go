@@ -0,0 +1,333 @@
+package sn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/webp"
+)
+
+// Social cards are the size Open Graph and Twitter recommend for large images
+const (
+	socialCardWidth  = 1200
+	socialCardHeight = 630
+	socialCardMargin = 80
+)
+
+// socialCardCacheEntries is the number of rendered cards kept in memory
+const socialCardCacheEntries = 500
+
+// socialCardHeroLimit is the largest hero image that is downloaded for a card
+const socialCardHeroLimit = 20 << 20
+
+var (
+	socialCardBackground = color.RGBA{0x1f, 0x29, 0x33, 0xff}
+	socialCardShade      = color.RGBA{0, 0, 0, 0x99}
+	socialCardAccent     = color.RGBA{0x3b, 0x82, 0xf6, 0xff}
+	socialCardMuted      = color.RGBA{0xd1, 0xd5, 0xdb, 0xff}
+)
+
+// socialCard is a rendered card image
+type socialCard struct {
+	png  []byte
+	etag string
+}
+
+var (
+	socialCards     = make(map[string]*socialCard)
+	socialCardsLock sync.Mutex
+)
+
+// socialCardContent is what a card shows
+type socialCardContent struct {
+	Title string
+	Site  string
+	Date  string
+	Hero  image.Image
+}
+
+// SocialCardURL returns the URL of the social card image of an item, or "" when no route
+// has the ogimage handler
+func SocialCardURL(item Item) string {
+	cardPath, ok := socialCardPath(item)
+	if !ok {
+		return ""
+	}
+	return rootURL() + cardPath
+}
+
+// socialCardPath returns the path of an item's card on the first ogimage route
+func socialCardPath(item Item) (string, bool) {
+	for _, routeName := range sortedRouteNames() {
+		routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+		if viper.GetString(fmt.Sprintf("%s.handler", routeConfigLocation)) != "ogimage" {
+			continue
+		}
+		complete := true
+		cardPath := routeVariable.ReplaceAllStringFunc(viper.GetString(fmt.Sprintf("%s.path", routeConfigLocation)), func(match string) string {
+			switch routeVariable.FindStringSubmatch(match)[1] {
+			case "repo":
+				return url.PathEscape(item.Repo)
+			case "slug":
+				segments := strings.Split(item.Slug, "/")
+				for i, segment := range segments {
+					segments[i] = url.PathEscape(segment)
+				}
+				return strings.Join(segments, "/")
+			}
+			complete = false
+			return match
+		})
+		return cardPath, complete
+	}
+	return "", false
+}
+
+// socialCardHandler serves the card of the item named by the repo and slug path variables
+func socialCardHandler(w http.ResponseWriter, r *http.Request) {
+	routeName := mux.CurrentRoute(r).GetName()
+	vars := mux.Vars(r)
+	repo, slug := vars["repo"], vars["slug"]
+
+	result := ItemsFromItemQuery(ItemQuery{Repo: &repo, Slug: &slug, Page: 1, PerPage: 1, Fields: []string{}})
+	if len(result.Items) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	card, err := renderSocialCard(routeName, result.Items[0])
+	if err != nil {
+		slog.Error("Error rendering social card", "repo", repo, "slug", slug, "error", err)
+		http.Error(w, "Error rendering social card", http.StatusInternalServerError)
+		return
+	}
+
+	writeRouteHeaders(w, "image/png")
+	w.Header().Set("ETag", card.etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(card.png))
+}
+
+// renderSocialCard returns the card of an item, from the cache when nothing it shows has
+// changed since it was rendered
+func renderSocialCard(routeName string, item Item) (*socialCard, error) {
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+	content := socialCardContent{
+		Title: item.Title,
+		Site:  viper.GetString("title"),
+	}
+	if !item.Date.IsZero() {
+		content.Date = item.Date.Format(ConfigStringDefault(fmt.Sprintf("%s.date_format", routeConfigLocation), "January 2, 2006"))
+	}
+	hero := item.Frontmatter["hero"]
+	fontFile := viper.GetString(fmt.Sprintf("%s.font", routeConfigLocation))
+	titleFontFile := viper.GetString(fmt.Sprintf("%s.title_font", routeConfigLocation))
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{content.Title, content.Site, content.Date, hero, viper.GetString("theme"), fontFile, titleFontFile}, "\x00")))
+	key := hex.EncodeToString(sum[:16])
+
+	socialCardsLock.Lock()
+	card := socialCards[key]
+	socialCardsLock.Unlock()
+	if card != nil {
+		return card, nil
+	}
+
+	staticFs := ThemeFs(themeStatic, "")
+	if viper.IsSet(fmt.Sprintf("%s.dir", routeConfigLocation)) {
+		staticFs = ThemeFs(themeStatic, ConfigPath(fmt.Sprintf("%s.dir", routeConfigLocation), OptionallyExist()))
+	}
+	regular, err := loadCardFont(staticFs, fontFile, goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	bold, err := loadCardFont(staticFs, titleFontFile, gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+	var heroErr error
+	if hero != "" {
+		if content.Hero, heroErr = loadHeroImage(hero); heroErr != nil {
+			slog.Warn("Rendering social card without its hero image", "repo", item.Repo, "slug", item.Slug, "hero", hero, "error", heroErr)
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, drawSocialCard(content, regular, bold)); err != nil {
+		return nil, err
+	}
+	card = &socialCard{png: encoded.Bytes(), etag: fmt.Sprintf(`"%s"`, key)}
+	if heroErr != nil {
+		// Try the hero image again next time
+		return card, nil
+	}
+
+	socialCardsLock.Lock()
+	defer socialCardsLock.Unlock()
+	if len(socialCards) >= socialCardCacheEntries {
+		for k := range socialCards {
+			delete(socialCards, k)
+			break
+		}
+	}
+	socialCards[key] = card
+	return card, nil
+}
+
+// drawSocialCard draws the title over the hero image, or a plain background without one,
+// with the site name and date below it
+func drawSocialCard(content socialCardContent, regular *opentype.Font, bold *opentype.Font) image.Image {
+	canvas := image.NewRGBA(image.Rect(0, 0, socialCardWidth, socialCardHeight))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(socialCardBackground), image.Point{}, draw.Src)
+	if content.Hero != nil {
+		draw.CatmullRom.Scale(canvas, canvas.Bounds(), content.Hero, coverCrop(content.Hero.Bounds(), canvas.Bounds()), draw.Src, nil)
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(socialCardShade), image.Point{}, draw.Over)
+	}
+	draw.Draw(canvas, image.Rect(0, 0, socialCardWidth, 12), image.NewUniform(socialCardAccent), image.Point{}, draw.Src)
+
+	titleFace := cardFace(bold, 68)
+	detailFace := cardFace(regular, 34)
+	defer titleFace.Close()
+	defer detailFace.Close()
+
+	lines := wrapText(titleFace, content.Title, socialCardWidth-2*socialCardMargin, 4)
+	lineHeight := titleFace.Metrics().Height.Ceil() + 8
+	drawer := font.Drawer{Dst: canvas, Src: image.White, Face: titleFace}
+	for i, line := range lines {
+		drawer.Dot = fixed.P(socialCardMargin, socialCardMargin+titleFace.Metrics().Ascent.Ceil()+i*lineHeight)
+		drawer.DrawString(line)
+	}
+
+	details := make([]string, 0, 2)
+	for _, detail := range []string{content.Site, content.Date} {
+		if detail != "" {
+			details = append(details, detail)
+		}
+	}
+	drawer = font.Drawer{Dst: canvas, Src: image.NewUniform(socialCardMuted), Face: detailFace}
+	drawer.Dot = fixed.P(socialCardMargin, socialCardHeight-socialCardMargin)
+	drawer.DrawString(strings.Join(details, " · "))
+
+	return canvas
+}
+
+// coverCrop returns the largest part of src, centered, with the aspect ratio of dst
+func coverCrop(src image.Rectangle, dst image.Rectangle) image.Rectangle {
+	width, height := src.Dx(), src.Dy()
+	if width*dst.Dy() > height*dst.Dx() {
+		width = height * dst.Dx() / dst.Dy()
+	} else {
+		height = width * dst.Dy() / dst.Dx()
+	}
+	origin := src.Min.Add(image.Pt((src.Dx()-width)/2, (src.Dy()-height)/2))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(width, height))}
+}
+
+// wrapText splits text into lines that fit width, ending the last line with an ellipsis
+// when there are more than maxLines
+func wrapText(face font.Face, text string, width int, maxLines int) []string {
+	lines := make([]string, 0)
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := strings.TrimSpace(line + " " + word)
+		if line != "" && font.MeasureString(face, candidate).Ceil() > width {
+			lines = append(lines, line)
+			line = word
+		} else {
+			line = candidate
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := lines[maxLines-1]
+		for last != "" && font.MeasureString(face, last+"…").Ceil() > width {
+			if i := strings.LastIndex(last, " "); i >= 0 {
+				last = last[:i]
+			} else {
+				last = ""
+			}
+		}
+		lines[maxLines-1] = last + "…"
+	}
+	return lines
+}
+
+// loadCardFont parses a font file from the theme's static files, or the fallback font when
+// no file is configured
+func loadCardFont(staticFs afero.Fs, filename string, fallback []byte) (*opentype.Font, error) {
+	data := fallback
+	if filename != "" {
+		var err error
+		if data, err = afero.ReadFile(staticFs, path.Join("/", filename)); err != nil {
+			return nil, fmt.Errorf("social card font %s: %w", filename, err)
+		}
+	}
+	return opentype.Parse(data)
+}
+
+func cardFace(f *opentype.Font, size float64) font.Face {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		// NewFace only fails for invalid options
+		panic(err)
+	}
+	return face
+}
+
+// loadHeroImage decodes the hero image of an item, downloading it when it is a URL, or
+// else reading it from the root path
+func loadHeroImage(hero string) (image.Image, error) {
+	src := s3URL(hero)
+
+	var reader io.Reader
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s returned status %d", src, resp.StatusCode)
+		}
+		reader = io.LimitReader(resp.Body, socialCardHeroLimit)
+	} else {
+		file, err := Vfs.Open(path.Join(viper.GetString("path"), src))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	img, _, err := image.Decode(reader)
+	return img, err
+}
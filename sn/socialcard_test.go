@@ -0,0 +1,122 @@
+package sn
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+)
+
+func TestSocialCardHandler(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md": "---\ntitle: One\ndate: 2024-01-01\n---\n\nFirst post.",
+		"/blog/two.md": "---\ntitle: Two\ndate: 2024-01-02\nhero: /hero.png\n---\n\nSecond post.",
+	})
+	hero := image.NewRGBA(image.Rect(0, 0, 40, 10))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 10; y++ {
+			hero.Set(x, y, color.RGBA{0xff, 0, 0, 0xff})
+		}
+	}
+	var heroPNG bytes.Buffer
+	png.Encode(&heroPNG, hero)
+	afero.WriteFile(Vfs, "/hero.png", heroPNG.Bytes(), 0644)
+	DBLoadRepo("blog")
+
+	viper.Set("rooturl", "https://example.com/")
+	viper.Set("title", "Sn")
+
+	if url := SocialCardURL(Item{Repo: "blog", Slug: "one"}); url != "" {
+		t.Errorf("Expected no card URL without an ogimage route, got %q", url)
+	}
+
+	viper.Set("routes.og.path", "/og/{repo}/{slug:.+}.png")
+	viper.Set("routes.og.handler", "ogimage")
+	if url := SocialCardURL(Item{Repo: "blog", Slug: "2024/a b"}); url != "https://example.com/og/blog/2024/a%20b.png" {
+		t.Errorf("SocialCardURL() = %q", url)
+	}
+
+	router := mux.NewRouter()
+	setupRoutes(router)
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/og/blog/one.png", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Expected a png, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	card, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("Invalid png: %v", err)
+	}
+	if card.Bounds() != image.Rect(0, 0, socialCardWidth, socialCardHeight) {
+		t.Errorf("Card size = %v", card.Bounds())
+	}
+
+	if notModified := get("/og/blog/one.png", http.Header{"If-None-Match": {rec.Header().Get("ETag")}}); notModified.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", notModified.Code)
+	}
+
+	rec = get("/og/blog/two.png", nil)
+	withHero, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("Invalid png: %v", err)
+	}
+	// The hero is cropped to cover the card under a shade, so the corner is a dark red
+	if r, g, _, _ := withHero.At(socialCardWidth-1, socialCardHeight-1).RGBA(); r <= g {
+		t.Errorf("Expected the hero image behind the card, got %v", withHero.At(socialCardWidth-1, socialCardHeight-1))
+	}
+
+	if rec := get("/og/blog/missing.png", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing item, got %d", rec.Code)
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	bold, _ := opentype.Parse(gobold.TTF)
+	face := cardFace(bold, 68)
+	defer face.Close()
+
+	tests := []struct {
+		name     string
+		text     string
+		maxLines int
+		lines    int
+		ellipsis bool
+	}{
+		{"short", "A short title", 4, 1, false},
+		{"wrapped", strings.Repeat("word ", 12), 4, 3, false},
+		{"truncated", strings.Repeat("word ", 60), 4, 4, true},
+		{"empty", "", 4, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := wrapText(face, tt.text, socialCardWidth-2*socialCardMargin, tt.maxLines)
+			if len(lines) != tt.lines {
+				t.Fatalf("wrapText() = %d lines %q, want %d", len(lines), lines, tt.lines)
+			}
+			if tt.lines > 0 && strings.HasSuffix(lines[len(lines)-1], "…") != tt.ellipsis {
+				t.Errorf("wrapText() last line = %q, ellipsis %v", lines[len(lines)-1], tt.ellipsis)
+			}
+		})
+	}
+}
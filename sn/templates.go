@@ -1,64 +1,371 @@
 package sn
 
 import (
+	"bytes"
+	"cmp"
+	"encoding/json"
 	"fmt"
+	"html"
+	htmltemplate "html/template"
 	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
 	"path"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/aymerick/raymond"
+	"github.com/gorilla/mux"
 	"github.com/ringmaster/Sn/sn/util"
 	"github.com/spf13/afero"
 	"github.com/spf13/viper"
 )
 
+// GetTemplateFilesFromConfig returns the paths of the templates listed at configPath.  They
+// are inside of TemplateDir, and are read through the theme when the site does not have them.
 func GetTemplateFilesFromConfig(configPath string) []string {
 	var templates []string
+	templateDir := TemplateDir()
 	templateList := viper.GetStringSlice(configPath)
 	for _, template := range templateList {
-		templates = append(templates, path.Join(ConfigPath("template_dir", MustExist()), template))
+		templates = append(templates, path.Join(templateDir, template))
 	}
 	return templates
 }
 
-func RenderTemplateFiles(filenames []string, context map[string]interface{}) (string, error) {
-	concat := ""
-	for _, filename := range filenames {
-		file, err := afero.ReadFile(Vfs, filename)
+// ItemTemplateFiles applies the "template" and "layout" frontmatter of a single item
+// to the template files of a route.  The template replaces every route template but
+// the last one, and the layout replaces the last one.  A route with a single template
+// has no layout, so a layout from frontmatter is appended after it.
+func ItemTemplateFiles(templateFiles []string, item Item) ([]string, error) {
+	templateName, hasTemplate := item.Frontmatter["template"]
+	layoutName, hasLayout := item.Frontmatter["layout"]
+	if !hasTemplate && !hasLayout {
+		return templateFiles, nil
+	}
+
+	templateDir := TemplateDir()
+
+	body := templateFiles
+	layout := ""
+	if len(templateFiles) > 1 {
+		body = templateFiles[:len(templateFiles)-1]
+		layout = templateFiles[len(templateFiles)-1]
+	}
+
+	if hasTemplate {
+		templateFile, err := templateFileInDir(templateDir, templateName)
+		if err != nil {
+			return templateFiles, err
+		}
+		body = []string{templateFile}
+	}
+	if hasLayout {
+		layoutFile, err := templateFileInDir(templateDir, layoutName)
 		if err != nil {
-			return string(file), err
+			return templateFiles, err
 		}
-		concat = concat + string(file)
+		layout = layoutFile
+	}
+
+	result := append([]string{}, body...)
+	if layout != "" {
+		result = append(result, layout)
+	}
+	return result, nil
+}
+
+// templateFileInDir returns the path of a named template, ensuring it is a file inside of
+// templateDir or the theme
+func templateFileInDir(templateDir string, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("template %q is outside of the template directory", name)
+	}
+	filename := path.Join(templateDir, filepath.ToSlash(name))
+	info, err := ThemeFs(themeTemplates, templateDir).Stat(path.Join("/", filepath.ToSlash(name)))
+	if err != nil {
+		return "", fmt.Errorf("template %q does not exist in %s", name, templateDir)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("template %q is a directory", name)
+	}
+	return filename, nil
+}
+
+// pageDepsData is the private data of a raymond render that holds the dependencies of the
+// page being rendered, which the query helper adds the repos it reads to
+const pageDepsData = "_pagedeps"
+
+// RenderTemplateFiles renders the template files in order with one engine, chosen by their
+// extension: html/template for .gohtml files and raymond for the rest
+func RenderTemplateFiles(filenames []string, context map[string]interface{}) (string, error) {
+	return renderTemplateFiles(filenames, context, nil)
+}
+
+// renderTemplateFiles renders the template files, adding the repos that their query helpers
+// read to deps
+func renderTemplateFiles(filenames []string, context map[string]interface{}, deps map[string]bool) (string, error) {
+	tpl, err := compileTemplateFiles(filenames)
+	if err != nil {
+		return "", err
 	}
 
 	context["now"] = time.Now()
-	var result string
-	result, err := raymond.Render(concat, context)
 
-	return result, err
+	return tpl.execWithDeps(context, deps)
+}
+
+// compiledTemplate is a parsed list of template files, with the state it was parsed from
+type compiledTemplate struct {
+	template   *raymond.Template
+	goTemplate *htmltemplate.Template
+	filenames  []string
+	partials   *partialSet
+	modTimes   []time.Time
+	queryDeps  map[string]bool // The page dependencies of the query calls of a Go template
+}
+
+// Exec renders the template with the given context
+func (c *compiledTemplate) Exec(context interface{}) (string, error) {
+	return c.execWithDeps(context, nil)
+}
+
+// execWithDeps renders the template, adding the repos that it queries to deps.  A raymond
+// template records the queries it runs.  The functions of a Go template are bound when it is
+// parsed, so it adds the repos of every query call it contains.
+func (c *compiledTemplate) execWithDeps(context interface{}, deps map[string]bool) (string, error) {
+	if c.goTemplate != nil {
+		if deps != nil {
+			maps.Copy(deps, c.queryDeps)
+		}
+		return execGoTemplate(c.goTemplate, c.filenames, context)
+	}
+	data := raymond.NewDataFrame()
+	if deps != nil {
+		data.Set(pageDepsData, deps)
+	}
+	return c.template.ExecWith(context, data)
+}
+
+// templateCache holds parsed templates by their list of template files
+var (
+	templateCache     = make(map[string]*compiledTemplate)
+	templateCacheLock sync.RWMutex
+)
+
+// compileTemplateFiles returns the parsed concatenation of the template files, reusing the
+// cached template until one of the files or the partials change
+func compileTemplateFiles(filenames []string) (*compiledTemplate, error) {
+	key := strings.Join(filenames, "\n")
+	set := partials.Load()
+
+	templateFiles := newTemplateResolver()
+	modTimes := make([]time.Time, len(filenames))
+	for i, filename := range filenames {
+		fs, name := templateFiles.resolve(filename)
+		info, err := fs.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	templateCacheLock.RLock()
+	cached := templateCache[key]
+	templateCacheLock.RUnlock()
+	if cached != nil && cached.partials == set && slices.EqualFunc(cached.modTimes, modTimes, time.Time.Equal) {
+		return cached, nil
+	}
+
+	sources := make([]string, len(filenames))
+	goTemplates := 0
+	for i, filename := range filenames {
+		fs, name := templateFiles.resolve(filename)
+		file, err := afero.ReadFile(fs, name)
+		if err != nil {
+			return nil, err
+		}
+		sources[i] = string(file)
+		if isGoTemplate(filename) {
+			goTemplates++
+		}
+	}
+
+	compiled := &compiledTemplate{filenames: filenames, partials: set, modTimes: modTimes}
+	switch goTemplates {
+	case 0:
+		tpl, err := raymond.Parse(strings.Join(sources, ""))
+		if err != nil {
+			return nil, err
+		}
+		if set != nil {
+			for name, partial := range set.templates {
+				tpl.RegisterPartialTemplate(name, partial)
+			}
+		}
+		compiled.template = tpl
+	case len(filenames):
+		tpl, err := parseGoTemplateFiles(filenames, sources, set)
+		if err != nil {
+			return nil, err
+		}
+		compiled.goTemplate = tpl
+		compiled.queryDeps = goTemplateQueryDeps(tpl)
+	default:
+		return nil, fmt.Errorf("templates %v mix %s and raymond templates", filenames, goTemplateExt)
+	}
+
+	templateCacheLock.Lock()
+	templateCache[key] = compiled
+	templateCacheLock.Unlock()
+
+	return compiled, nil
+}
+
+// templateResolver finds template files in the theme
+type templateResolver struct {
+	templateDir string
+	themeFs     afero.Fs
 }
 
+func newTemplateResolver() templateResolver {
+	templateDir := TemplateDir()
+	return templateResolver{templateDir: templateDir, themeFs: ThemeFs(themeTemplates, templateDir)}
+}
+
+// resolve returns the filesystem and path to read a template file from.  Files inside of
+// the template directory are read through the theme, and others directly from the Vfs.
+func (t templateResolver) resolve(filename string) (afero.Fs, string) {
+	if name, ok := strings.CutPrefix(filename, strings.TrimSuffix(t.templateDir, "/")+"/"); ok {
+		return t.themeFs, path.Join("/", name)
+	}
+	return Vfs, filename
+}
+
+// clearTemplateCache discards every parsed template
+func clearTemplateCache() {
+	templateCacheLock.Lock()
+	defer templateCacheLock.Unlock()
+	templateCache = make(map[string]*compiledTemplate)
+}
+
+// partialSet is a complete set of parsed partials, swapped in as a whole on reload
+type partialSet struct {
+	templates  map[string]*raymond.Template
+	goPartials map[string]string // Sources of the .gohtml partials, parsed into each Go template
+	errors     map[string]error  // Parse errors by template filename
+	loadedAt   time.Time
+}
+
+// partials holds the current partialSet.  Partials are registered on each parsed template
+// rather than globally with raymond, which cannot replace a registered partial.
+var partials atomic.Pointer[partialSet]
+
+// RegisterPartials parses every file in template_dir and the theme as a partial and
+// atomically replaces the current partials.  A file that fails to parse keeps its previous
+// version, so a bad edit is reported without taking the site down.
 func RegisterPartials() {
 	slog.Info("Registering Template Partials")
-	templatepath := ConfigPath("template_dir", MustExist())
-	files, err := afero.ReadDir(Vfs, templatepath)
+	if !themeExists() {
+		slog.Error("Theme not found, using the default theme", "theme", viper.GetString("theme"))
+	}
+	templatepath := TemplateDir()
+	themeFs := ThemeFs(themeTemplates, templatepath)
+	files, err := afero.ReadDir(themeFs, "/")
 	if err != nil {
 		panic(err)
 	}
 
+	previous := partials.Load()
+	set := &partialSet{
+		templates:  make(map[string]*raymond.Template),
+		goPartials: make(map[string]string),
+		errors:     make(map[string]error),
+		loadedAt:   time.Now(),
+	}
+
 	for _, file := range files {
 		if !file.IsDir() {
-			template, err := afero.ReadFile(Vfs, path.Join(templatepath, file.Name()))
+			filename := path.Join(templatepath, file.Name())
+			partialname := regexp.MustCompile(`\.`).Split(file.Name(), 2)[0]
+
+			source, err := afero.ReadFile(themeFs, path.Join("/", file.Name()))
+			if err == nil && isGoTemplate(filename) {
+				if err = parseGoPartial(partialname, string(source)); err == nil {
+					set.goPartials[partialname] = string(source)
+					continue
+				}
+			}
+			var template *raymond.Template
+			if err == nil {
+				template, err = raymond.Parse(string(source))
+			}
 			if err != nil {
-				panic(err)
+				slog.Error("Error parsing template", "file", filename, "error", err)
+				set.errors[filename] = err
+				if previous != nil && previous.templates[partialname] != nil {
+					set.templates[partialname] = previous.templates[partialname]
+				}
+				if previous != nil && previous.goPartials[partialname] != "" {
+					set.goPartials[partialname] = previous.goPartials[partialname]
+				}
+				continue
 			}
-			partialname := regexp.MustCompile(`\.`).Split(file.Name(), 2)[0]
-			raymond.RegisterPartial(partialname, string(template))
+			set.templates[partialname] = template
 		}
 	}
+
+	partials.Store(set)
+	ClearPageCache()
+}
+
+// TemplateErrors returns the parse errors from the last time partials were registered
+func TemplateErrors() map[string]error {
+	set := partials.Load()
+	if set == nil {
+		return nil
+	}
+	return set.errors
+}
+
+// StartWatchingTemplates re-registers the partials whenever a file in template_dir or the
+// theme changes
+func StartWatchingTemplates() {
+	r := regexp.MustCompile(".*")
+	prevStates, err := GetFileStates(ThemeFs(themeTemplates, TemplateDir()), "/", r)
+	if err != nil {
+		slog.Error("Error watching templates", "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+
+	go func() {
+		for range ticker.C {
+			configLock.RLock()
+			currStates, err := GetFileStates(ThemeFs(themeTemplates, TemplateDir()), "/", r)
+			if err != nil {
+				configLock.RUnlock()
+				slog.Error("Error watching templates", "error", err)
+				continue
+			}
+			changedFiles := CompareFileStates(prevStates, currStates)
+			if len(changedFiles) > 0 {
+				slog.Info("Templates changed", "files", changedFiles)
+				RegisterPartials()
+			}
+			configLock.RUnlock()
+			prevStates = currStates
+		}
+	}()
 }
 
 func RegisterTemplateHelpers() {
@@ -78,18 +385,7 @@ func RegisterTemplateHelpers() {
 		return fmt.Sprintf(`<pre style="">%s</pre>`, str)
 	})
 	raymond.RegisterHelper("s3", func(src string, options *raymond.Options) string {
-		regex := regexp.MustCompile(`s3://(?P<bucket>[^/]+)/(?P<filename>.+)`)
-
-		match := regex.FindStringSubmatch(src)
-		if match != nil {
-			bucket := match[1]
-			filename := match[2]
-			cdnURL := viper.GetString(fmt.Sprintf("s3.%s.cdn", bucket))
-			newSrc := cdnURL + filename
-			return newSrc
-		}
-
-		return src
+		return s3URL(src)
 	})
 	raymond.RegisterHelper("d", func(options *raymond.Options) string {
 		return fmt.Sprintf(`<pre style="">%s</pre>`, options.Ctx())
@@ -98,79 +394,20 @@ func RegisterTemplateHelpers() {
 		return t.Format(format)
 	})
 	raymond.RegisterHelper("more", func(html string, pcount int, options *raymond.Options) string {
-		more := ""
-		re := regexp.MustCompile(`<!--\s*more\s*-->`)
-		split := re.Split(html, -1)
-		if len(split) > 1 {
-			return split[0] + options.Fn()
-		}
-
-		doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
-		if err != nil {
-			return "<p>NewDocument() error</p>" + html
-		}
-
-		doc.Find("p").EachWithBreak(func(i int, sel *goquery.Selection) bool {
-			tp, err := goquery.OuterHtml(sel)
-			if err == nil {
-				if sel.Text() != "" {
-					more = more + tp
-					pcount--
-				}
-			}
-			if pcount <= 0 {
-				return false
-			}
-			return true
-		})
-
-		return more + options.Fn()
+		return moreHTML(html, pcount) + options.Fn()
 	})
 	raymond.RegisterHelper("summary", func(html string, options *raymond.Options) string {
-		summary := ""
-
-		doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
-		if err != nil {
-			return "<p>NewDocument() error</p>" + html
-		}
-
-		doc.Find("p").EachWithBreak(func(i int, sel *goquery.Selection) bool {
-			if sel.Text() == "" {
-				return true
-			}
-			summary = sel.Text()
-			return false
-		})
-
-		return summary
+		return summaryText(html)
 	})
 	raymond.RegisterHelper("head", func(html string, count int, options *raymond.Options) string {
-		doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
-		if err != nil {
-			return "<p>NewDocument() error</p>" + html
-		}
-
-		result := ""
-
-		doc.Find("p").EachWithBreak(func(i int, sel *goquery.Selection) bool {
-			if err == nil {
-				if sel.Text() != "" {
-					result = sel.Text()
-					return false
-				}
-			}
-			return true
-		})
-
-		return result[0 : MinOf(count, len(result))-1]
+		return headText(html, count)
 	})
 	raymond.RegisterHelper("paginate", func(pagelist ItemResult, distance int, options *raymond.Options) raymond.SafeString {
 		pagelist.Page = MaxOf(1, pagelist.Page)
 		min := MaxOf(pagelist.Page-distance, 1)
 		max := MinOf(pagelist.Page+distance, pagelist.Pages)
 		paginator := fmt.Sprintf("<!-- Paginator  min: %d  max: %d  pages: %d  page: %d  distance: %d -->", min, max, pagelist.Pages, pagelist.Page, distance)
-		for pg := min; pg <= max; pg++ {
-			ctx := map[string]interface{}{"page": pg, "active": pg == pagelist.Page}
+		for _, ctx := range paginatePages(pagelist, distance) {
 			paginator += options.FnWith(ctx)
 		}
 		return raymond.SafeString(paginator)
@@ -221,4 +458,363 @@ func RegisterTemplateHelpers() {
 	raymond.RegisterHelper("permalink", func(item interface{}, options *raymond.Options) string {
 		return util.GetItemURL(item)
 	})
+	// ogimage returns the URL of the generated social card image of an item, or "" without an ogimage route
+	// Usage: {{#if (ogimage this)}}<meta property="og:image" content="{{ogimage this}}">{{/if}}
+	raymond.RegisterHelper("ogimage", func(item Item, options *raymond.Options) string {
+		return SocialCardURL(item)
+	})
+	// revisions iterates the git history of an item, newest first
+	// Usage: {{#revisions this}}{{author}} {{dateformat date "2006-01-02"}} {{message}}{{/revisions}}
+	raymond.RegisterHelper("revisions", func(item Item, options *raymond.Options) raymond.SafeString {
+		revisions := itemRevisions(item)
+		if len(revisions) == 0 {
+			return raymond.SafeString(options.Inverse())
+		}
+		result := ""
+		for _, revision := range revisions {
+			result += options.FnWith(revision)
+		}
+		return raymond.SafeString(result)
+	})
+	// markdown renders a markdown string as HTML
+	// Usage: {{markdown frontmatter.description}}
+	raymond.RegisterHelper("markdown", func(source string) raymond.SafeString {
+		return raymond.SafeString(renderMarkdown(source))
+	})
+	// truncatewords shortens text, or the text of HTML, to a number of words
+	// Usage: {{truncatewords html 30}}
+	raymond.RegisterHelper("truncatewords", func(text string, count int) string {
+		return truncateWords(text, count)
+	})
+	// tagurl, authorurl and pageurl build URLs from the route configuration
+	// Usage: {{tagurl this}} in {{#each categories}}, {{authorurl this}} in {{#each authors}},
+	// {{pageurl @root.url page}} in {{#paginate}}
+	raymond.RegisterHelper("tagurl", func(tag string) string {
+		return util.GetTagURL(tag)
+	})
+	raymond.RegisterHelper("authorurl", func(author string) string {
+		return util.GetAuthorURL(author)
+	})
+	raymond.RegisterHelper("pageurl", func(current any, page int) string {
+		switch u := current.(type) {
+		case *url.URL:
+			return pageURL(u, page)
+		case url.URL:
+			return pageURL(&u, page)
+		}
+		return ""
+	})
+	// feedlinks emits a <link rel="alternate"> for each feed of the current listing
+	// Usage: {{feedlinks pathvars}} in the head of the layout
+	raymond.RegisterHelper("feedlinks", func(pathvars any) raymond.SafeString {
+		return raymond.SafeString(feedLinks(stringMap(pathvars)))
+	})
+	// timeago describes a time relative to now, like "3 days ago"
+	// Usage: {{timeago date}}
+	raymond.RegisterHelper("timeago", func(t time.Time) string {
+		return relativeTime(t, time.Now())
+	})
+	// eq, gt, and, or are for use as subexpressions in conditionals
+	// Usage: {{#if (and (eq repo "posts") (gt posts.Pages 1))}}
+	raymond.RegisterHelper("eq", func(a any, b any) bool {
+		return fmt.Sprint(a) == fmt.Sprint(b)
+	})
+	raymond.RegisterHelper("gt", func(a any, b any) bool {
+		return compareValues(a, b) > 0
+	})
+	raymond.RegisterHelper("and", func(a any, b any) bool {
+		return raymond.IsTrue(a) && raymond.IsTrue(b)
+	})
+	raymond.RegisterHelper("or", func(a any, b any) bool {
+		return raymond.IsTrue(a) || raymond.IsTrue(b)
+	})
+	// json encodes a value as JSON, for use in script tags and data attributes
+	// Usage: <script>const post = {{json this}};</script>
+	raymond.RegisterHelper("json", func(value any) raymond.SafeString {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			slog.Error("Error encoding JSON in template", "error", err)
+			return "null"
+		}
+		return raymond.SafeString(encoded)
+	})
+	// query runs an item query from the template, with the same parameters as a route's out query
+	// Usage: {{#query repo="posts" paginate_count=5}}{{#each items}}{{title}}{{/each}}{{else}}No posts{{/query}}
+	raymond.RegisterHelper("query", func(options *raymond.Options) raymond.SafeString {
+		if deps, ok := options.DataFrame().Get(pageDepsData).(map[string]bool); ok {
+			deps[queryPageDep(options.Hash()["repo"])] = true
+		}
+		result := ItemsFromItemQuery(itemQueryFromHash(options.Hash()))
+		if len(result.Items) == 0 {
+			return raymond.SafeString(options.Inverse())
+		}
+		return raymond.SafeString(options.FnWith(result))
+	})
+}
+
+// s3Source matches an s3://bucket/filename reference to an uploaded file
+var s3Source = regexp.MustCompile(`^s3://(?P<bucket>[^/]+)/(?P<filename>.+)`)
+
+// s3Location returns the bucket and filename of an s3:// reference
+func s3Location(src string) (string, string, bool) {
+	match := s3Source.FindStringSubmatch(src)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+// s3URL returns the CDN URL of an s3:// reference, using the cdn of the bucket's s3 settings,
+// or src unchanged when it is not one
+func s3URL(src string) string {
+	if bucket, filename, ok := s3Location(src); ok {
+		cdnURL := viper.GetString(fmt.Sprintf("s3.%s.cdn", bucket))
+		return cdnURL + filename
+	}
+
+	return src
+}
+
+// itemRevisions returns the git history of an item, newest first
+func itemRevisions(item Item) []Revision {
+	history, ok := GitFileHistory(item.Source)
+	if !ok {
+		return nil
+	}
+	return history.Revisions
+}
+
+// renderMarkdown renders a markdown string from a template as HTML
+func renderMarkdown(source string) string {
+	var buf bytes.Buffer
+	if err := newMarkdown().Convert([]byte(source), &buf); err != nil {
+		slog.Error("Error rendering markdown in template", "error", err)
+		return ""
+	}
+	return buf.String()
+}
+
+// moreHTML returns the HTML before a <!--more--> comment, or else its first pcount paragraphs
+func moreHTML(html string, pcount int) string {
+	more := ""
+	re := regexp.MustCompile(`<!--\s*more\s*-->`)
+	split := re.Split(html, -1)
+	if len(split) > 1 {
+		return split[0]
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return "<p>NewDocument() error</p>" + html
+	}
+
+	doc.Find("p").EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		tp, err := goquery.OuterHtml(sel)
+		if err == nil {
+			if sel.Text() != "" {
+				more = more + tp
+				pcount--
+			}
+		}
+		if pcount <= 0 {
+			return false
+		}
+		return true
+	})
+
+	return more
+}
+
+// summaryText returns the text of the first paragraph of html that has any
+func summaryText(html string) string {
+	summary := ""
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return "<p>NewDocument() error</p>" + html
+	}
+
+	doc.Find("p").EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		if sel.Text() == "" {
+			return true
+		}
+		summary = sel.Text()
+		return false
+	})
+
+	return summary
+}
+
+// headText returns up to count characters of the first paragraph of html that has text
+func headText(html string, count int) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return "<p>NewDocument() error</p>" + html
+	}
+
+	result := ""
+
+	doc.Find("p").EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		if err == nil {
+			if sel.Text() != "" {
+				result = sel.Text()
+				return false
+			}
+		}
+		return true
+	})
+
+	return result[0 : MinOf(count, len(result))-1]
+}
+
+// paginatePages lists the pages within distance of the current page of a listing
+func paginatePages(pagelist ItemResult, distance int) []map[string]interface{} {
+	pagelist.Page = MaxOf(1, pagelist.Page)
+	min := MaxOf(pagelist.Page-distance, 1)
+	max := MinOf(pagelist.Page+distance, pagelist.Pages)
+	pages := make([]map[string]interface{}, 0, MaxOf(max-min+1, 0))
+	for pg := min; pg <= max; pg++ {
+		pages = append(pages, map[string]interface{}{"page": pg, "active": pg == pagelist.Page})
+	}
+	return pages
+}
+
+// htmlTag matches an HTML tag, replaced with a space so words in adjacent elements stay apart
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// truncateWords returns the first count words of text, which may be HTML
+func truncateWords(text string, count int) string {
+	text = html.UnescapeString(htmlTag.ReplaceAllString(text, " "))
+	words := strings.Fields(text)
+	if len(words) <= count {
+		return strings.Join(words, " ")
+	}
+	return strings.Join(words[:count], " ") + "…"
+}
+
+// stringMap converts a map passed to a helper, such as the path variables, to strings
+func stringMap(value any) map[string]string {
+	switch m := value.(type) {
+	case map[string]string:
+		return m
+	case map[string]interface{}:
+		converted := make(map[string]string, len(m))
+		for k, v := range m {
+			converted[k] = fmt.Sprint(v)
+		}
+		return converted
+	}
+	return map[string]string{}
+}
+
+// relativeTime describes t relative to now in the largest whole unit
+func relativeTime(t time.Time, now time.Time) string {
+	diff := now.Sub(t)
+	suffix := "ago"
+	if diff < 0 {
+		diff = -diff
+		suffix = "from now"
+	}
+
+	units := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"week", 7 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	for _, unit := range units {
+		if n := int(diff / unit.duration); n >= 1 {
+			if n > 1 {
+				return fmt.Sprintf("%d %ss %s", n, unit.name, suffix)
+			}
+			return fmt.Sprintf("1 %s %s", unit.name, suffix)
+		}
+	}
+	return "just now"
+}
+
+// compareValues compares two values numerically when both are numbers, otherwise as strings
+func compareValues(a any, b any) int {
+	aFloat, aErr := strconv.ParseFloat(fmt.Sprint(a), 64)
+	bFloat, bErr := strconv.ParseFloat(fmt.Sprint(b), 64)
+	if aErr == nil && bErr == nil {
+		return cmp.Compare(aFloat, bFloat)
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// pageURL returns the URL of another page of the listing at current, using the path
+// variable named by the route's paginate_name when there is one, else the query string
+func pageURL(current *url.URL, page int) string {
+	if current == nil {
+		return ""
+	}
+	target := *current
+	paginateName := "page"
+
+	var match mux.RouteMatch
+	if r := router.Load(); r != nil && r.Match(&http.Request{Method: http.MethodGet, URL: current}, &match) && match.Route != nil {
+		for _, query := range routeOutQueries(match.Route.GetName()) {
+			if name, ok := query["paginate_name"].(string); ok {
+				paginateName = name
+				break
+			}
+		}
+		if _, ok := match.Vars[paginateName]; ok {
+			pairs := make([]string, 0, len(match.Vars)*2)
+			for name, value := range match.Vars {
+				if name == paginateName {
+					value = strconv.Itoa(page)
+				}
+				pairs = append(pairs, name, value)
+			}
+			if built, err := match.Route.URLPath(pairs...); err == nil {
+				target.Path = built.Path
+				return target.String()
+			}
+		}
+	}
+
+	values := target.Query()
+	values.Set(paginateName, strconv.Itoa(page))
+	target.RawQuery = values.Encode()
+	return target.String()
+}
+
+// itemQueryFromHash builds an ItemQuery from the hash arguments of the query helper
+func itemQueryFromHash(hash map[string]interface{}) ItemQuery {
+	qry := ItemQuery{Page: 1, PerPage: 5, Frontmatter: make(map[string]string)}
+	for key, value := range hash {
+		str := fmt.Sprint(value)
+		switch key {
+		case "repo":
+			qry.Repo = &str
+		case "slug":
+			qry.Slug = &str
+		case "tag", "category":
+			qry.Category = &str
+		case "author":
+			qry.Author = &str
+		case "search":
+			qry.Search = &str
+		case "order_by":
+			qry.OrderBy = &str
+		case "paginate_count", "limit":
+			if count, err := strconv.Atoi(str); err == nil && count > 0 {
+				qry.PerPage = count
+			}
+		case "page":
+			if page, err := strconv.Atoi(str); err == nil && page > 0 {
+				qry.Page = page
+			}
+		case "fields":
+			qry.Fields = strings.Split(str, ",")
+		}
+	}
+	return qry
 }
@@ -0,0 +1,364 @@
+package sn
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aymerick/raymond"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// TestItemTemplateFiles verifies frontmatter template and layout overrides
+func TestItemTemplateFiles(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	origVfs := Vfs
+	Vfs = memFs
+	defer func() { Vfs = origVfs }()
+
+	viper.Reset()
+	viper.Set("template_dir", "/tpl")
+	for _, name := range []string{"post.html.hb", "layout.html.hb", "landing.html.hb", "bare.html.hb"} {
+		afero.WriteFile(memFs, "/tpl/"+name, []byte(name), 0644)
+	}
+	memFs.MkdirAll("/tpl/partials", 0755)
+
+	routeFiles := []string{"/tpl/post.html.hb", "/tpl/layout.html.hb"}
+
+	tests := []struct {
+		name        string
+		routeFiles  []string
+		frontmatter map[string]string
+		expected    []string
+		wantErr     bool
+	}{
+		{
+			name:        "no overrides",
+			routeFiles:  routeFiles,
+			frontmatter: map[string]string{},
+			expected:    routeFiles,
+		},
+		{
+			name:        "template override keeps layout",
+			routeFiles:  routeFiles,
+			frontmatter: map[string]string{"template": "landing.html.hb"},
+			expected:    []string{"/tpl/landing.html.hb", "/tpl/layout.html.hb"},
+		},
+		{
+			name:        "layout override keeps template",
+			routeFiles:  routeFiles,
+			frontmatter: map[string]string{"layout": "bare.html.hb"},
+			expected:    []string{"/tpl/post.html.hb", "/tpl/bare.html.hb"},
+		},
+		{
+			name:        "both overrides",
+			routeFiles:  routeFiles,
+			frontmatter: map[string]string{"template": "landing.html.hb", "layout": "bare.html.hb"},
+			expected:    []string{"/tpl/landing.html.hb", "/tpl/bare.html.hb"},
+		},
+		{
+			name:        "layout appended to single template route",
+			routeFiles:  []string{"/tpl/post.html.hb"},
+			frontmatter: map[string]string{"layout": "bare.html.hb"},
+			expected:    []string{"/tpl/post.html.hb", "/tpl/bare.html.hb"},
+		},
+		{
+			name:        "missing template",
+			routeFiles:  routeFiles,
+			frontmatter: map[string]string{"template": "nope.html.hb"},
+			expected:    routeFiles,
+			wantErr:     true,
+		},
+		{
+			name:        "template outside of template_dir",
+			routeFiles:  routeFiles,
+			frontmatter: map[string]string{"layout": "../sn.yaml"},
+			expected:    routeFiles,
+			wantErr:     true,
+		},
+		{
+			name:        "template is a directory",
+			routeFiles:  routeFiles,
+			frontmatter: map[string]string{"template": "partials"},
+			expected:    routeFiles,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ItemTemplateFiles(tt.routeFiles, Item{Frontmatter: tt.frontmatter})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ItemTemplateFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("ItemTemplateFiles() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+// TestRegisterPartials_Reload verifies partials can be replaced, and that a partial
+// that fails to parse keeps its previous version
+func TestRegisterPartials_Reload(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	origVfs := Vfs
+	Vfs = memFs
+	defer func() { Vfs = origVfs }()
+
+	viper.Reset()
+	viper.Set("template_dir", "/tpl")
+	afero.WriteFile(memFs, "/tpl/page.html.hb", []byte("[{{> header}}]"), 0644)
+	afero.WriteFile(memFs, "/tpl/header.html.hb", []byte("first {{title}}"), 0644)
+
+	render := func() string {
+		t.Helper()
+		result, err := RenderTemplateFiles([]string{"/tpl/page.html.hb"}, map[string]interface{}{"title": "Sn"})
+		if err != nil {
+			t.Fatalf("RenderTemplateFiles() error = %v", err)
+		}
+		return result
+	}
+
+	RegisterPartials()
+	if result := render(); result != "[first Sn]" {
+		t.Errorf("Expected first partial, got %q", result)
+	}
+
+	afero.WriteFile(memFs, "/tpl/header.html.hb", []byte("second {{title}}"), 0644)
+	RegisterPartials()
+	if result := render(); result != "[second Sn]" {
+		t.Errorf("Expected reloaded partial, got %q", result)
+	}
+	if len(TemplateErrors()) != 0 {
+		t.Errorf("Expected no template errors, got %v", TemplateErrors())
+	}
+
+	afero.WriteFile(memFs, "/tpl/header.html.hb", []byte("broken {{#if title}}"), 0644)
+	RegisterPartials()
+	if result := render(); result != "[second Sn]" {
+		t.Errorf("Expected previous partial to be kept, got %q", result)
+	}
+	if _, ok := TemplateErrors()["/tpl/header.html.hb"]; !ok {
+		t.Errorf("Expected a parse error for header.html.hb, got %v", TemplateErrors())
+	}
+}
+
+// TestCompileTemplateFiles_Cache verifies parsed templates are reused until a file changes
+func TestCompileTemplateFiles_Cache(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	origVfs := Vfs
+	Vfs = memFs
+	defer func() { Vfs = origVfs }()
+	clearTemplateCache()
+
+	files := []string{"/tpl/a.html.hb", "/tpl/b.html.hb"}
+	afero.WriteFile(memFs, files[0], []byte("a{{x}}"), 0644)
+	afero.WriteFile(memFs, files[1], []byte("b"), 0644)
+
+	first, err := compileTemplateFiles(files)
+	if err != nil {
+		t.Fatalf("compileTemplateFiles() error = %v", err)
+	}
+	second, _ := compileTemplateFiles(files)
+	if first != second {
+		t.Error("Expected the cached template to be reused")
+	}
+
+	afero.WriteFile(memFs, files[1], []byte("c"), 0644)
+	memFs.Chtimes(files[1], time.Now(), time.Now().Add(time.Second))
+	third, _ := compileTemplateFiles(files)
+	if third == first {
+		t.Error("Expected a changed file to invalidate the cached template")
+	}
+	if result, _ := third.Exec(map[string]string{"x": "1"}); result != "a1c" {
+		t.Errorf("Expected reparsed template output a1c, got %q", result)
+	}
+
+	if _, err := compileTemplateFiles([]string{"/tpl/missing.html.hb"}); err == nil {
+		t.Error("Expected an error for a missing template file")
+	}
+}
+
+var registerHelpersOnce sync.Once
+
+// setupPostsIndexBenchmark loads the embedded default theme's templates, for a site that
+// overrides none of them, and returns a context for its posts index route
+func setupPostsIndexBenchmark(b *testing.B) map[string]interface{} {
+	origVfs := Vfs
+	Vfs = afero.NewMemMapFs()
+	b.Cleanup(func() { Vfs = origVfs })
+
+	viper.Reset()
+	viper.Set("path", "/")
+	viper.Set("title", "Sn")
+	registerHelpersOnce.Do(RegisterTemplateHelpers)
+	RegisterPartials()
+
+	items := make([]Item, 5)
+	for i := range items {
+		items[i] = Item{
+			Title:      fmt.Sprintf("Post %d", i),
+			Slug:       fmt.Sprintf("post-%d", i),
+			Repo:       "posts",
+			Date:       time.Now(),
+			Categories: []string{"go", "sn"},
+			Html:       strings.Repeat("<p>Some words in a paragraph.</p>", 20),
+		}
+	}
+
+	context := map[string]interface{}{
+		"config":   map[string]interface{}{"title": "Sn"},
+		"pathvars": map[string]string{},
+		"posts":    ItemResult{Items: items, Total: 5, Pages: 1, Page: 1},
+	}
+	return context
+}
+
+func BenchmarkRenderTemplateFiles_PostsIndex(b *testing.B) {
+	context := setupPostsIndexBenchmark(b)
+	files := []string{path.Join(TemplateDir(), "posts.html.hb"), path.Join(TemplateDir(), "layout.html.hb")}
+	if _, err := RenderTemplateFiles(files, context); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			RenderTemplateFiles(files, context)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			clearTemplateCache()
+			RenderTemplateFiles(files, context)
+		}
+	})
+}
+
+func TestTemplateHelpers(t *testing.T) {
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md": "---\ntitle: One\ntags: [go]\n---\n\nFirst post.",
+		"/blog/two.md": "---\ntitle: Two\ntags: [go]\n---\n\nSecond post.",
+	})
+	DBLoadRepo("blog")
+	registerHelpersOnce.Do(RegisterTemplateHelpers)
+
+	viper.Set("rooturl", "https://example.com/")
+	viper.Set("routes.tags.handler", "posts")
+	viper.Set("routes.tags.path", "/tag/{tag}")
+	viper.Set("routes.tags.out.posts", map[string]interface{}{"repo": "blog", "tag": "{tag}"})
+
+	tests := []struct {
+		name     string
+		template string
+		context  map[string]interface{}
+		expected string
+	}{
+		{"markdown", `{{markdown text}}`, map[string]interface{}{"text": "*hi*"}, "<p><em>hi</em></p>\n"},
+		{"truncatewords", `{{truncatewords text 2}}`, map[string]interface{}{"text": "<p>one two</p><p>three</p>"}, "one two…"},
+		{"truncatewords short", `{{truncatewords text 5}}`, map[string]interface{}{"text": "one two"}, "one two"},
+		{"tagurl", `{{tagurl "go lang"}}`, nil, "https://example.com/tag/go%20lang"},
+		{"authorurl fallback", `{{authorurl "alice"}}`, nil, "https://example.com/author/alice"},
+		{"pageurl", `{{pageurl url 3}}`, map[string]interface{}{"url": &url.URL{Path: "/search", RawQuery: "s=go"}}, "/search?page=3&amp;s=go"},
+		{"eq", `{{#if (eq count "2")}}yes{{else}}no{{/if}}`, map[string]interface{}{"count": 2}, "yes"},
+		{"gt", `{{#if (gt count 10)}}yes{{else}}no{{/if}}`, map[string]interface{}{"count": 9}, "no"},
+		{"and", `{{#if (and a b)}}yes{{else}}no{{/if}}`, map[string]interface{}{"a": true, "b": ""}, "no"},
+		{"or", `{{#if (or a b)}}yes{{else}}no{{/if}}`, map[string]interface{}{"a": true, "b": ""}, "yes"},
+		{"json", `{{json value}}`, map[string]interface{}{"value": map[string]string{"a": "<b>"}}, `{"a":"\u003cb\u003e"}`},
+		{"query", `{{#query repo="blog" tag="go" paginate_count=1}}{{total}}:{{#each items}}{{repo}}{{/each}}{{/query}}`, nil, "2:blog"},
+		{"query empty", `{{#query repo="nope"}}found{{else}}none{{/query}}`, nil, "none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := raymond.Render(tt.template, tt.context)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Render() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		t        time.Time
+		expected string
+	}{
+		{now.Add(-30 * time.Second), "just now"},
+		{now.Add(-1 * time.Minute), "1 minute ago"},
+		{now.Add(-5 * time.Hour), "5 hours ago"},
+		{now.Add(-3 * 24 * time.Hour), "3 days ago"},
+		{now.Add(-400 * 24 * time.Hour), "1 year ago"},
+		{now.Add(2 * time.Hour), "2 hours from now"},
+	}
+	for _, tt := range tests {
+		if result := relativeTime(tt.t, now); result != tt.expected {
+			t.Errorf("relativeTime(%v) = %q, want %q", tt.t, result, tt.expected)
+		}
+	}
+}
+
+// TestRenderTemplateFiles_Engines verifies .gohtml templates render with html/template using
+// the same context, layout and partial semantics as raymond templates
+func TestRenderTemplateFiles_Engines(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	origVfs := Vfs
+	Vfs = memFs
+	defer func() { Vfs = origVfs }()
+	clearTemplateCache()
+	registerHelpersOnce.Do(RegisterTemplateHelpers)
+
+	viper.Reset()
+	viper.Set("template_dir", "/tpl")
+	templates := map[string]string{
+		"page.html.hb":   `{{#define "title"}}{{title}}{{/define}}`,
+		"layout.html.hb": `<h1>{{#block "title"}}Default{{/block}}</h1>{{> footer}}`,
+		"footer.html.hb": `<p>{{dateformat date "2006"}}</p>`,
+		"page.gohtml":    `{{define "title"}}{{.title}}{{end}}`,
+		"other.gohtml":   `{{define "title"}}Other{{end}}`,
+		"layout.gohtml":  `<h1>{{block "title" .}}Default{{end}}</h1>{{template "footer" .}}`,
+		"footer.gohtml":  `<p>{{dateformat .date "2006"}}</p>`,
+	}
+	for name, source := range templates {
+		afero.WriteFile(memFs, "/tpl/"+name, []byte(source), 0644)
+	}
+	RegisterPartials()
+
+	context := func() map[string]interface{} {
+		return map[string]interface{}{"title": "<Sn>", "date": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	}
+
+	tests := []struct {
+		name     string
+		files    []string
+		expected string
+		wantErr  bool
+	}{
+		{"raymond", []string{"/tpl/page.html.hb", "/tpl/layout.html.hb"}, "<h1>&lt;Sn&gt;</h1><p>2024</p>", false},
+		{"gohtml", []string{"/tpl/page.gohtml", "/tpl/layout.gohtml"}, "<h1>&lt;Sn&gt;</h1><p>2024</p>", false},
+		{"gohtml block default", []string{"/tpl/layout.gohtml"}, "<h1>Default</h1><p>2024</p>", false},
+		{"mixed engines", []string{"/tpl/page.gohtml", "/tpl/layout.html.hb"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := RenderTemplateFiles(tt.files, context())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RenderTemplateFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result != tt.expected {
+				t.Errorf("RenderTemplateFiles() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package sn
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+//go:embed all:themes
+var embeddedThemes embed.FS
+
+// defaultTheme is the embedded theme at the end of every theme chain
+const defaultTheme = "default"
+
+// Theme content is split by kind into a directory of each theme
+const (
+	themeTemplates = "template"
+	themeStatic    = "static"
+)
+
+// TemplateDir returns the directory of the site's own templates.  The directory does not
+// need to exist, since the theme supplies every template the site does not override.
+func TemplateDir() string {
+	return ConfigPath("template_dir", WithDefault(path.Join(viper.GetString("path"), themeTemplates)), OptionallyExist())
+}
+
+// ThemeFs returns the files of one kind of theme content as a single read-only filesystem
+// rooted at "/".  A file is taken from the first of: siteDir, the kind's directory of the
+// configured theme, and the embedded default theme.  Directories list the files of all three.
+func ThemeFs(kind string, siteDir string) afero.Fs {
+	layers := []afero.Fs{}
+	if siteDir != "" && DirExistsFs(Vfs, siteDir) {
+		layers = append(layers, afero.NewBasePathFs(Vfs, siteDir))
+	}
+	if themeFs := namedThemeFs(kind); themeFs != nil {
+		layers = append(layers, themeFs)
+	}
+	layers = append(layers, embeddedThemeFs(defaultTheme, kind))
+
+	result := layers[len(layers)-1]
+	for i := len(layers) - 2; i >= 0; i-- {
+		result = afero.NewCopyOnWriteFs(result, layers[i])
+	}
+	return afero.NewReadOnlyFs(result)
+}
+
+// namedThemeFs returns the kind's directory of the theme set by "theme", looked up in
+// theme_dir and then among the embedded themes, or nil if there is none
+func namedThemeFs(kind string) afero.Fs {
+	theme := viper.GetString("theme")
+	if theme == "" || theme == defaultTheme || !fs.ValidPath(theme) || strings.Contains(theme, "/") {
+		return nil
+	}
+
+	themeDir := ConfigPath("theme_dir", WithDefault(path.Join(viper.GetString("path"), "themes")), OptionallyExist())
+	if dir := path.Join(themeDir, theme, kind); DirExistsFs(Vfs, dir) {
+		return afero.NewBasePathFs(Vfs, dir)
+	}
+	if info, err := fs.Stat(embeddedThemes, path.Join("themes", theme, kind)); err == nil && info.IsDir() {
+		return embeddedThemeFs(theme, kind)
+	}
+	return nil
+}
+
+// themeExists reports whether the theme set by "theme" can be found
+func themeExists() bool {
+	theme := viper.GetString("theme")
+	return theme == "" || theme == defaultTheme || namedThemeFs(themeTemplates) != nil || namedThemeFs(themeStatic) != nil
+}
+
+func embeddedThemeFs(theme string, kind string) afero.Fs {
+	return afero.NewBasePathFs(afero.FromIOFS{FS: embeddedThemes}, path.Join("themes", theme, kind))
+}
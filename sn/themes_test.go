@@ -0,0 +1,112 @@
+package sn
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+func setupThemeTest(t *testing.T) {
+	t.Helper()
+	memFs := afero.NewMemMapFs()
+	origVfs := Vfs
+	Vfs = memFs
+	t.Cleanup(func() { Vfs = origVfs })
+
+	viper.Reset()
+	viper.Set("template_dir", "/tpl")
+	viper.Set("theme_dir", "/themes")
+	viper.Set("theme", "plain")
+	files := map[string]string{
+		"/tpl/post.html.hb":                   "site post",
+		"/themes/plain/template/post.html.hb": "theme post",
+		"/themes/plain/template/head.html.hb": "theme head",
+		"/static/styles.css":                  "site styles",
+		"/themes/plain/static/theme.css":      "theme styles",
+	}
+	for name, content := range files {
+		afero.WriteFile(memFs, name, []byte(content), 0644)
+	}
+}
+
+func TestThemeFs(t *testing.T) {
+	setupThemeTest(t)
+
+	tests := []struct {
+		name     string
+		kind     string
+		siteDir  string
+		file     string
+		expected string
+	}{
+		{"site overrides theme", themeTemplates, "/tpl", "/post.html.hb", "site post"},
+		{"theme file", themeTemplates, "/tpl", "/head.html.hb", "theme head"},
+		{"embedded default theme", themeTemplates, "/tpl", "/404.html.hb", "<article>"},
+		{"missing site dir", themeTemplates, "/nope", "/post.html.hb", "theme post"},
+		{"site static", themeStatic, "/static", "/styles.css", "site styles"},
+		{"theme static", themeStatic, "/static", "/theme.css", "theme styles"},
+		{"embedded static", themeStatic, "/static", "/simple.min.css", "body"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := afero.ReadFile(ThemeFs(tt.kind, tt.siteDir), tt.file)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if !strings.Contains(string(content), tt.expected) {
+				t.Errorf("ReadFile() = %.40q, want it to contain %q", content, tt.expected)
+			}
+		})
+	}
+
+	viper.Set("theme", "missing")
+	if themeExists() {
+		t.Error("Expected a theme without a directory not to exist")
+	}
+	if _, err := ThemeFs(themeTemplates, "/tpl").Stat("/head.html.hb"); err == nil {
+		t.Error("Expected a missing theme to fall back to the default theme")
+	}
+}
+
+func TestThemeTemplatesAndStatic(t *testing.T) {
+	setupThemeTest(t)
+	registerHelpersOnce.Do(RegisterTemplateHelpers)
+	RegisterPartials()
+
+	set := partials.Load()
+	for _, name := range []string{"post", "head", "layout"} {
+		if set.templates[name] == nil {
+			t.Errorf("Expected partial %q from the theme chain", name)
+		}
+	}
+	if result, _ := set.templates["post"].Exec(nil); result != "site post" {
+		t.Errorf("Expected the site partial to override the theme, got %q", result)
+	}
+
+	viper.Set("routes.page.templates", []string{"head.html.hb"})
+	result, err := RenderTemplateFiles(GetTemplateFilesFromConfig("routes.page.templates"), map[string]interface{}{})
+	if err != nil || result != "theme head" {
+		t.Errorf("RenderTemplateFiles() = %q, %v, want the theme template", result, err)
+	}
+
+	viper.Set("routes.static.path", "/static")
+	viper.Set("routes.static.handler", "static")
+	viper.Set("routes.static.dir", "/static")
+	router := NewRouter()
+	for file, expected := range map[string]string{
+		"/static/styles.css": "site styles",
+		"/static/theme.css":  "theme styles",
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", file, nil))
+		body, _ := io.ReadAll(rec.Result().Body)
+		if string(body) != expected {
+			t.Errorf("GET %s = %q, want %q", file, body, expected)
+		}
+	}
+}
@@ -16,6 +16,9 @@ type Item struct {
 	Frontmatter map[string]string
 	Date        time.Time
 	RawDate     string
+	Created     time.Time
+	Updated     time.Time
+	LastEditor  string
 	Raw         string
 	Html        string
 	Source      string
@@ -41,5 +44,11 @@ type Author struct {
 	Count int
 }
 
+// Taxonomy is the categories and authors of a set of items, with the number of items of each
+type Taxonomy struct {
+	Categories []Category
+	Authors    []Author
+}
+
 // ActivityPubManager holds the global ActivityPub manager instance
 var ActivityPubManager *activitypub.Manager
@@ -3,8 +3,10 @@ package util
 import (
 	"fmt"
 	"log/slog"
+	"net/url"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -193,3 +195,76 @@ func GetAllPostRoutePatterns() []string {
 
 	return patterns
 }
+
+// GetTagURL returns the full URL of the listing page for a tag, from the first route
+// (by name) whose query selects items by a tag or category path variable
+func GetTagURL(tag string) string {
+	return queryFieldURLOrFallback([]string{"tag", "category"}, tag, "/tag/")
+}
+
+// GetAuthorURL returns the full URL of the listing page for an author, from the first
+// route (by name) whose query selects items by an author path variable
+func GetAuthorURL(author string) string {
+	return queryFieldURLOrFallback([]string{"author"}, author, "/author/")
+}
+
+// LookupTagURL is GetTagURL without the fallback, reporting whether a route lists the tag
+func LookupTagURL(tag string) (string, bool) {
+	return getQueryFieldURL([]string{"tag", "category"}, tag)
+}
+
+// LookupAuthorURL is GetAuthorURL without the fallback, reporting whether a route lists
+// the author
+func LookupAuthorURL(author string) (string, bool) {
+	return getQueryFieldURL([]string{"author"}, author)
+}
+
+func queryFieldURLOrFallback(fields []string, value string, fallbackPrefix string) string {
+	if url, ok := getQueryFieldURL(fields, value); ok {
+		return url
+	}
+	slog.Warn("No route found for query field, using fallback URL pattern", "fields", fields, "value", value)
+	return strings.TrimSuffix(viper.GetString("rooturl"), "/") + fallbackPrefix + url.PathEscape(value)
+}
+
+// getQueryFieldURL finds a posts route whose only path variable is used by one of the
+// given query fields, and substitutes the value into its path
+func getQueryFieldURL(fields []string, value string) (string, bool) {
+	baseURL := strings.TrimSuffix(viper.GetString("rooturl"), "/")
+
+	routeNames := make([]string, 0)
+	for routeName := range viper.GetStringMap("routes") {
+		routeNames = append(routeNames, routeName)
+	}
+	sort.Strings(routeNames)
+
+	re := regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+	for _, routeName := range routeNames {
+		routeConfig := fmt.Sprintf("routes.%s", routeName)
+		if viper.GetString(fmt.Sprintf("%s.handler", routeConfig)) != "posts" {
+			continue
+		}
+
+		routePath := viper.GetString(fmt.Sprintf("%s.path", routeConfig))
+		pathVars := re.FindAllStringSubmatch(routePath, -1)
+		if len(pathVars) != 1 {
+			continue
+		}
+		paramName := pathVars[0][1]
+
+		outConfig := viper.GetStringMap(fmt.Sprintf("%s.out", routeConfig))
+		for _, outVal := range outConfig {
+			outMap, ok := outVal.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, field := range fields {
+				if outMap[field] == fmt.Sprintf("{%s}", paramName) {
+					return baseURL + strings.Replace(routePath, pathVars[0][0], url.PathEscape(value), 1), true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
@@ -320,3 +320,30 @@ func TestGetRoutePatternForRepo(t *testing.T) {
 		})
 	}
 }
+
+func TestGetTagURL(t *testing.T) {
+	viper.Reset()
+	viper.Set("rooturl", "https://example.com/")
+	viper.Set("routes.02_tag.handler", "posts")
+	viper.Set("routes.02_tag.path", "/tag/{tag}")
+	viper.Set("routes.02_tag.out.posts.repo", "posts")
+	viper.Set("routes.02_tag.out.posts.tag", "{tag}")
+	viper.Set("routes.01_topic.handler", "posts")
+	viper.Set("routes.01_topic.path", "/topic/{name}/{page}")
+	viper.Set("routes.01_topic.out.posts.category", "{name}")
+	viper.Set("routes.03_author.handler", "posts")
+	viper.Set("routes.03_author.path", "/by/{who:[a-z]+}")
+	viper.Set("routes.03_author.out.posts.author", "{who}")
+
+	if url := GetTagURL("go lang"); url != "https://example.com/tag/go%20lang" {
+		t.Errorf("GetTagURL() = %q, want the route with only the tag variable", url)
+	}
+	if url := GetAuthorURL("alice"); url != "https://example.com/by/alice" {
+		t.Errorf("GetAuthorURL() = %q, want https://example.com/by/alice", url)
+	}
+
+	viper.Reset()
+	if url := GetAuthorURL("alice"); url != "/author/alice" {
+		t.Errorf("GetAuthorURL() fallback = %q, want /author/alice", url)
+	}
+}
@@ -1,19 +1,22 @@
 package sn
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"embed"
+	"errors"
 	"fmt"
 	"io/fs"
-	"log"
 	"log/slog"
 	"maps"
 	"net/http"
+	"net/url"
 	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-http-utils/etag"
@@ -34,7 +37,13 @@ type FileSystem interface {
 	ReadDir(name string) ([]fs.DirEntry, error)
 }
 
-var router *mux.Router
+// defaultShutdownTimeout is how long the server waits for requests in flight when it shuts
+// down, unless shutdown_timeout is set
+const defaultShutdownTimeout = 30 * time.Second
+
+// router is the router the server answers requests with.  A reload swaps in a new router,
+// and requests already in flight finish with the one they started with.
+var router atomic.Pointer[mux.Router]
 
 type SpacesConfig struct {
 	SpaceName   string
@@ -58,7 +67,16 @@ func debugHandler(w http.ResponseWriter, r *http.Request) {
 	output += fmt.Sprintf("routeName: %s\nrouteConfigLocation: %s\n", routeName, routeConfigLocation)
 	output += "\n"
 
-	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+	templateErrors := TemplateErrors()
+	if len(templateErrors) > 0 {
+		output += "TEMPLATE ERRORS:\n"
+		for filename, err := range templateErrors {
+			output += fmt.Sprintf("%s: %s\n", filename, err)
+		}
+		output += "\n"
+	}
+
+	router.Load().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 		output += fmt.Sprintln("ROUTE: ", route.GetName())
 		pathTemplate, err := route.GetPathTemplate()
 		if err == nil {
@@ -100,12 +118,59 @@ func routeStringValue(r *http.Request, v string) string {
 	return temp
 }
 
+// routeOutQueries returns the item queries of a route, which for a feed or ical route is its
+// feed or ical query
+func routeOutQueries(routeName string) []map[string]interface{} {
+	named := routeNamedQueries(routeName)
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	queries := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		queries = append(queries, named[name])
+	}
+	return queries
+}
+
+// routeNamedQueries returns the item queries of a route by the name of the template variable
+// they fill, or by feed or ical for the query of a feed or ical route
+func routeNamedQueries(routeName string) map[string]map[string]interface{} {
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+	switch viper.GetString(fmt.Sprintf("%s.handler", routeConfigLocation)) {
+	case "feed":
+		return map[string]map[string]interface{}{"feed": feedQuery(routeName)}
+	case "ical":
+		return map[string]map[string]interface{}{"ical": icalQuery(routeName)}
+	}
+	queries := make(map[string]map[string]interface{})
+	for outVarName := range viper.GetStringMap(fmt.Sprintf("%s.out", routeConfigLocation)) {
+		qlocation := fmt.Sprintf("%s.out.%s", routeConfigLocation, outVarName)
+		if _, ok := viper.Get(qlocation).(map[string]interface{}); ok {
+			queries[outVarName] = viper.GetStringMap(qlocation)
+		}
+	}
+	return queries
+}
+
 func templateHandler(w http.ResponseWriter, r *http.Request, routeName string) {
 	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
 
 	templateConfigLocation := fmt.Sprintf("%s.templates", routeConfigLocation)
 	templateFiles := GetTemplateFilesFromConfig(templateConfigLocation)
 
+	cacheKey := ""
+	generation := currentPageGeneration()
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && pageCacheEnabled(routeName) {
+		cacheKey = pageCacheKey(routeName, mux.Vars(r), r.URL.Query())
+		if page := getCachedPage(cacheKey); page != nil {
+			writeCachedPage(w, r, page)
+			return
+		}
+	}
+	deps := make(map[string]bool)
+
 	context := viper.GetStringMap(routeConfigLocation)
 	context["config"] = CopyMap(viper.AllSettings())
 	context["pathvars"] = mux.Vars(r)
@@ -128,12 +193,27 @@ func templateHandler(w http.ResponseWriter, r *http.Request, routeName string) {
 			context[outVarName] = routeStringValue(r, v)
 		default:
 			outvals := maps.Clone(viper.GetStringMap(qlocation))
+			if repo, ok := outvals["repo"].(string); ok {
+				deps[pageDepRepo(routeStringValue(r, repo))] = true
+			} else {
+				deps[pageDepAllRepos] = true
+			}
 			itemResult := ItemsFromOutvals(outvals, context)
 			context[outVarName] = itemResult
 			if len(itemResult.Items) == 0 && outvals["404_on_empty"] != nil {
 				templateHandler(w, r, outvals["404_on_empty"].(string))
 				return
 			}
+			// A single item may choose its own template and layout via frontmatter
+			if outvals["slug"] != nil && len(itemResult.Items) == 1 {
+				itemTemplateFiles, err := ItemTemplateFiles(templateFiles, itemResult.Items[0])
+				if err != nil {
+					slog.Default().Warn("ignoring item template override", "route", routeName, "slug", itemResult.Items[0].Slug, "err", err)
+				} else {
+					templateFiles = itemTemplateFiles
+				}
+				deps[pageDepComments(itemResult.Items[0].Repo, itemResult.Items[0].Slug)] = true
+			}
 		}
 	}
 
@@ -145,20 +225,14 @@ func templateHandler(w http.ResponseWriter, r *http.Request, routeName string) {
 		context["http_status"] = 200
 	}
 
-	rendered, err := RenderTemplateFiles(templateFiles, context)
+	rendered, err := renderTemplateFiles(templateFiles, context, deps)
 	if err != nil {
 		slog.Default().Error("error rendering template", "err", err)
 		rendered = fmt.Sprintf("<div class=\"notification is-danger\">Error rendering template: %s</div>\n", err)
 	}
 
 	// May use context here to set additional headers, as defined by the handler
-	w.Header().Add("Content-Type", context["mime"].(string))
-	w.Header().Add("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-	w.Header().Add("X-Frame-Options", "SAMEORIGIN")
-	w.Header().Add("X-Content-Type-Options", "nosniff")
-	w.Header().Add("Upgrade-Insecure-Requests", "1")
-	w.Header().Add("Referrer-Policy", "strict-origin-when-cross-origin")
-	w.Header().Add("Permissions-Policy", "geolocation=(self), microphone=()")
+	writeRouteHeaders(w, context["mime"].(string))
 	if viper.IsSet(fmt.Sprintf("%s.location", routeConfigLocation)) {
 		context["location"] = viper.GetString(fmt.Sprintf("%s.location", routeConfigLocation))
 		w.Header().Add("location", context["location"].(string))
@@ -188,11 +262,28 @@ func templateHandler(w http.ResponseWriter, r *http.Request, routeName string) {
 		slog.Default().Warn("Unexpected http_status type", "type", fmt.Sprintf("%T", v), "value", v)
 	}
 
+	if cacheKey != "" && err == nil && statusCode == http.StatusOK {
+		page := storeCachedPage(cacheKey, []byte(rendered), context["mime"].(string), deps, generation)
+		writeCachedPage(w, r, page)
+		return
+	}
+
 	w.WriteHeader(statusCode)
 
 	w.Write([]byte(rendered))
 }
 
+// writeRouteHeaders sets the content type and security headers of a rendered route
+func writeRouteHeaders(w http.ResponseWriter, mime string) {
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+	w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Upgrade-Insecure-Requests", "1")
+	w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+	w.Header().Set("Permissions-Policy", "geolocation=(self), microphone=()")
+}
+
 func postHandler(w http.ResponseWriter, r *http.Request) {
 	routeName := mux.CurrentRoute(r).GetName()
 	templateHandler(w, r, routeName)
@@ -213,6 +304,10 @@ func setRootUrl(r *http.Request) {
 	}
 }
 
+// routeHandlers are the handler settings that setupRoutes knows; routes without a handler
+// use the catchall handler
+var routeHandlers = []string{"posts", "json", "sitemap", "robots", "ogimage", "frontend", "static", "upload", "git", "debug", "feed", "websub", "ical", "redirect"}
+
 func setupRoutes(router *mux.Router) {
 	// Register ActivityPub routes
 	if ActivityPubManager != nil && ActivityPubManager.IsEnabled() {
@@ -230,6 +325,14 @@ func setupRoutes(router *mux.Router) {
 		switch viper.GetString(fmt.Sprintf("%s.handler", routeConfigLocation)) {
 		case "posts":
 			router.HandleFunc(routePath, postHandler).Name(routeName)
+		case "json":
+			router.HandleFunc(routePath, jsonHandler).Name(routeName)
+		case "sitemap":
+			router.HandleFunc(routePath, sitemapHandler).Name(routeName)
+		case "robots":
+			router.HandleFunc(routePath, robotsHandler).Name(routeName)
+		case "ogimage":
+			router.HandleFunc(routePath, socialCardHandler).Name(routeName)
 		case "frontend":
 			// This path is outside of auth middleware because it needs to supply basic API data to the frontend
 			router.Path(path.Join(routePath, "api")).Methods("GET").HandlerFunc(dataHandler).Name(routeName + "_api")
@@ -255,8 +358,8 @@ func setupRoutes(router *mux.Router) {
 				file := ConfigPath(fmt.Sprintf("%s.file", routeConfigLocation), OptionallyExist())
 				router.Path(routePath).Handler(customFileServer(Vfs, file)).Name(routeName)
 			} else {
-				dir := ConfigPath(fmt.Sprintf("%s.dir", routeConfigLocation))
-				router.PathPrefix(routePath).Handler(http.StripPrefix(routePath, customDirServer(Vfs, routeName, dir))).Name(routeName)
+				dir := ConfigPath(fmt.Sprintf("%s.dir", routeConfigLocation), OptionallyExist())
+				router.PathPrefix(routePath).Handler(http.StripPrefix(routePath, customDirServer(ThemeFs(themeStatic, dir), routeName, "/"))).Name(routeName)
 			}
 		case "upload":
 			router.HandleFunc(routePath, uploadHandler).Name(routeName)
@@ -265,9 +368,11 @@ func setupRoutes(router *mux.Router) {
 		case "debug":
 			router.HandleFunc(routePath, debugHandler).Name(routeName)
 		case "feed":
-			router.NewRoute().HeadersRegexp("Accept", "rss").Name(routeName).Path(routePath).HandlerFunc(rssHandler)
-			router.NewRoute().HeadersRegexp("Accept", "atom").Name(routeName).Path(routePath).HandlerFunc(atomHandler)
-			router.NewRoute().Name(routeName).Path(routePath).HandlerFunc(rssHandler) // I hate this
+			router.HandleFunc(routePath, feedRouteHandler).Name(routeName)
+		case "websub":
+			router.HandleFunc(routePath, websubHubHandler).Name(routeName)
+		case "ical":
+			router.HandleFunc(routePath, icalHandler).Name(routeName)
 		case "redirect":
 			router.HandleFunc(routePath, func(w http.ResponseWriter, r *http.Request) {
 				to := viper.GetString(fmt.Sprintf("%s.to", routeConfigLocation))
@@ -312,11 +417,99 @@ func LogMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func WebserverStart() {
-	router = mux.NewRouter()
-	router.Use(LogMiddleware)
-	setupRoutes(router)
-	http.Handle("/", etag.Handler(handlers.CompressHandler(router), false))
+// newSiteRequest returns a GET request for a path of the site, addressed to the host of the
+// rooturl, for pages the site renders for itself
+func newSiteRequest(ctx context.Context, requestURI string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Host = "localhost"
+	if root, err := url.Parse(viper.GetString("rooturl")); err == nil && root.Host != "" {
+		req.Host = root.Host
+	}
+	return req, nil
+}
+
+// responseBuffer is an http.ResponseWriter that keeps the response in memory, for pages the
+// site renders for itself
+type responseBuffer struct {
+	Code        int
+	Body        bytes.Buffer
+	header      http.Header
+	wroteHeader bool
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{Code: http.StatusOK, header: make(http.Header)}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	if b.wroteHeader {
+		return
+	}
+	b.Code = code
+	b.wroteHeader = true
+}
+
+// Write adds to the body, detecting its Content-Type as the server would when none is set
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if !b.wroteHeader {
+		if b.header.Get("Content-Type") == "" {
+			b.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		b.WriteHeader(http.StatusOK)
+	}
+	return b.Body.Write(p)
+}
+
+// NewRouter creates the router for the configured routes, without swapping it in for the
+// server's requests
+func NewRouter() *mux.Router {
+	newRouter := mux.NewRouter()
+	setupRoutes(newRouter)
+	return newRouter
+}
+
+// ReloadRouter builds the router for the current configuration, with request logging, and
+// swaps it in for the requests that arrive after it
+func ReloadRouter() {
+	router.Store(serverRouter())
+}
+
+// serverRouter builds the router for the current configuration, with request logging
+func serverRouter() *mux.Router {
+	newRouter := mux.NewRouter()
+	newRouter.Use(LogMiddleware)
+	setupRoutes(newRouter)
+	return newRouter
+}
+
+// WebserverStart serves the site until the context is done, then stops accepting connections
+// and waits up to shutdown_timeout for the requests in flight to finish
+func WebserverStart(ctx context.Context) error {
+	configLock.RLock()
+	ReloadRouter()
+	handler := etag.Handler(handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		configLock.RLock()
+		defer configLock.RUnlock()
+		router.Load().ServeHTTP(w, r)
+	})), false)
+
+	var servers []*http.Server
+	errs := make(chan error, 2)
+	serve := func(server *http.Server, listen func() error) {
+		servers = append(servers, server)
+		go func() {
+			if err := listen(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
 
 	if viper.IsSet("ssldomains") && viper.GetBool("use_ssl") {
 		certManager := autocert.Manager{
@@ -326,18 +519,44 @@ func WebserverStart() {
 		}
 
 		server := &http.Server{
-			Addr: ":https",
+			Addr:    ":https",
+			Handler: handler,
 			TLSConfig: &tls.Config{
 				GetCertificate: certManager.GetCertificate,
 			},
 		}
 
-		go http.ListenAndServe(":http", certManager.HTTPHandler(nil))
+		challenges := &http.Server{Addr: ":http", Handler: certManager.HTTPHandler(nil)}
+		serve(challenges, challenges.ListenAndServe)
+		serve(server, func() error { return server.ListenAndServeTLS("", "") })
 		slog.Default().Info("TLS HTTPS server started", "domains", viper.GetStringSlice("ssldomains"))
-		log.Fatal(server.ListenAndServeTLS("", ""))
 	} else {
+		server := &http.Server{Addr: fmt.Sprintf(":%d", viper.GetInt("port")), Handler: handler}
+		serve(server, server.ListenAndServe)
 		slog.Default().Info("HTTP server started", "port", viper.GetInt("port"),
 			"host", fmt.Sprintf("http://localhost:%d", viper.GetInt("port")))
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", viper.GetInt("port")), nil))
 	}
+	configLock.RUnlock()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		slog.Info("Shutting down the server")
+	}
+
+	configLock.RLock()
+	timeout := viper.GetDuration("shutdown_timeout")
+	configLock.RUnlock()
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+			slog.Error("Error while shutting down the server", "addr", server.Addr, "error", shutdownErr)
+		}
+	}
+	return err
 }
@@ -0,0 +1,415 @@
+package sn
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+// Leases of the built-in hub: the lease of a subscription that does not ask for one, and the
+// longest lease granted
+const (
+	websubLeaseSeconds    = 10 * 24 * 60 * 60
+	websubMaxLeaseSeconds = 30 * 24 * 60 * 60
+)
+
+// websubMaxSecret is the longest hub.secret the WebSub spec allows
+const websubMaxSecret = 200
+
+// websubDelay is how long a notification waits, so that the changes from one edit, such as a
+// REST request and the reload of the file it wrote, are sent once
+var websubDelay = 2 * time.Second
+
+var websubClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	websubPending     = make(map[string]*time.Timer)
+	websubPendingLock sync.Mutex
+)
+
+// websubSubscription is a callback subscribed to a topic of the built-in hub
+type websubSubscription struct {
+	Topic    string
+	Callback string
+	Secret   string
+	Expires  time.Time
+}
+
+// WebSubHubURL returns the hub that feeds advertise: the websub.hub setting, or else the route
+// with the websub handler, or "" when there is neither
+func WebSubHubURL() string {
+	if hub := viper.GetString("websub.hub"); hub != "" {
+		return hub
+	}
+	if routeName, ok := websubHubRoute(); ok {
+		return rootURL() + viper.GetString(fmt.Sprintf("routes.%s.path", routeName))
+	}
+	return ""
+}
+
+// websubHubRoute returns the name of the route of the built-in hub, which is not used when
+// websub.hub names an external hub
+func websubHubRoute() (string, bool) {
+	if viper.GetString("websub.hub") != "" {
+		return "", false
+	}
+	for _, routeName := range sortedRouteNames() {
+		if viper.GetString(fmt.Sprintf("routes.%s.handler", routeName)) == "websub" {
+			return routeName, true
+		}
+	}
+	return "", false
+}
+
+// NotifyWebSub tells the hub that the feeds that can list an item have changed
+func NotifyWebSub(item Item) {
+	if WebSubHubURL() == "" {
+		return
+	}
+	for _, topic := range feedTopics(item) {
+		scheduleWebSub(topic)
+	}
+}
+
+// feedTopics returns the URLs of the feeds that can list an item: the feeds of its repo, with
+// their {repo}, {tag} and {author} path variables set to the item's
+func feedTopics(item Item) []string {
+	topics := make([]string, 0)
+	for _, routeName := range sortedRouteNames() {
+		routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+		if viper.GetString(fmt.Sprintf("%s.handler", routeConfigLocation)) != "feed" {
+			continue
+		}
+		if repo, ok := feedQuery(routeName)["repo"].(string); ok && !routeVariable.MatchString(repo) && repo != item.Repo {
+			continue
+		}
+
+		paths := []string{viper.GetString(fmt.Sprintf("%s.path", routeConfigLocation))}
+		for _, match := range routeVariable.FindAllStringSubmatch(paths[0], -1) {
+			var values []string
+			switch match[1] {
+			case "repo":
+				values = []string{item.Repo}
+			case "tag":
+				values = item.Categories
+			case "author":
+				values = item.Authors
+			}
+			expanded := make([]string, 0, len(paths)*len(values))
+			for _, feedPath := range paths {
+				for _, value := range values {
+					expanded = append(expanded, strings.Replace(feedPath, match[0], url.PathEscape(value), 1))
+				}
+			}
+			paths = expanded
+		}
+		for _, feedPath := range paths {
+			topics = append(topics, rootURL()+feedPath)
+		}
+	}
+	return topics
+}
+
+// scheduleWebSub publishes a topic after websubDelay, once for every notification in that time
+func scheduleWebSub(topic string) {
+	websubPendingLock.Lock()
+	defer websubPendingLock.Unlock()
+	if timer, ok := websubPending[topic]; ok {
+		timer.Reset(websubDelay)
+		return
+	}
+	websubPending[topic] = time.AfterFunc(websubDelay, func() {
+		websubPendingLock.Lock()
+		delete(websubPending, topic)
+		websubPendingLock.Unlock()
+		publishWebSub(topic)
+	})
+}
+
+// publishWebSub sends a topic to its subscribers through the built-in hub, or pings the
+// external hub to fetch it
+func publishWebSub(topic string) {
+	configLock.RLock()
+	_, builtIn := websubHubRoute()
+	hub := WebSubHubURL()
+	configLock.RUnlock()
+	if builtIn {
+		distributeWebSub(topic)
+		return
+	}
+
+	resp, err := websubClient.PostForm(hub, url.Values{"hub.mode": {"publish"}, "hub.url": {topic}, "hub.topic": {topic}})
+	if err != nil {
+		slog.Error("Error notifying WebSub hub", "hub", hub, "topic", topic, "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Error("WebSub hub refused the notification", "hub", hub, "topic", topic, "status", resp.StatusCode)
+		return
+	}
+	slog.Info("Notified WebSub hub", "hub", hub, "topic", topic)
+}
+
+// websubHubHandler is the built-in hub.  Subscription requests are answered with 202 and the
+// intent of the subscriber is verified afterwards.  A publish request for one of the site's
+// feeds sends it to the subscribers.
+func websubHubHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "The WebSub hub accepts POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	routeName := mux.CurrentRoute(r).GetName()
+
+	switch mode := r.PostForm.Get("hub.mode"); mode {
+	case "subscribe", "unsubscribe":
+		subscription, err := newWebSubSubscription(routeName, r.PostForm)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		go func() {
+			if err := verifyWebSubIntent(mode, subscription); err != nil {
+				slog.Warn("WebSub subscriber did not verify its intent", "mode", mode, "topic", subscription.Topic, "callback", subscription.Callback, "error", err)
+				return
+			}
+			if mode == "subscribe" {
+				err = saveWebSubSubscription(subscription)
+			} else {
+				err = deleteWebSubSubscription(subscription.Topic, subscription.Callback)
+			}
+			if err != nil {
+				slog.Error("Error saving WebSub subscription", "mode", mode, "topic", subscription.Topic, "error", err)
+				return
+			}
+			slog.Info("WebSub subscription verified", "mode", mode, "topic", subscription.Topic, "callback", subscription.Callback)
+		}()
+	case "publish":
+		topic := r.PostForm.Get("hub.url")
+		if topic == "" {
+			topic = r.PostForm.Get("hub.topic")
+		}
+		if !isWebSubTopic(topic) {
+			http.Error(w, "hub.url is not a feed of this site", http.StatusBadRequest)
+			return
+		}
+		scheduleWebSub(topic)
+	default:
+		http.Error(w, "hub.mode must be subscribe, unsubscribe or publish", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// newWebSubSubscription validates a subscription request.  The lease is the one requested,
+// limited to the route's max_lease_seconds, or else the route's lease_seconds.
+func newWebSubSubscription(routeName string, form url.Values) (websubSubscription, error) {
+	routeConfigLocation := fmt.Sprintf("routes.%s", routeName)
+	subscription := websubSubscription{
+		Topic:    form.Get("hub.topic"),
+		Callback: form.Get("hub.callback"),
+		Secret:   form.Get("hub.secret"),
+	}
+
+	callback, err := url.Parse(subscription.Callback)
+	if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+		return subscription, fmt.Errorf("hub.callback must be an http or https URL")
+	}
+	if !isWebSubTopic(subscription.Topic) {
+		return subscription, fmt.Errorf("hub.topic is not a feed of this site")
+	}
+	if len(subscription.Secret) >= websubMaxSecret {
+		return subscription, fmt.Errorf("hub.secret must be shorter than %d bytes", websubMaxSecret)
+	}
+
+	lease := viper.GetInt(fmt.Sprintf("%s.lease_seconds", routeConfigLocation))
+	if lease <= 0 {
+		lease = websubLeaseSeconds
+	}
+	maxLease := viper.GetInt(fmt.Sprintf("%s.max_lease_seconds", routeConfigLocation))
+	if maxLease <= 0 {
+		maxLease = websubMaxLeaseSeconds
+	}
+	if requested, err := strconv.Atoi(form.Get("hub.lease_seconds")); err == nil && requested > 0 {
+		lease = requested
+	}
+	subscription.Expires = time.Now().Add(time.Duration(min(lease, maxLease)) * time.Second)
+	return subscription, nil
+}
+
+// isWebSubTopic reports whether a URL is one of the site's feeds
+func isWebSubTopic(topic string) bool {
+	req, ok := topicRequest(topic)
+	topicRouter := router.Load()
+	if !ok || topicRouter == nil {
+		return false
+	}
+	var match mux.RouteMatch
+	return topicRouter.Match(req, &match) && match.Route != nil &&
+		viper.GetString(fmt.Sprintf("routes.%s.handler", match.Route.GetName())) == "feed"
+}
+
+// topicRequest returns a request for a URL of the site
+func topicRequest(topic string) (*http.Request, bool) {
+	requestURI, ok := strings.CutPrefix(topic, rootURL())
+	if !ok || !strings.HasPrefix(requestURI, "/") {
+		return nil, false
+	}
+	if _, err := url.ParseRequestURI(requestURI); err != nil {
+		return nil, false
+	}
+	req, err := newSiteRequest(context.Background(), requestURI)
+	return req, err == nil
+}
+
+// verifyWebSubIntent asks the callback to echo a challenge, confirming that it asked to
+// subscribe or unsubscribe
+func verifyWebSubIntent(mode string, subscription websubSubscription) error {
+	challengeBytes := make([]byte, 16)
+	if _, err := rand.Read(challengeBytes); err != nil {
+		return err
+	}
+	challenge := hex.EncodeToString(challengeBytes)
+
+	callback, err := url.Parse(subscription.Callback)
+	if err != nil {
+		return err
+	}
+	query := callback.Query()
+	query.Set("hub.mode", mode)
+	query.Set("hub.topic", subscription.Topic)
+	query.Set("hub.challenge", challenge)
+	if mode == "subscribe" {
+		query.Set("hub.lease_seconds", strconv.Itoa(int(time.Until(subscription.Expires).Round(time.Second).Seconds())))
+	}
+	callback.RawQuery = query.Encode()
+
+	resp, err := websubClient.Get(callback.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, int64(len(challenge))+1))
+	if err != nil {
+		return err
+	}
+	if string(body) != challenge {
+		return fmt.Errorf("callback did not echo the challenge")
+	}
+	return nil
+}
+
+// distributeWebSub sends the current content of a topic to its subscribers, signed with
+// their secret, and ends the subscriptions whose lease expired or whose callback is gone
+func distributeWebSub(topic string) {
+	// The topic is rendered, and the hub named, with one configuration; a reload waits for
+	// the rendering but not for the deliveries
+	configLock.RLock()
+	req, ok := topicRequest(topic)
+	topicRouter := router.Load()
+	if !ok || topicRouter == nil {
+		configLock.RUnlock()
+		return
+	}
+	rec := newResponseBuffer()
+	topicRouter.ServeHTTP(rec, req)
+	hub := WebSubHubURL()
+	configLock.RUnlock()
+	if rec.Code != http.StatusOK {
+		slog.Error("Error rendering WebSub topic", "topic", topic, "status", rec.Code)
+		return
+	}
+	content := rec.Body.Bytes()
+
+	if _, err := db.Exec("DELETE FROM websub_subscriptions WHERE expires <= ?", time.Now().Unix()); err != nil {
+		slog.Error("Error removing expired WebSub subscriptions", "error", err)
+	}
+	subscriptions, err := webSubSubscriptions(topic)
+	if err != nil {
+		slog.Error("Error listing WebSub subscriptions", "topic", topic, "error", err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		delivery, err := http.NewRequest(http.MethodPost, subscription.Callback, strings.NewReader(string(content)))
+		if err != nil {
+			continue
+		}
+		delivery.Header.Set("Content-Type", rec.Header().Get("Content-Type"))
+		delivery.Header.Set("Link", fmt.Sprintf(`<%s>; rel="hub", <%s>; rel="self"`, hub, topic))
+		if subscription.Secret != "" {
+			mac := hmac.New(sha256.New, []byte(subscription.Secret))
+			mac.Write(content)
+			delivery.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		resp, err := websubClient.Do(delivery)
+		if err != nil {
+			slog.Warn("Error delivering WebSub content", "topic", topic, "callback", subscription.Callback, "error", err)
+			continue
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusGone:
+			deleteWebSubSubscription(topic, subscription.Callback)
+		case resp.StatusCode >= 300:
+			slog.Warn("WebSub subscriber refused content", "topic", topic, "callback", subscription.Callback, "status", resp.StatusCode)
+		}
+	}
+}
+
+func saveWebSubSubscription(subscription websubSubscription) error {
+	_, err := db.Exec(`INSERT INTO websub_subscriptions (topic, callback, secret, expires, created) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (topic, callback) DO UPDATE SET secret = excluded.secret, expires = excluded.expires`,
+		subscription.Topic, subscription.Callback, subscription.Secret, subscription.Expires.Unix(), time.Now().Unix())
+	return err
+}
+
+func deleteWebSubSubscription(topic string, callback string) error {
+	_, err := db.Exec("DELETE FROM websub_subscriptions WHERE topic = ? AND callback = ?", topic, callback)
+	return err
+}
+
+// webSubSubscriptions returns the subscriptions to a topic whose lease has not expired
+func webSubSubscriptions(topic string) ([]websubSubscription, error) {
+	rows, err := db.Query("SELECT topic, callback, secret, expires FROM websub_subscriptions WHERE topic = ? AND expires > ? ORDER BY id", topic, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := make([]websubSubscription, 0)
+	for rows.Next() {
+		var subscription websubSubscription
+		var expires int64
+		if err := rows.Scan(&subscription.Topic, &subscription.Callback, &subscription.Secret, &expires); err != nil {
+			return nil, err
+		}
+		subscription.Expires = time.Unix(expires, 0)
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
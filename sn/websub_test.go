@@ -0,0 +1,204 @@
+package sn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+func setupWebSubTest(t *testing.T) *mux.Router {
+	t.Helper()
+	setupLoaderTest(t, map[string]string{
+		"/blog/one.md": "---\ntitle: One\ndate: 2024-01-01\nauthors: [alice]\ntags: [go]\n---\n\nFirst post.",
+	})
+	DBLoadRepo("blog")
+
+	viper.Set("rooturl", "https://example.com/")
+	viper.Set("routes.feed.path", "/feed.xml")
+	viper.Set("routes.feed.handler", "feed")
+	viper.Set("routes.feed.feed", map[string]interface{}{"repo": "blog"})
+	viper.Set("routes.websub.path", "/_/websub")
+	viper.Set("routes.websub.handler", "websub")
+
+	origRouter, origDelay := router.Load(), websubDelay
+	websubDelay = 0
+	t.Cleanup(func() {
+		router.Store(origRouter)
+		websubDelay = origDelay
+	})
+	// The hub checks topics against the router that serves the site
+	router.Store(NewRouter())
+	return router.Load()
+}
+
+// waitFor polls a condition, as the hub verifies and distributes after it has answered
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebSubHub(t *testing.T) {
+	router := setupWebSubTest(t)
+
+	type delivery struct {
+		body      []byte
+		signature string
+		link      string
+	}
+	verified := make(chan url.Values, 1)
+	delivered := make(chan delivery, 1)
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			verified <- r.URL.Query()
+			io.WriteString(w, r.URL.Query().Get("hub.challenge"))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		delivered <- delivery{body: body, signature: r.Header.Get("X-Hub-Signature"), link: r.Header.Get("Link")}
+		// A gone subscriber is unsubscribed
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer subscriber.Close()
+
+	form := url.Values{
+		"hub.mode":          {"subscribe"},
+		"hub.topic":         {"https://example.com/feed.xml"},
+		"hub.callback":      {subscriber.URL + "/callback"},
+		"hub.secret":        {"sekrit"},
+		"hub.lease_seconds": {"3600"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/_/websub", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Subscribe status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+
+	query := <-verified
+	if query.Get("hub.mode") != "subscribe" || query.Get("hub.topic") != "https://example.com/feed.xml" || query.Get("hub.challenge") == "" {
+		t.Errorf("Unexpected verification query %v", query)
+	}
+	if lease := query.Get("hub.lease_seconds"); lease != "3600" {
+		t.Errorf("Verified lease = %s, want 3600", lease)
+	}
+	waitFor(t, "the subscription", func() bool {
+		subscriptions, _ := webSubSubscriptions("https://example.com/feed.xml")
+		return len(subscriptions) == 1
+	})
+
+	NotifyWebSub(Item{Repo: "blog", Slug: "one", Categories: []string{"go"}, Authors: []string{"alice"}})
+
+	select {
+	case got := <-delivered:
+		mac := hmac.New(sha256.New, []byte("sekrit"))
+		mac.Write(got.body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.signature != want {
+			t.Errorf("X-Hub-Signature = %q, want %q", got.signature, want)
+		}
+		if !strings.Contains(string(got.body), "<title>One</title>") {
+			t.Errorf("Expected the feed to be delivered, got %s", got.body)
+		}
+		if want := `<https://example.com/_/websub>; rel="hub", <https://example.com/feed.xml>; rel="self"`; got.link != want {
+			t.Errorf("Link = %q, want %q", got.link, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the feed to be delivered")
+	}
+
+	waitFor(t, "the gone subscriber to be removed", func() bool {
+		subscriptions, _ := webSubSubscriptions("https://example.com/feed.xml")
+		return len(subscriptions) == 0
+	})
+}
+
+func TestWebSubHubRejects(t *testing.T) {
+	router := setupWebSubTest(t)
+
+	tests := []struct {
+		name string
+		form url.Values
+	}{
+		{"unknown mode", url.Values{"hub.mode": {"list"}}},
+		{"other site", url.Values{"hub.mode": {"subscribe"}, "hub.topic": {"https://example.org/feed.xml"}, "hub.callback": {"https://reader.example/cb"}}},
+		{"not a feed", url.Values{"hub.mode": {"subscribe"}, "hub.topic": {"https://example.com/_/websub"}, "hub.callback": {"https://reader.example/cb"}}},
+		{"bad callback", url.Values{"hub.mode": {"subscribe"}, "hub.topic": {"https://example.com/feed.xml"}, "hub.callback": {"ftp://reader.example/cb"}}},
+		{"long secret", url.Values{"hub.mode": {"subscribe"}, "hub.topic": {"https://example.com/feed.xml"}, "hub.callback": {"https://reader.example/cb"}, "hub.secret": {strings.Repeat("x", websubMaxSecret)}}},
+		{"publish elsewhere", url.Values{"hub.mode": {"publish"}, "hub.url": {"https://example.org/feed.xml"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/_/websub", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("Status = %d, want 400", rec.Code)
+			}
+		})
+	}
+}
+
+func TestWebSubFeedLinks(t *testing.T) {
+	router := setupWebSubTest(t)
+	viper.Set("routes.json.path", "/feed.json")
+	viper.Set("routes.json.handler", "feed")
+	viper.Set("routes.json.feed", map[string]interface{}{"repo": "blog"})
+	viper.Set("routes.tag.path", "/tag/{tag}/feed.xml")
+	viper.Set("routes.tag.handler", "feed")
+	viper.Set("routes.tag.feed", map[string]interface{}{"repo": "blog"})
+	viper.Set("routes.other.path", "/other.xml")
+	viper.Set("routes.other.handler", "feed")
+	viper.Set("routes.other.feed", map[string]interface{}{"repo": "notes"})
+	router = NewRouter()
+
+	for path, want := range map[string]string{
+		"/feed.xml":  `<atom:link href="https://example.com/_/websub" rel="hub"></atom:link>`,
+		"/feed.json": `"url": "https://example.com/_/websub"`,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: expected %s in %s", path, want, rec.Body)
+		}
+		if links := rec.Header().Values("Link"); !slices.Contains(links, `<https://example.com/_/websub>; rel="hub"`) {
+			t.Errorf("%s: expected a hub Link header, got %v", path, links)
+		}
+	}
+
+	topics := feedTopics(Item{Repo: "blog", Categories: []string{"go", "web dev"}})
+	want := []string{
+		"https://example.com/feed.xml",
+		"https://example.com/feed.json",
+		"https://example.com/tag/go/feed.xml",
+		"https://example.com/tag/web%20dev/feed.xml",
+	}
+	slices.Sort(topics)
+	slices.Sort(want)
+	if !slices.Equal(topics, want) {
+		t.Errorf("feedTopics = %v, want %v", topics, want)
+	}
+
+	viper.Set("websub.hub", "https://hub.example/")
+	if hub := WebSubHubURL(); hub != "https://hub.example/" {
+		t.Errorf("WebSubHubURL = %q, want the configured hub", hub)
+	}
+}